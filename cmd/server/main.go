@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,42 +12,58 @@ import (
 	"flowhook/internal/config"
 	"flowhook/internal/db"
 	"flowhook/internal/handlers"
+	"flowhook/internal/logger"
+	"flowhook/internal/metrics"
 	"flowhook/internal/middleware"
+	"flowhook/internal/tracing"
 )
 
 func main() {
 	config.Load()
+	logger.Init()
 	
 	// Initialize database
 	if err := db.Init(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		logger.Fatal("Failed to initialize database", "error", err)
 	}
 	defer db.Close()
 
-	// Run migrations
 	ctx := context.Background()
-	if err := db.RunMigrations(ctx); err != nil {
-		log.Printf("Warning: Failed to run migrations: %v", err)
-		// Check if tables already exist
-		var exists bool
-		err = db.Pool.QueryRow(ctx, `
-			SELECT EXISTS (
-				SELECT FROM information_schema.tables 
-				WHERE table_schema = 'public' 
-				AND table_name = 'endpoints'
-			)
-		`).Scan(&exists)
-		if err != nil || !exists {
-			log.Fatalf("Database not initialized. Please run migrations manually.")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, os.Args[2:]); err != nil {
+			logger.Fatal("Migrate command failed", "error", err)
 		}
-		log.Println("Tables already exist, skipping migrations")
+		return
 	}
 
+	// Run migrations, or with AUTO_MIGRATE=false only check they are applied.
+	// Either way the server refuses to start on a modified migration.
+	if config.AppConfig.AutoMigrate {
+		if err := db.RunMigrations(ctx); err != nil {
+			logger.Fatal("Failed to run migrations", "error", err)
+		}
+	} else if err := db.CheckMigrations(ctx); err != nil {
+		logger.Fatal("Database schema is not up to date, run \"migrate up\"", "error", err)
+	}
+
+	shutdownTracing, err := tracing.Init(ctx)
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing", "error", err)
+	}
+
+	// Background workers run until shutdown
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	handlers.StartRetentionWorker(workerCtx)
+	handlers.StartDeliveryStatsWorker(workerCtx)
+	handlers.StartBulkReplayWorker(workerCtx)
+	handlers.StartExportJobWorker(workerCtx)
+
 	// Setup routes
 	mux := http.NewServeMux()
 
-	// Apply compression middleware to all routes
-	handler := middleware.GzipMiddleware(mux)
+	// Apply compression middleware to all routes, traced per route and
+	// logged with a correlation ID per request
+	handler := middleware.GzipMiddleware(tracing.Middleware(mux, logger.Middleware(mux)))
 
 	// CORS middleware with origin validation
 	corsMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
@@ -65,7 +80,7 @@ func main() {
 					} else {
 						// Log rejected origin for debugging (only in development)
 						if config.AppConfig.Environment == "development" {
-							log.Printf("CORS: Origin '%s' not in allowed list: %v", origin, config.AppConfig.AllowedOrigins)
+							logger.Debug("CORS origin not in allowed list", "origin", origin, "allowed_origins", config.AppConfig.AllowedOrigins)
 						}
 					}
 					// If validation fails, allowedOrigin remains empty (no CORS header)
@@ -83,8 +98,8 @@ func main() {
 					w.Header().Set("Access-Control-Allow-Credentials", "true")
 				}
 				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token")
-				w.Header().Set("Access-Control-Expose-Headers", "X-CSRF-Token")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token, X-Request-ID")
+				w.Header().Set("Access-Control-Expose-Headers", "X-CSRF-Token, X-Request-ID, X-Next-Cursor, X-Prev-Cursor")
 				w.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
 				w.WriteHeader(http.StatusOK)
 				return
@@ -95,8 +110,8 @@ func main() {
 				w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
 				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token")
-				w.Header().Set("Access-Control-Expose-Headers", "X-CSRF-Token")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token, X-Request-ID")
+				w.Header().Set("Access-Control-Expose-Headers", "X-CSRF-Token, X-Request-ID, X-Next-Cursor, X-Prev-Cursor")
 			}
 
 			next(w, r)
@@ -107,7 +122,7 @@ func main() {
 	csrfMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
 		if config.AppConfig != nil && config.AppConfig.CSRFEnabled {
 			// Exempt webhook capture endpoint and health checks
-			exemptPaths := []string{"/e/", "/health", "/ready", "/api/v1/metrics"}
+			exemptPaths := []string{"/e/", "/health", "/ready", "/metrics", "/api/v1/metrics"}
 			return middleware.CSRFExemptMiddleware(exemptPaths, next)
 		}
 		return next
@@ -117,6 +132,7 @@ func main() {
 	mux.HandleFunc("/health", handlers.HealthCheck)
 	mux.HandleFunc("/ready", handlers.ReadyCheck)
 	mux.HandleFunc("/api/v1/metrics", corsMiddleware(handlers.GetMetrics))
+	mux.Handle("/metrics", metrics.Handler())
 	mux.HandleFunc("/api/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./api/openapi.yaml")
 	})
@@ -151,6 +167,14 @@ func main() {
 			} else if r.Method == http.MethodPut {
 				handlers.UpdateRetentionPolicy(w, r)
 			}
+		} else if strings.HasSuffix(r.URL.Path, "/archives") {
+			handlers.GetRequestArchives(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/redaction") {
+			if r.Method == http.MethodGet {
+				handlers.GetRedactionPolicy(w, r)
+			} else if r.Method == http.MethodPut {
+				handlers.UpdateRedactionPolicy(w, r)
+			}
 		} else if strings.HasSuffix(r.URL.Path, "/templates") {
 			if r.Method == http.MethodPost {
 				handlers.CreateRequestTemplate(w, r)
@@ -169,11 +193,49 @@ func main() {
 			} else if r.Method == http.MethodGet {
 				handlers.GetTransformations(w, r)
 			}
+		} else if strings.HasSuffix(r.URL.Path, "/export") {
+			handlers.ExportEndpointRequests(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/import") {
+			handlers.ImportRequests(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/bulk-replays") {
+			if r.Method == http.MethodPost {
+				handlers.CreateBulkReplay(w, r)
+			} else if r.Method == http.MethodGet {
+				handlers.GetBulkReplays(w, r)
+			}
+		} else if strings.HasSuffix(r.URL.Path, "/exports") {
+			if r.Method == http.MethodPost {
+				handlers.CreateExportJob(w, r)
+			} else if r.Method == http.MethodGet {
+				handlers.GetExportJobs(w, r)
+			}
 		} else {
 			handlers.GetEndpointBySlug(w, r)
 		}
 	}))
 
+	mux.HandleFunc("/api/v1/bulk-replays/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/report") {
+			handlers.GetBulkReplayReport(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/pause") || strings.HasSuffix(r.URL.Path, "/resume") || strings.HasSuffix(r.URL.Path, "/cancel") {
+			handlers.ControlBulkReplay(w, r)
+		} else {
+			handlers.GetBulkReplay(w, r)
+		}
+	}))
+
+	mux.HandleFunc("/api/v1/exports/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/download") {
+			handlers.DownloadExportJob(w, r)
+		} else if r.Method == http.MethodDelete {
+			handlers.DeleteExportJob(w, r)
+		} else {
+			handlers.GetExportJob(w, r)
+		}
+	}))
+
+	mux.HandleFunc("/api/v1/archives/", corsMiddleware(handlers.RestoreRequestArchive))
+
 	mux.HandleFunc("/api/v1/templates/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/send") {
 			handlers.SendTemplateRequest(w, r)
@@ -212,6 +274,18 @@ func main() {
 		}
 	}))
 
+	mux.HandleFunc("/api/v1/diff", corsMiddleware(handlers.DiffResponses))
+
+	mux.HandleFunc("/api/v1/replays/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/attempts") {
+			handlers.GetReplayAttempts(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/rerun") {
+			handlers.RerunReplay(w, r)
+		} else {
+			handlers.GetReplay(w, r)
+		}
+	}))
+
 	mux.HandleFunc("/api/v1/requests/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/replay") {
 			handlers.ReplayRequest(w, r)
@@ -229,6 +303,8 @@ func main() {
 	mux.HandleFunc("/api/v1/forwarding-rules/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/timeline") {
 			handlers.GetRuleDeliveryTimeline(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/shadow-report") {
+			handlers.GetShadowReport(w, r)
 		} else if r.Method == http.MethodPut {
 			handlers.UpdateForwardingRule(w, r)
 		} else if r.Method == http.MethodDelete {
@@ -250,9 +326,11 @@ func main() {
 		}
 	}))
 	mux.HandleFunc("/api/v1/realtime", corsMiddleware(handlers.RealtimeHandler))
+	mux.HandleFunc("/api/v1/encryption", corsMiddleware(handlers.GetEncryptionStatus))
+	mux.HandleFunc("/api/v1/encryption/rotate", corsMiddleware(handlers.RotateEncryptionKeys))
 
 	// Webhook capture endpoint
-	mux.HandleFunc("/e/", corsMiddleware(handlers.CaptureHandler))
+	mux.HandleFunc("/e/", corsMiddleware(metrics.InstrumentCapture(handlers.CaptureHandler)))
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -270,9 +348,9 @@ func main() {
 
 	// Graceful shutdown
 	go func() {
-		log.Printf("Server starting on port %s", port)
+		logger.Info("Server starting", "port", port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+			logger.Fatal("Server failed", "error", err)
 		}
 	}()
 
@@ -281,14 +359,18 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
+	logger.Info("Shutting down server...")
+	stopWorkers()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		logger.Fatal("Server forced to shutdown", "error", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Warn("Failed to flush traces", "error", err)
 	}
 
-	log.Println("Server exited")
+	logger.Info("Server exited")
 }
 
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"flowhook/internal/db"
+)
+
+const migrateUsage = `usage: server migrate <command>
+
+commands:
+  up          apply pending migrations
+  down [n]    revert the last n applied migrations (default 1)
+  status      list migrations and whether they are applied
+
+Migrations marked as needing a maintenance window, such as
+021_time_partitioning, lock the tables they change until they finish. Stop
+traffic to the servers before running "up" when one is pending; "status" lists
+them as "pending (maintenance)".`
+
+// runMigrate runs the migrate subcommand
+func runMigrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		statuses, err := db.MigrationStatuses(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.AppliedAt == nil && s.Maintenance != "" {
+				fmt.Fprintf(os.Stderr, "migration %03d_%s needs a maintenance window: %s\n", s.Version, s.Name, s.Maintenance)
+			}
+		}
+		return db.RunMigrations(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("down takes a positive number of migrations, got %q", args[1])
+			}
+			steps = n
+		}
+		return db.RollbackMigrations(ctx, steps)
+	case "status":
+		statuses, err := db.MigrationStatuses(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range statuses {
+			status, appliedAt := "pending", ""
+			if s.Maintenance != "" {
+				status = "pending (maintenance)"
+			}
+			if s.AppliedAt != nil {
+				status, appliedAt = "applied", s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			if s.Drifted {
+				status = "modified"
+			} else if s.Missing {
+				status = "unknown"
+			}
+			fmt.Fprintf(w, "%03d\t%s\t%s\t%s\n", s.Version, s.Name, status, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+	}
+}
@@ -13,8 +13,26 @@ type Config struct {
 	LogLevel     string
 	MaxBodySize  int64
 	CleanupInterval int
+	StatsRefreshInterval int // Minutes between checks for a delivery_stats refresh due
+	AutoMigrate  bool // Apply pending migrations on startup
 	CSRFEnabled  bool
 	AllowedOrigins []string
+	OutboundAllowedSchemes []string // Schemes forwarding, replay and template sends may use
+	OutboundAllowPrivate   bool     // Allow loopback, private and link-local destinations
+	OutboundAllowlist      []string // Hosts, IPs or CIDRs exempt from the destination checks
+	EncryptionKey          string   // Base64 AES-256 master key for data encrypted at rest
+	EncryptionPreviousKeys []string // Retired master keys, still accepted for decryption
+	EncryptionKeyringFile  string   // JSON keyring used instead of the keys above
+	RetentionDefaultDays        int   // Age limit for endpoints without a retention policy, 0 for none
+	RetentionDefaultMaxRequests int   // Request count limit for endpoints without a policy, 0 for none
+	RetentionDefaultMaxBytes    int64 // Body size limit for endpoints without a policy, 0 for none
+	RetentionDefaultArchive     bool  // Archive requests removed by the default policy
+	ExportRetentionHours int // Hours a finished export job and its file are kept
+	MetricsEndpointLabel string // Endpoint label of /metrics series: slug, id or none
+	MetricsMaxLabelValues int   // Distinct endpoints and rules labelled before the rest become "other"
+	TracingExporter    string  // Span exporter: none, otlp, stdout or file
+	TracingFile        string  // File the file exporter appends spans to
+	TracingSampleRatio float64 // Share of traces sampled when the sender has not decided
 }
 
 var AppConfig *Config
@@ -37,11 +55,40 @@ func Load() {
 		LogLevel:     getEnv("LOG_LEVEL", "info"),
 		MaxBodySize:  int64(getEnvInt("MAX_BODY_SIZE", 10*1024*1024)), // 10MB default
 		CleanupInterval: getEnvInt("CLEANUP_INTERVAL", 60), // 60 minutes default
+		StatsRefreshInterval: getEnvInt("STATS_REFRESH_INTERVAL", 5), // 5 minutes default
+		AutoMigrate:  getEnv("AUTO_MIGRATE", "true") == "true",
 		CSRFEnabled:  csrfEnabled,
 		AllowedOrigins: allowedOrigins,
+		OutboundAllowedSchemes: splitList(getEnv("OUTBOUND_ALLOWED_SCHEMES", "http,https")),
+		OutboundAllowPrivate:   getEnv("OUTBOUND_ALLOW_PRIVATE", "false") == "true",
+		OutboundAllowlist:      splitList(getEnv("OUTBOUND_ALLOWLIST", "")),
+		EncryptionKey:          getEnv("ENCRYPTION_KEY", ""),
+		EncryptionPreviousKeys: splitList(getEnv("ENCRYPTION_PREVIOUS_KEYS", "")),
+		EncryptionKeyringFile:  getEnv("ENCRYPTION_KEYRING_FILE", ""),
+		RetentionDefaultDays:        getEnvInt("RETENTION_DEFAULT_DAYS", 0),
+		RetentionDefaultMaxRequests: getEnvInt("RETENTION_DEFAULT_MAX_REQUESTS", 0),
+		RetentionDefaultMaxBytes:    int64(getEnvInt("RETENTION_DEFAULT_MAX_BYTES", 0)),
+		RetentionDefaultArchive:     getEnv("RETENTION_DEFAULT_ARCHIVE", "false") == "true",
+		ExportRetentionHours: getEnvInt("EXPORT_RETENTION_HOURS", 24),
+		MetricsEndpointLabel:  getEnv("METRICS_ENDPOINT_LABEL", "slug"),
+		MetricsMaxLabelValues: getEnvInt("METRICS_MAX_LABEL_VALUES", 100),
+		TracingExporter:    getEnv("TRACING_EXPORTER", "none"),
+		TracingFile:        getEnv("TRACING_FILE", "traces.jsonl"),
+		TracingSampleRatio: getEnvFloat("TRACING_SAMPLE_RATIO", 1),
 	}
 }
 
+// splitList splits a comma-separated setting, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -49,6 +96,15 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
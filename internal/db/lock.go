@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Advisory lock keys of jobs that must only run on one replica at a time.
+// The high bytes spell "flow" to avoid clashing with other applications
+// sharing the database.
+const (
+	LockRetention     int64 = 0x666c6f77_0001
+	LockMigrations    int64 = 0x666c6f77_0002
+	LockDeliveryStats int64 = 0x666c6f77_0003
+	LockKeyRotation   int64 = 0x666c6f77_0004
+)
+
+// WithAdvisoryLock runs fn while holding the session-level advisory lock key.
+// It returns false without running fn when another session holds the lock,
+// so of several replicas only one runs fn at a time.
+func WithAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error) {
+	conn, err := Pool.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+
+	var acquired bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&acquired); err != nil {
+		return false, err
+	}
+	if !acquired {
+		return false, nil
+	}
+	defer advisoryUnlock(conn, key)
+
+	return true, fn(ctx)
+}
+
+// WaitForAdvisoryLock runs fn while holding the session-level advisory lock
+// key, waiting for other sessions to release it first
+func WaitForAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) error {
+	conn, err := Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, key); err != nil {
+		return err
+	}
+	defer advisoryUnlock(conn, key)
+
+	return fn(ctx)
+}
+
+func advisoryUnlock(conn *pgxpool.Conn, key int64) {
+	// A connection that failed to unlock is closed rather than returned to
+	// the pool still holding the lock
+	if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, key); err != nil {
+		conn.Conn().Close(context.Background())
+	}
+}
@@ -2,78 +2,357 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"flowhook/internal/logger"
+	"flowhook/migrations"
 
 	"github.com/jackc/pgx/v5"
 )
 
-// RunMigrations executes all SQL migration files in order
-// Looks for migrations in ../../migrations/ relative to this file
+// ErrChecksumDrift is returned when an applied migration's file has changed
+var ErrChecksumDrift = errors.New("applied migrations have been modified")
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string // Empty when the migration cannot be reverted
+	Checksum string // SHA-256 of Up
+	// Maintenance is why applying the migration needs a maintenance window,
+	// from a "-- Maintenance window:" line in its header. Empty for most.
+	Maintenance string
+}
+
+// maintenancePrefix starts the header line of a migration that blocks the
+// tables it changes for longer than a deploy should
+const maintenancePrefix = "-- Maintenance window:"
+
+// MigrationStatus is a migration and its state in the database
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+	Drifted   bool // Applied from a file with a different checksum
+	Missing   bool // Applied, but not known to this build
+}
+
+// LoadMigrations reads the NNN_name.sql migrations and NNN_name.down.sql
+// rollbacks in fsys, ordered by version
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		filename := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(filename, ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(filename, ".sql")
+		down := strings.HasSuffix(base, ".down")
+		base = strings.TrimSuffix(base, ".down")
+
+		prefix, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s does not start with a version number", filename)
+		}
+
+		content, err := fs.ReadFile(fsys, filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", filename, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+		if down {
+			m.Down = string(content)
+			continue
+		}
+		if m.Up != "" {
+			return nil, fmt.Errorf("duplicate migration version %d", version)
+		}
+		sum := sha256.Sum256(content)
+		m.Name = name
+		m.Up = string(content)
+		m.Checksum = hex.EncodeToString(sum[:])
+		m.Maintenance = maintenanceNote(m.Up)
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has a down migration but no up migration", m.Version)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// maintenanceNote returns the reason given on the "-- Maintenance window:"
+// line of a migration's leading comment, if any
+func maintenanceNote(up string) string {
+	for _, line := range strings.Split(up, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		if note, ok := strings.CutPrefix(line, maintenancePrefix); ok {
+			return strings.TrimSpace(note)
+		}
+	}
+	return ""
+}
+
+// appliedMigration is a row of schema_migrations
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func ensureMigrationsTable(ctx context.Context) error {
+	_, err := Pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+	return err
+}
+
+func appliedMigrations(ctx context.Context) (map[int]appliedMigration, error) {
+	applied := make(map[int]appliedMigration)
+
+	var exists bool
+	if err := Pool.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := Pool.Query(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var m appliedMigration
+		if err := rows.Scan(&version, &m.name, &m.checksum, &m.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = m
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatuses returns every known migration, and every applied one
+// unknown to this build, ordered by version
+func MigrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+	if Pool == nil {
+		return nil, fmt.Errorf("database pool not initialized")
+	}
+
+	list, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	statuses := make([]MigrationStatus, 0, len(list))
+	for _, m := range list {
+		status := MigrationStatus{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			appliedAt := a.appliedAt
+			status.AppliedAt = &appliedAt
+			status.Drifted = a.checksum != m.Checksum
+			delete(applied, m.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for version, a := range applied {
+		appliedAt := a.appliedAt
+		statuses = append(statuses, MigrationStatus{
+			Migration: Migration{Version: version, Name: a.name, Checksum: a.checksum},
+			AppliedAt: &appliedAt,
+			Missing:   true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// checkDrift returns ErrChecksumDrift naming the drifted migrations, if any
+func checkDrift(statuses []MigrationStatus) error {
+	var drifted []string
+	for _, s := range statuses {
+		if s.Drifted {
+			drifted = append(drifted, fmt.Sprintf("%03d_%s", s.Version, s.Name))
+		}
+	}
+	if len(drifted) > 0 {
+		return fmt.Errorf("%w: %s", ErrChecksumDrift, strings.Join(drifted, ", "))
+	}
+	return nil
+}
+
+// CheckMigrations returns an error when migrations are pending or applied
+// ones have been modified
+func CheckMigrations(ctx context.Context) error {
+	statuses, err := MigrationStatuses(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkDrift(statuses); err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			return fmt.Errorf("migration %03d_%s is pending", s.Version, s.Name)
+		}
+	}
+	return nil
+}
+
+// RunMigrations applies pending migrations in order, each in its own
+// transaction with its schema_migrations row. It holds the migrations
+// advisory lock, so replicas starting together apply them once. Nothing is
+// applied when an applied migration has been modified.
+//
+// Databases created before schema_migrations existed have every migration
+// applied once more; they are idempotent up to that point.
 func RunMigrations(ctx context.Context) error {
 	if Pool == nil {
 		return fmt.Errorf("database pool not initialized")
 	}
 
-	// Get migrations directory
-	// Try multiple paths depending on where the binary is run from
-	var migrationsDir string
-	var err error
-
-	// Path 1: From backend/internal/db -> ../../../migrations (when running from flow-hook/)
-	migrationsDir = filepath.Join("..", "..", "..", "migrations")
-	if _, err = os.Stat(migrationsDir); err == nil {
-		// Found it
-	} else {
-		// Path 2: From backend/ -> ../migrations (when running from backend/)
-		migrationsDir = filepath.Join("..", "migrations")
-		if _, err = os.Stat(migrationsDir); err == nil {
-			// Found it
-		} else {
-			// Path 3: migrations/ (when running from flow-hook/)
-			migrationsDir = "migrations"
-			if _, err = os.Stat(migrationsDir); err != nil {
-				return fmt.Errorf("could not find migrations directory. Tried: ../../../migrations, ../migrations, migrations")
+	return WaitForAdvisoryLock(ctx, LockMigrations, func(ctx context.Context) error {
+		if err := ensureMigrationsTable(ctx); err != nil {
+			return fmt.Errorf("failed to create schema_migrations: %w", err)
+		}
+		statuses, err := MigrationStatuses(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkDrift(statuses); err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			if s.Missing {
+				logger.WarnContext(ctx, "Migration is applied but unknown to this build", "version", s.Version, "name", s.Name)
+				continue
 			}
+			if s.AppliedAt != nil {
+				continue
+			}
+			if s.Maintenance != "" {
+				logger.WarnContext(ctx, "Applying a migration that needs a maintenance window", "version", s.Version, "name", s.Name, "reason", s.Maintenance)
+			}
+			if err := applyMigration(ctx, s.Migration); err != nil {
+				return err
+			}
+			logger.InfoContext(ctx, "Executed migration", "version", s.Version, "name", s.Name)
 		}
+		return nil
+	})
+}
+
+func applyMigration(ctx context.Context, m Migration) error {
+	tx, err := Pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for migration %03d_%s: %w", m.Version, m.Name, err)
 	}
+	defer tx.Rollback(ctx)
 
-	entries, err := os.ReadDir(migrationsDir)
+	if _, err := tx.Exec(ctx, m.Up); err != nil {
+		return fmt.Errorf("failed to execute migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+		m.Version, m.Name, m.Checksum,
+	)
 	if err != nil {
-		return fmt.Errorf("failed to read migrations directory %s: %w", migrationsDir, err)
+		return fmt.Errorf("failed to record migration %03d_%s: %w", m.Version, m.Name, err)
 	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
 
-	// Sort and execute migrations
-	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
-			continue
-		}
+// RollbackMigrations reverts the latest steps applied migrations, newest
+// first. It stops at a migration without a down migration.
+func RollbackMigrations(ctx context.Context, steps int) error {
+	if Pool == nil {
+		return fmt.Errorf("database pool not initialized")
+	}
 
-		migrationPath := filepath.Join(migrationsDir, entry.Name())
-		content, err := os.ReadFile(migrationPath)
+	return WaitForAdvisoryLock(ctx, LockMigrations, func(ctx context.Context) error {
+		statuses, err := MigrationStatuses(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+			return err
 		}
 
-		// Execute migration in a transaction
-		tx, txErr := Pool.BeginTx(ctx, pgx.TxOptions{})
-		if txErr != nil {
-			return fmt.Errorf("failed to begin transaction for migration %s: %w", entry.Name(), txErr)
-		}
-		_, execErr := tx.Exec(ctx, string(content))
-		if execErr != nil {
-			tx.Rollback(ctx)
-			return fmt.Errorf("failed to execute migration %s: %w", entry.Name(), execErr)
-		}
-		if commitErr := tx.Commit(ctx); commitErr != nil {
-			tx.Rollback(ctx)
-			return fmt.Errorf("failed to commit migration %s: %w", entry.Name(), commitErr)
+		for i := len(statuses) - 1; i >= 0 && steps > 0; i-- {
+			s := statuses[i]
+			if s.AppliedAt == nil {
+				continue
+			}
+			if s.Missing {
+				return fmt.Errorf("migration %03d_%s is unknown to this build and cannot be reverted", s.Version, s.Name)
+			}
+			if s.Down == "" {
+				return fmt.Errorf("migration %03d_%s has no down migration", s.Version, s.Name)
+			}
+			if err := revertMigration(ctx, s.Migration); err != nil {
+				return err
+			}
+			logger.InfoContext(ctx, "Reverted migration", "version", s.Version, "name", s.Name)
+			steps--
 		}
+		return nil
+	})
+}
 
-		fmt.Printf("✓ Executed migration: %s\n", entry.Name())
+func revertMigration(ctx context.Context, m Migration) error {
+	tx, err := Pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for migration %03d_%s: %w", m.Version, m.Name, err)
 	}
+	defer tx.Rollback(ctx)
 
+	if _, err := tx.Exec(ctx, m.Down); err != nil {
+		return fmt.Errorf("failed to revert migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+		return fmt.Errorf("failed to record migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit migration %03d_%s: %w", m.Version, m.Name, err)
+	}
 	return nil
 }
@@ -0,0 +1,253 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxChanges bounds the changes reported for very different responses
+const maxChanges = 1000
+
+// Response is one side of a comparison
+type Response struct {
+	Status  int
+	Headers map[string]interface{}
+	Body    *string
+}
+
+// Change is a single difference. Base is null for added values and Compare
+// for removed ones.
+type Change struct {
+	Path    string      `json:"path"`
+	Op      string      `json:"op"` // added|removed|changed
+	Base    interface{} `json:"base"`
+	Compare interface{} `json:"compare"`
+}
+
+// StatusDiff compares the response status codes
+type StatusDiff struct {
+	Base    int  `json:"base"`
+	Compare int  `json:"compare"`
+	Equal   bool `json:"equal"`
+}
+
+// Result is the difference between two responses
+type Result struct {
+	Equal      bool       `json:"equal"`
+	Status     StatusDiff `json:"status"`
+	Headers    []Change   `json:"headers"`
+	BodyFormat string     `json:"body_format"` // json|text
+	Body       []Change   `json:"body"`
+	Ignored    int        `json:"ignored"`   // Differences suppressed by ignore paths
+	Truncated  bool       `json:"truncated"` // More than maxChanges differences
+}
+
+// Compare diffs two responses. Paths are "status", "headers.<Name>" and
+// JSONPath-style body paths such as "$.items[0].id". Ignore paths use the same
+// syntax, where "*" matches any key or index and ".." any depth, so
+// "$..updated_at" ignores that field everywhere. An ignore path also covers
+// everything below it.
+func Compare(base, compare Response, ignore []string) Result {
+	d := &differ{}
+	for _, p := range ignore {
+		if p = strings.TrimSpace(p); p != "" {
+			d.ignore = append(d.ignore, ParsePath(p))
+		}
+	}
+
+	result := Result{
+		Status: StatusDiff{Base: base.Status, Compare: compare.Status, Equal: base.Status == compare.Status},
+	}
+	if !result.Status.Equal && d.ignored([]string{"status"}) {
+		result.Status.Equal = true
+		d.ignoredCount++
+	}
+
+	d.changes = []Change{}
+	d.diffHeaders(base.Headers, compare.Headers)
+	result.Headers = d.changes
+
+	d.changes = []Change{}
+	var baseBody, compareBody interface{}
+	baseJSON := parseJSON(base.Body, &baseBody)
+	compareJSON := parseJSON(compare.Body, &compareBody)
+	if baseJSON && compareJSON {
+		result.BodyFormat = "json"
+		d.diffValues([]string{"$"}, baseBody, compareBody)
+	} else {
+		result.BodyFormat = "text"
+		d.diffText(base.Body, compare.Body)
+	}
+	result.Body = d.changes
+
+	result.Ignored = d.ignoredCount
+	result.Truncated = d.truncated
+	result.Equal = result.Status.Equal && len(result.Headers) == 0 && len(result.Body) == 0 && !d.truncated
+	return result
+}
+
+type differ struct {
+	ignore       [][]string
+	changes      []Change
+	ignoredCount int
+	truncated    bool
+}
+
+func (d *differ) add(path []string, op string, base, compare interface{}) {
+	if d.ignored(path) {
+		d.ignoredCount++
+		return
+	}
+	if len(d.changes) >= maxChanges {
+		d.truncated = true
+		return
+	}
+	d.changes = append(d.changes, Change{Path: FormatPath(path), Op: op, Base: base, Compare: compare})
+}
+
+func (d *differ) ignored(path []string) bool {
+	for _, pattern := range d.ignore {
+		if matchPrefix(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *differ) diffHeaders(base, compare map[string]interface{}) {
+	a, b := normalizeHeaders(base), normalizeHeaders(compare)
+	for _, name := range unionKeys(a, b) {
+		path := []string{"headers", name}
+		av, inA := a[name]
+		bv, inB := b[name]
+		switch {
+		case !inB:
+			d.add(path, "removed", av, nil)
+		case !inA:
+			d.add(path, "added", nil, bv)
+		case av != bv:
+			d.add(path, "changed", av, bv)
+		}
+	}
+}
+
+func (d *differ) diffValues(path []string, a, b interface{}) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			for _, key := range unionKeys(av, bv) {
+				child := appendPath(path, key)
+				ac, inA := av[key]
+				bc, inB := bv[key]
+				switch {
+				case !inB:
+					d.add(child, "removed", ac, nil)
+				case !inA:
+					d.add(child, "added", nil, bc)
+				default:
+					d.diffValues(child, ac, bc)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			// Arrays are compared by position
+			for i := 0; i < len(av) || i < len(bv); i++ {
+				child := appendPath(path, strconv.Itoa(i))
+				switch {
+				case i >= len(bv):
+					d.add(child, "removed", av[i], nil)
+				case i >= len(av):
+					d.add(child, "added", nil, bv[i])
+				default:
+					d.diffValues(child, av[i], bv[i])
+				}
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		d.add(path, "changed", a, b)
+	}
+}
+
+func (d *differ) diffText(a, b *string) {
+	path := []string{"$"}
+	switch {
+	case a == nil && b == nil:
+	case b == nil:
+		d.add(path, "removed", *a, nil)
+	case a == nil:
+		d.add(path, "added", nil, *b)
+	case *a != *b:
+		d.add(path, "changed", *a, *b)
+	}
+}
+
+// parseJSON reports whether body is a JSON document, decoding it into v.
+// An empty body is treated as JSON null so it can be compared with JSON.
+// Numbers are kept as json.Number so large integers compare exactly.
+func parseJSON(body *string, v *interface{}) bool {
+	if body == nil || strings.TrimSpace(*body) == "" {
+		*v = nil
+		return true
+	}
+	decoder := json.NewDecoder(strings.NewReader(*body))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return false
+	}
+	// Nothing may follow the document
+	_, err := decoder.Token()
+	return err == io.EOF
+}
+
+// normalizeHeaders canonicalizes names and joins repeated values
+func normalizeHeaders(headers map[string]interface{}) map[string]string {
+	out := make(map[string]string, len(headers))
+	for k, v := range headers {
+		var value string
+		switch val := v.(type) {
+		case []interface{}:
+			parts := make([]string, len(val))
+			for i, p := range val {
+				parts[i] = fmt.Sprintf("%v", p)
+			}
+			value = strings.Join(parts, ", ")
+		case []string:
+			value = strings.Join(val, ", ")
+		default:
+			value = fmt.Sprintf("%v", val)
+		}
+		out[http.CanonicalHeaderKey(k)] = value
+	}
+	return out
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func appendPath(path []string, segment string) []string {
+	out := make([]string, len(path), len(path)+1)
+	copy(out, path)
+	return append(out, segment)
+}
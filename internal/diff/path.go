@@ -0,0 +1,130 @@
+package diff
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// anyDepth is the parsed form of ".." in a path
+const anyDepth = "**"
+
+// ParsePath splits a path into segments. Body paths start with "$" and accept
+// ".key", "[0]", "[\"key\"]", "*" and ".."; other paths are dot-separated, with
+// header names canonicalized.
+func ParsePath(p string) []string {
+	if !strings.HasPrefix(p, "$") {
+		segments := strings.SplitN(p, ".", 2)
+		if segments[0] == "headers" && len(segments) == 2 {
+			segments[1] = http.CanonicalHeaderKey(segments[1])
+		}
+		return segments
+	}
+
+	segments := []string{"$"}
+	rest := p[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			segments = append(segments, anyDepth)
+			rest = rest[2:]
+			var name string
+			name, rest = readName(rest)
+			if name != "" {
+				segments = append(segments, name)
+			}
+		case rest[0] == '.':
+			var name string
+			name, rest = readName(rest[1:])
+			segments = append(segments, name)
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return append(segments, rest)
+			}
+			inner := rest[1:end]
+			if unquoted, err := strconv.Unquote(inner); err == nil {
+				inner = unquoted
+			} else if strings.HasPrefix(inner, "'") && strings.HasSuffix(inner, "'") && len(inner) >= 2 {
+				inner = inner[1 : len(inner)-1]
+			}
+			segments = append(segments, inner)
+			rest = rest[end+1:]
+		default:
+			var name string
+			name, rest = readName(rest)
+			segments = append(segments, name)
+		}
+	}
+	return segments
+}
+
+// readName reads a key up to the next "." or "["
+func readName(s string) (string, string) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+// FormatPath renders segments in the syntax ParsePath reads
+func FormatPath(segments []string) string {
+	if len(segments) == 0 || segments[0] != "$" {
+		return strings.Join(segments, ".")
+	}
+
+	var b strings.Builder
+	b.WriteString("$")
+	for _, s := range segments[1:] {
+		if _, err := strconv.Atoi(s); err == nil {
+			b.WriteString("[" + s + "]")
+		} else if isIdentifier(s) {
+			b.WriteString("." + s)
+		} else {
+			b.WriteString("[" + strconv.Quote(s) + "]")
+		}
+	}
+	return b.String()
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// MatchPath reports whether pattern, as returned by ParsePath, matches path or
+// one of its ancestors
+func MatchPath(pattern, path []string) bool {
+	return matchPrefix(pattern, path)
+}
+
+// matchPrefix reports whether pattern matches path or one of its ancestors
+func matchPrefix(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	if pattern[0] == anyDepth {
+		for i := 0; i <= len(path); i++ {
+			if matchPrefix(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	if pattern[0] != "*" && pattern[0] != path[0] {
+		return false
+	}
+	return matchPrefix(pattern[1:], path[1:])
+}
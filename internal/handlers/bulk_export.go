@@ -0,0 +1,625 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"flowhook/internal/config"
+	"flowhook/internal/db"
+	"flowhook/internal/logger"
+	"flowhook/internal/models"
+	"flowhook/internal/storage"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// syncExportLimit is the largest export streamed directly; bigger ones need a job
+const syncExportLimit = 10000
+
+// exportJobSlots bounds how many export jobs write at once
+var exportJobSlots = make(chan struct{}, 2)
+
+// defaultExportRetention is how long finished export jobs and their files are
+// kept when EXPORT_RETENTION_HOURS is unset or invalid
+const defaultExportRetention = 24 * time.Hour
+
+// exportRetention returns how long a finished export job and its file are kept
+func exportRetention() time.Duration {
+	if config.AppConfig != nil && config.AppConfig.ExportRetentionHours > 0 {
+		return time.Duration(config.AppConfig.ExportRetentionHours) * time.Hour
+	}
+	return defaultExportRetention
+}
+
+// ExportEndpointRequests handles GET /api/v1/endpoints/:slug/export?format=ndjson|csv|har|postman
+func ExportEndpointRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := strings.TrimPrefix(r.URL.Path, "/api/v1/endpoints/")
+	slug = strings.TrimSuffix(slug, "/export")
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	formatInfo, ok := exportFormats[format]
+	if !ok {
+		http.Error(w, "format must be one of: ndjson, csv, har, postman", http.StatusBadRequest)
+		return
+	}
+
+	var endpointID uuid.UUID
+	err := db.Pool.QueryRow(r.Context(), `SELECT id FROM endpoints WHERE slug = $1`, slug).Scan(&endpointID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	encrypted, err := endpointEncryptsPayloads(r.Context(), endpointID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	filter := requestFilterFromQuery(r)
+	where, args, err := filter.whereClause(endpointID, encrypted)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var count int
+	err = db.Pool.QueryRow(r.Context(), `SELECT COUNT(*) FROM requests WHERE `+where, args...).Scan(&count)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to count requests: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if count > syncExportLimit {
+		http.Error(w, fmt.Sprintf("Export matches %d requests (limit %d); create an export job with POST /api/v1/endpoints/%s/exports", count, syncExportLimit, slug), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Streaming can outlast the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", formatInfo.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-export.%s", slug, formatInfo.Extension))
+
+	if _, err := writeRequestExport(r.Context(), w, format, slug, publicBaseURL(), where, args); err != nil {
+		// Headers are already sent, so the truncated download is all we can signal
+		logger.ErrorContext(r.Context(), "Export failed", "endpoint", slug, "error", err)
+	}
+}
+
+// CreateExportJob handles POST /api/v1/endpoints/:slug/exports
+func CreateExportJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := strings.TrimPrefix(r.URL.Path, "/api/v1/endpoints/")
+	slug = strings.TrimSuffix(slug, "/exports")
+
+	var req models.CreateExportJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Format == "" {
+		req.Format = "ndjson"
+	}
+	if _, ok := exportFormats[req.Format]; !ok {
+		http.Error(w, "format must be one of: ndjson, csv, har, postman", http.StatusBadRequest)
+		return
+	}
+
+	var endpointID uuid.UUID
+	err := db.Pool.QueryRow(r.Context(), `SELECT id FROM endpoints WHERE slug = $1`, slug).Scan(&endpointID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	filter := requestFilter{
+		Method: req.Method,
+		Search: req.Search,
+		From:   req.From,
+		To:     req.To,
+		Query:  req.Query,
+	}
+	encrypted, err := endpointEncryptsPayloads(r.Context(), endpointID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Validate the filter now rather than failing the job later
+	if _, _, err := filter.whereClause(endpointID, encrypted); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter: %v", err), http.StatusBadRequest)
+		return
+	}
+	filterJSON, _ := json.Marshal(filter)
+	baseURL := publicBaseURL()
+
+	var jobID uuid.UUID
+	err = db.Pool.QueryRow(
+		r.Context(),
+		`INSERT INTO export_jobs (endpoint_id, format, filter, base_url, runner_id, lease_expires_at)
+		 VALUES ($1, $2, $3, $4, $5, now() + make_interval(secs => $6))
+		 RETURNING id`,
+		endpointID, req.Format, filterJSON, baseURL, instanceID, jobLeaseDuration.Seconds(),
+	).Scan(&jobID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create export job: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	go runExportJob(jobID, endpointID, slug, req.Format, baseURL, filter)
+
+	job, err := getExportJob(r.Context(), jobID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetExportJobs handles GET /api/v1/endpoints/:slug/exports
+func GetExportJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := strings.TrimPrefix(r.URL.Path, "/api/v1/endpoints/")
+	slug = strings.TrimSuffix(slug, "/exports")
+
+	rows, err := db.Pool.Query(
+		r.Context(),
+		`SELECT `+exportJobColumns+`
+		 FROM export_jobs
+		 WHERE endpoint_id = (SELECT id FROM endpoints WHERE slug = $1)
+		 ORDER BY created_at DESC
+		 LIMIT 50`,
+		slug,
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	jobs := []models.ExportJob{}
+	for rows.Next() {
+		job, err := scanExportJob(rows)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to scan export job: %v", err), http.StatusInternalServerError)
+			return
+		}
+		jobs = append(jobs, job)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// GetExportJob handles GET /api/v1/exports/:id
+func GetExportJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, "/api/v1/exports/"))
+	if err != nil {
+		http.Error(w, "Invalid export ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := getExportJob(r.Context(), jobID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// DownloadExportJob handles GET /api/v1/exports/:id/download
+func DownloadExportJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobIDStr := strings.TrimPrefix(r.URL.Path, "/api/v1/exports/")
+	jobIDStr = strings.TrimSuffix(jobIDStr, "/download")
+	jobID, err := uuid.Parse(jobIDStr)
+	if err != nil {
+		http.Error(w, "Invalid export ID", http.StatusBadRequest)
+		return
+	}
+
+	var status, format, slug string
+	var filePath *string
+	err = db.Pool.QueryRow(
+		r.Context(),
+		`SELECT j.status, j.format, j.file_path, e.slug
+		 FROM export_jobs j JOIN endpoints e ON e.id = j.endpoint_id
+		 WHERE j.id = $1`,
+		jobID,
+	).Scan(&status, &format, &filePath, &slug)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if status != "completed" || filePath == nil {
+		http.Error(w, fmt.Sprintf("Export is %s", status), http.StatusConflict)
+		return
+	}
+
+	// Export files are written to the data directory of the replica that ran
+	// the job, so replicas must share it for downloads to work on any of them
+	file, err := storage.OpenExportFile(*filePath)
+	if err != nil {
+		http.Error(w, "Export file is no longer available on this server", http.StatusGone)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read export: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	formatInfo := exportFormats[format]
+	filename := fmt.Sprintf("%s-export-%s.%s", slug, jobID.String()[:8], formatInfo.Extension)
+	w.Header().Set("Content-Type", formatInfo.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	http.ServeContent(w, r, filename, info.ModTime(), file)
+}
+
+// DeleteExportJob handles DELETE /api/v1/exports/:id
+func DeleteExportJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, "/api/v1/exports/"))
+	if err != nil {
+		http.Error(w, "Invalid export ID", http.StatusBadRequest)
+		return
+	}
+
+	var filePath *string
+	err = db.Pool.QueryRow(r.Context(), `DELETE FROM export_jobs WHERE id = $1 RETURNING file_path`, jobID).Scan(&filePath)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if filePath != nil {
+		if err := storage.DeleteExportFile(*filePath); err != nil {
+			logger.WarnContext(r.Context(), "Failed to delete export file", "path", *filePath, "error", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartExportJobWorker fails interrupted export jobs and removes expired ones
+// now and then once per lease period
+func StartExportJobWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(jobLeaseDuration)
+		defer ticker.Stop()
+
+		for {
+			if err := FailInterruptedExportJobs(ctx); err != nil {
+				logger.ErrorContext(ctx, "Failed to reset interrupted export jobs", "error", err)
+			}
+			if err := DeleteExpiredExportJobs(ctx); err != nil {
+				logger.ErrorContext(ctx, "Failed to delete expired export jobs", "error", err)
+			}
+			if err := deleteOrphanedExportFiles(ctx); err != nil {
+				logger.ErrorContext(ctx, "Failed to delete orphaned export files", "error", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// FailInterruptedExportJobs marks unfinished jobs whose process stopped, as
+// their lease expired, as failed. Jobs other replicas are running are left alone.
+func FailInterruptedExportJobs(ctx context.Context) error {
+	_, err := db.Pool.Exec(
+		ctx,
+		`UPDATE export_jobs
+		 SET status = 'failed', error_message = 'Interrupted: the server running it stopped', completed_at = now(),
+		     runner_id = NULL, lease_expires_at = NULL, expires_at = now() + make_interval(secs => $1)
+		 WHERE status IN ('pending', 'running') AND (lease_expires_at IS NULL OR lease_expires_at < now())`,
+		exportRetention().Seconds(),
+	)
+	return err
+}
+
+// DeleteExpiredExportJobs deletes finished export jobs past their expiry
+// together with their files. A file another replica wrote is left to that
+// replica's orphan sweep.
+func DeleteExpiredExportJobs(ctx context.Context) error {
+	rows, err := db.Pool.Query(ctx, `DELETE FROM export_jobs WHERE expires_at < now() RETURNING file_path`)
+	if err != nil {
+		return err
+	}
+	filePaths, err := pgx.CollectRows(rows, pgx.RowTo[*string])
+	if err != nil {
+		return err
+	}
+
+	for _, filePath := range filePaths {
+		if filePath == nil {
+			continue
+		}
+		if err := storage.DeleteExportFile(*filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.WarnContext(ctx, "Failed to delete export file", "path", *filePath, "error", err)
+		}
+	}
+	return nil
+}
+
+// deleteOrphanedExportFiles deletes export files in this replica's data
+// directory whose job no longer exists, as when the job expired on another
+// replica or its endpoint was deleted and the job rows cascaded away
+func deleteOrphanedExportFiles(ctx context.Context) error {
+	files, err := storage.ExportFiles()
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	jobIDs := make([]uuid.UUID, 0, len(files))
+	for jobID := range files {
+		jobIDs = append(jobIDs, jobID)
+	}
+	// Job rows are created before their file, so a file without a row is orphaned
+	rows, err := db.Pool.Query(ctx, `SELECT id FROM export_jobs WHERE id = ANY($1)`, jobIDs)
+	if err != nil {
+		return err
+	}
+	existing, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return err
+	}
+	for _, jobID := range existing {
+		delete(files, jobID)
+	}
+
+	for jobID, filePath := range files {
+		if err := storage.DeleteExportFile(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.WarnContext(ctx, "Failed to delete orphaned export file", "export_job_id", jobID, "path", filePath, "error", err)
+		}
+	}
+	return nil
+}
+
+// runExportJob writes an export file in the background and records the outcome
+func runExportJob(jobID, endpointID uuid.UUID, slug, format, baseURL string, filter requestFilter) {
+	ctx, cancel := context.WithCancel(logger.WithJob(context.Background(), "export"))
+	defer cancel()
+
+	// Renew the lease while waiting for a slot and writing. A job that was
+	// deleted, or failed by another replica, is abandoned.
+	heartbeatDone := make(chan struct{})
+	defer close(heartbeatDone)
+	go func() {
+		ticker := time.NewTicker(jobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heartbeatDone:
+				return
+			case <-ticker.C:
+				tag, err := db.Pool.Exec(ctx,
+					`UPDATE export_jobs SET lease_expires_at = now() + make_interval(secs => $3) WHERE id = $1 AND runner_id = $2`,
+					jobID, instanceID, jobLeaseDuration.Seconds())
+				if err != nil {
+					// The lease outlasts a few missed heartbeats
+					logger.WarnContext(ctx, "Failed to renew export job lease", "export_job_id", jobID, "error", err)
+					continue
+				}
+				if tag.RowsAffected() == 0 {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case exportJobSlots <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	defer func() { <-exportJobSlots }()
+
+	db.Pool.Exec(ctx, `UPDATE export_jobs SET status = 'running', started_at = now() WHERE id = $1 AND runner_id = $2`, jobID, instanceID)
+
+	fail := func(err error) {
+		errMsg := err.Error()
+		_, dbErr := db.Pool.Exec(
+			context.WithoutCancel(ctx),
+			`UPDATE export_jobs SET status = 'failed', error_message = $2, completed_at = now(), runner_id = NULL, lease_expires_at = NULL,
+			     expires_at = now() + make_interval(secs => $4)
+			 WHERE id = $1 AND runner_id = $3`,
+			jobID, errMsg, instanceID, exportRetention().Seconds(),
+		)
+		if dbErr != nil {
+			logger.ErrorContext(ctx, "Failed to record export job failure", "export_job_id", jobID, "error", dbErr)
+		}
+	}
+
+	encrypted, err := endpointEncryptsPayloads(ctx, endpointID)
+	if err != nil {
+		fail(err)
+		return
+	}
+	where, args, err := filter.whereClause(endpointID, encrypted)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	file, filePath, err := storage.CreateExportFile(jobID, exportFormats[format].Extension)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	count, err := writeRequestExport(ctx, file, format, slug, baseURL, where, args)
+	var size int64
+	if info, statErr := file.Stat(); statErr == nil {
+		size = info.Size()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		storage.DeleteExportFile(filePath)
+		fail(err)
+		return
+	}
+
+	tag, err := db.Pool.Exec(
+		ctx,
+		`UPDATE export_jobs
+		 SET status = 'completed', request_count = $2, file_path = $3, file_size = $4, completed_at = now(),
+		     runner_id = NULL, lease_expires_at = NULL, expires_at = now() + make_interval(secs => $6)
+		 WHERE id = $1 AND runner_id = $5`,
+		jobID, count, filePath, size, instanceID, exportRetention().Seconds(),
+	)
+	if err != nil || tag.RowsAffected() == 0 {
+		// The job may have been deleted, or failed after losing its lease
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to complete export job", "export_job_id", jobID, "error", err)
+		}
+		storage.DeleteExportFile(filePath)
+	}
+}
+
+// writeRequestExport streams every request matching where, oldest first, and
+// returns how many were written
+func writeRequestExport(ctx context.Context, w io.Writer, format, slug, baseURL, where string, args []interface{}) (int, error) {
+	exporter, err := newRequestExporter(format, w, fmt.Sprintf("FlowHook %s export", slug))
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.Pool.Query(
+		ctx,
+		`SELECT `+requestColumns+` FROM requests WHERE `+where+` ORDER BY received_at ASC, id ASC`,
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		req, err := scanRequest(rows)
+		if err != nil {
+			return count, err
+		}
+		if err := exporter.WriteRequest(req, captureURL(baseURL, slug, req)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	return count, exporter.Close()
+}
+
+// exportJobColumns is the column list read by scanExportJob
+const exportJobColumns = `id, endpoint_id, format, filter, status, request_count, file_size, error_message, created_at, started_at, completed_at, expires_at`
+
+func getExportJob(ctx context.Context, jobID uuid.UUID) (models.ExportJob, error) {
+	row := db.Pool.QueryRow(ctx, `SELECT `+exportJobColumns+` FROM export_jobs WHERE id = $1`, jobID)
+	return scanExportJob(row)
+}
+
+func scanExportJob(scanner interface {
+	Scan(dest ...interface{}) error
+}) (models.ExportJob, error) {
+	var job models.ExportJob
+	var filterJSON []byte
+
+	err := scanner.Scan(
+		&job.ID,
+		&job.EndpointID,
+		&job.Format,
+		&filterJSON,
+		&job.Status,
+		&job.RequestCount,
+		&job.FileSize,
+		&job.ErrorMessage,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.CompletedAt,
+		&job.ExpiresAt,
+	)
+	if err != nil {
+		return job, err
+	}
+
+	json.Unmarshal(filterJSON, &job.Filter)
+	if job.Status == "completed" {
+		downloadURL := fmt.Sprintf("/api/v1/exports/%s/download", job.ID)
+		job.DownloadURL = &downloadURL
+	}
+	return job, nil
+}
@@ -0,0 +1,849 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"flowhook/internal/db"
+	"flowhook/internal/logger"
+	"flowhook/internal/models"
+	"flowhook/internal/outbound"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	maxBulkReplayConcurrency = 20
+	bulkReplayPageSize       = 500
+)
+
+// bulkReplayRunner is the in-process handle on a running bulk replay
+type bulkReplayRunner struct {
+	stop chan struct{}
+	once sync.Once
+}
+
+func (br *bulkReplayRunner) signalStop() {
+	br.once.Do(func() { close(br.stop) })
+}
+
+var bulkReplayRunners = struct {
+	sync.Mutex
+	m map[uuid.UUID]*bulkReplayRunner
+}{m: make(map[uuid.UUID]*bulkReplayRunner)}
+
+// CreateBulkReplay handles POST /api/v1/endpoints/:slug/bulk-replays
+func CreateBulkReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := strings.TrimPrefix(r.URL.Path, "/api/v1/endpoints/")
+	slug = strings.TrimSuffix(slug, "/bulk-replays")
+
+	var req models.CreateBulkReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if (req.TargetURL == "") == (req.ForwardingRuleID == nil) {
+		http.Error(w, "Exactly one of target_url or forwarding_rule_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.TargetURL != "" {
+		if err := outbound.CheckURL(req.TargetURL); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid target_url: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.RatePerSecond < 0 {
+		http.Error(w, "rate_per_second must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.Concurrency <= 0 {
+		req.Concurrency = 1
+	}
+	if req.Concurrency > maxBulkReplayConcurrency {
+		http.Error(w, fmt.Sprintf("concurrency must be at most %d", maxBulkReplayConcurrency), http.StatusBadRequest)
+		return
+	}
+	if req.Order == "" {
+		req.Order = "oldest_first"
+	}
+	if req.Order != "oldest_first" && req.Order != "newest_first" {
+		http.Error(w, "order must be oldest_first or newest_first", http.StatusBadRequest)
+		return
+	}
+
+	var endpointID uuid.UUID
+	err := db.Pool.QueryRow(r.Context(), `SELECT id FROM endpoints WHERE slug = $1`, slug).Scan(&endpointID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if req.ForwardingRuleID != nil {
+		rule, err := getForwardingRuleByID(r.Context(), *req.ForwardingRuleID)
+		if err == pgx.ErrNoRows || (err == nil && rule.EndpointID != endpointID) {
+			http.Error(w, "Forwarding rule not found for this endpoint", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	filter := requestFilter{
+		Method:        req.Method,
+		Search:        req.Search,
+		From:          req.From,
+		To:            req.To,
+		Query:         req.Query,
+		ForwardFailed: req.ForwardFailed,
+		ForwardRuleID: req.ForwardRuleID,
+	}
+	encrypted, err := endpointEncryptsPayloads(r.Context(), endpointID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	where, args, err := filter.whereClause(endpointID, encrypted)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Fix the set of requests now so traffic arriving during the replay is left out
+	snapshotAt := time.Now()
+	args = append(args, snapshotAt)
+	where += fmt.Sprintf(" AND received_at <= $%d", len(args))
+
+	var total int
+	err = db.Pool.QueryRow(r.Context(), `SELECT COUNT(*) FROM requests WHERE `+where, args...).Scan(&total)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to count requests: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var targetURL *string
+	if req.TargetURL != "" {
+		targetURL = &req.TargetURL
+	}
+	filterJSON, _ := json.Marshal(filter)
+
+	var jobID uuid.UUID
+	err = db.Pool.QueryRow(
+		r.Context(),
+		`INSERT INTO bulk_replays (endpoint_id, filter, target_url, forwarding_rule_id, rate_per_second, concurrency, sort_order, snapshot_at, total)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 RETURNING id`,
+		endpointID, filterJSON, targetURL, req.ForwardingRuleID, req.RatePerSecond, req.Concurrency, req.Order, snapshotAt, total,
+	).Scan(&jobID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create bulk replay: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	go runBulkReplay(jobID)
+
+	job, err := getBulkReplay(r.Context(), jobID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetBulkReplays handles GET /api/v1/endpoints/:slug/bulk-replays
+func GetBulkReplays(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := strings.TrimPrefix(r.URL.Path, "/api/v1/endpoints/")
+	slug = strings.TrimSuffix(slug, "/bulk-replays")
+
+	rows, err := db.Pool.Query(
+		r.Context(),
+		`SELECT `+bulkReplayColumns+`
+		 FROM bulk_replays
+		 WHERE endpoint_id = (SELECT id FROM endpoints WHERE slug = $1)
+		 ORDER BY created_at DESC
+		 LIMIT 50`,
+		slug,
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	jobs := []models.BulkReplay{}
+	for rows.Next() {
+		job, err := scanBulkReplay(rows)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to scan bulk replay: %v", err), http.StatusInternalServerError)
+			return
+		}
+		jobs = append(jobs, job)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// GetBulkReplay handles GET /api/v1/bulk-replays/:id
+func GetBulkReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, "/api/v1/bulk-replays/"))
+	if err != nil {
+		http.Error(w, "Invalid bulk replay ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := getBulkReplay(r.Context(), jobID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Bulk replay not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// ControlBulkReplay handles POST /api/v1/bulk-replays/:id/pause|resume|cancel
+func ControlBulkReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/bulk-replays/")
+	jobIDStr, action, _ := strings.Cut(path, "/")
+	jobID, err := uuid.Parse(jobIDStr)
+	if err != nil {
+		http.Error(w, "Invalid bulk replay ID", http.StatusBadRequest)
+		return
+	}
+
+	bulkReplayRunners.Lock()
+	runner := bulkReplayRunners.m[jobID]
+	bulkReplayRunners.Unlock()
+
+	var tag pgconn.CommandTag
+	switch action {
+	case "pause":
+		tag, err = db.Pool.Exec(r.Context(),
+			`UPDATE bulk_replays SET status = 'paused' WHERE id = $1 AND status IN ('pending', 'running')`, jobID)
+		if runner != nil {
+			runner.signalStop()
+		}
+	case "resume":
+		if runner != nil {
+			// In-flight requests of the paused run are still finishing
+			http.Error(w, "Bulk replay is still pausing, try again shortly", http.StatusConflict)
+			return
+		}
+		tag, err = db.Pool.Exec(r.Context(),
+			`UPDATE bulk_replays SET status = 'running' WHERE id = $1 AND status = 'paused'`, jobID)
+		if err == nil && tag.RowsAffected() > 0 {
+			// If a paused run on another replica still holds the lease, the
+			// bulk replay worker resumes the job once it is released
+			go runBulkReplay(jobID)
+		}
+	case "cancel":
+		tag, err = db.Pool.Exec(r.Context(),
+			`UPDATE bulk_replays SET status = 'cancelled', completed_at = now()
+			 WHERE id = $1 AND status IN ('pending', 'running', 'paused')`, jobID)
+		if runner != nil {
+			runner.signalStop()
+		}
+	default:
+		http.Error(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	job, err := getBulkReplay(r.Context(), jobID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Bulk replay not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, fmt.Sprintf("Cannot %s a bulk replay that is %s", action, job.Status), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetBulkReplayReport handles GET /api/v1/bulk-replays/:id/report
+func GetBulkReplayReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobIDStr := strings.TrimPrefix(r.URL.Path, "/api/v1/bulk-replays/")
+	jobIDStr = strings.TrimSuffix(jobIDStr, "/report")
+	jobID, err := uuid.Parse(jobIDStr)
+	if err != nil {
+		http.Error(w, "Invalid bulk replay ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := getBulkReplay(r.Context(), jobID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Bulk replay not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Outcomes grouped by response status (0 = no response)
+	byStatus := map[string]int{}
+	rows, err := db.Pool.Query(
+		r.Context(),
+		`SELECT COALESCE(response_status, 0), COUNT(*)
+		 FROM bulk_replay_items WHERE bulk_replay_id = $1 AND status <> 'in_flight'
+		 GROUP BY 1 ORDER BY 1`,
+		jobID,
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var status, count int
+		if err := rows.Scan(&status, &count); err == nil {
+			byStatus[fmt.Sprintf("%d", status)] = count
+		}
+	}
+	rows.Close()
+
+	// Most recent failures, for follow-up
+	type failure struct {
+		RequestID      uuid.UUID  `json:"request_id"`
+		ReplayID       *uuid.UUID `json:"replay_id,omitempty"`
+		ResponseStatus *int       `json:"response_status,omitempty"`
+		ErrorMessage   *string    `json:"error_message,omitempty"`
+		CompletedAt    time.Time  `json:"completed_at"`
+	}
+	failures := []failure{}
+	rows, err = db.Pool.Query(
+		r.Context(),
+		`SELECT request_id, replay_id, response_status, error_message, completed_at
+		 FROM bulk_replay_items WHERE bulk_replay_id = $1 AND status = 'failed'
+		 ORDER BY completed_at DESC
+		 LIMIT 100`,
+		jobID,
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var f failure
+		if err := rows.Scan(&f.RequestID, &f.ReplayID, &f.ResponseStatus, &f.ErrorMessage, &f.CompletedAt); err == nil {
+			failures = append(failures, f)
+		}
+	}
+	rows.Close()
+
+	report := map[string]interface{}{
+		"bulk_replay":        job,
+		"remaining":          job.Total - job.Processed,
+		"by_response_status": byStatus,
+		"recent_failures":    failures,
+	}
+	if job.StartedAt != nil {
+		end := time.Now()
+		if job.CompletedAt != nil {
+			end = *job.CompletedAt
+		}
+		report["elapsed_ms"] = end.Sub(*job.StartedAt).Milliseconds()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// StartBulkReplayWorker resumes abandoned bulk replays now and then once per
+// lease period
+func StartBulkReplayWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(jobLeaseDuration)
+		defer ticker.Stop()
+
+		for {
+			if err := ResumeBulkReplays(ctx); err != nil {
+				logger.ErrorContext(ctx, "Failed to resume bulk replays", "error", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ResumeBulkReplays starts the bulk replays that should be running but have no
+// live runner: ones left by a process that stopped, or resumed while their
+// paused run was still finishing. Requests that already have an outcome are
+// skipped.
+func ResumeBulkReplays(ctx context.Context) error {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT id FROM bulk_replays
+		 WHERE status IN ('pending', 'running') AND (lease_expires_at IS NULL OR lease_expires_at < now())`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var jobID uuid.UUID
+		if err := rows.Scan(&jobID); err != nil {
+			return err
+		}
+		go runBulkReplay(jobID)
+	}
+	return rows.Err()
+}
+
+// runBulkReplay dispatches a job's remaining requests to a worker pool at the
+// configured rate until they run out or the job is paused or cancelled. It
+// returns at once unless it can claim the job's lease.
+func runBulkReplay(jobID uuid.UUID) {
+	runner := &bulkReplayRunner{stop: make(chan struct{})}
+	bulkReplayRunners.Lock()
+	if _, running := bulkReplayRunners.m[jobID]; running {
+		bulkReplayRunners.Unlock()
+		return
+	}
+	bulkReplayRunners.m[jobID] = runner
+	bulkReplayRunners.Unlock()
+
+	defer func() {
+		bulkReplayRunners.Lock()
+		delete(bulkReplayRunners.m, jobID)
+		bulkReplayRunners.Unlock()
+	}()
+
+	ctx := logger.WithJob(context.Background(), "bulk replay")
+
+	claimed, err := claimBulkReplay(ctx, jobID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to claim bulk replay", "bulk_replay_id", jobID, "error", err)
+		return
+	}
+	if !claimed {
+		// Another process runs it, or it is no longer pending or running
+		return
+	}
+	defer releaseBulkReplay(ctx, jobID)
+
+	// Stop when the job is paused or cancelled, on any replica, or the lease
+	// is lost
+	heartbeatDone := make(chan struct{})
+	defer close(heartbeatDone)
+	go func() {
+		ticker := time.NewTicker(jobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heartbeatDone:
+				return
+			case <-ticker.C:
+				if !renewBulkReplay(ctx, jobID) {
+					runner.signalStop()
+					return
+				}
+			}
+		}
+	}()
+
+	// Items a dead runner left in flight may have been delivered, so they are
+	// recorded as failed rather than sent again
+	_, err = db.Pool.Exec(ctx,
+		`WITH items AS (
+			UPDATE bulk_replay_items
+			SET status = 'failed', error_message = 'Interrupted before its outcome was recorded; the request may have been delivered', completed_at = now()
+			WHERE bulk_replay_id = $1 AND status = 'in_flight'
+			RETURNING 1
+		)
+		UPDATE bulk_replays
+		SET processed = processed + (SELECT COUNT(*) FROM items), failed = failed + (SELECT COUNT(*) FROM items)
+		WHERE id = $1`,
+		jobID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to close interrupted bulk replay items", "bulk_replay_id", jobID, "error", err)
+		return
+	}
+
+	job, err := getBulkReplay(ctx, jobID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to load bulk replay", "bulk_replay_id", jobID, "error", err)
+		return
+	}
+	if job.Status != "pending" && job.Status != "running" {
+		return
+	}
+
+	fail := func(err error) {
+		_, dbErr := db.Pool.Exec(ctx,
+			`UPDATE bulk_replays SET status = 'failed', error_message = $2, completed_at = now() WHERE id = $1`,
+			jobID, err.Error())
+		if dbErr != nil {
+			logger.ErrorContext(ctx, "Failed to record bulk replay failure", "bulk_replay_id", jobID, "error", dbErr)
+		}
+	}
+
+	var rule *models.ForwardingRule
+	if job.ForwardingRuleID != nil {
+		r, err := getForwardingRuleByID(ctx, *job.ForwardingRuleID)
+		if err != nil {
+			fail(fmt.Errorf("forwarding rule unavailable: %v", err))
+			return
+		}
+		rule = &r
+	} else if job.TargetURL == nil {
+		fail(fmt.Errorf("forwarding rule was deleted"))
+		return
+	}
+
+	filterJSON, _ := json.Marshal(job.Filter)
+	var filter requestFilter
+	json.Unmarshal(filterJSON, &filter)
+	encrypted, err := endpointEncryptsPayloads(ctx, job.EndpointID)
+	if err != nil {
+		fail(err)
+		return
+	}
+	where, args, err := filter.whereClause(job.EndpointID, encrypted)
+	if err != nil {
+		fail(err)
+		return
+	}
+	args = append(args, job.SnapshotAt, jobID)
+	where += fmt.Sprintf(" AND received_at <= $%d AND NOT EXISTS (SELECT 1 FROM bulk_replay_items i WHERE i.bulk_replay_id = $%d AND i.request_id = requests.id)",
+		len(args)-1, len(args))
+
+	db.Pool.Exec(ctx, `UPDATE bulk_replays SET status = 'running', started_at = COALESCE(started_at, now()) WHERE id = $1 AND status IN ('pending', 'running')`, jobID)
+
+	// Workers
+	work := make(chan models.Request)
+	var wg sync.WaitGroup
+	for i := 0; i < job.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range work {
+				replayBulkItem(ctx, job, rule, req)
+			}
+		}()
+	}
+
+	// Rate limit
+	var tick <-chan time.Time
+	if job.RatePerSecond > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / job.RatePerSecond))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	// Page through matching requests in the job's order; the keyset cursor moves
+	// past requests handed to workers before their outcome is recorded
+	order := "ASC"
+	if job.Order == "newest_first" {
+		order = "DESC"
+	}
+	var cursor *pageCursor
+	err = func() error {
+		for {
+			// Pick up a pause or cancel from another replica between pages
+			if cursor != nil && !renewBulkReplay(ctx, jobID) {
+				return nil
+			}
+
+			query := `SELECT ` + requestColumns + ` FROM requests WHERE ` + where
+			pageArgs := args
+			if cursor != nil {
+				cmp := ">"
+				if order == "DESC" {
+					cmp = "<"
+				}
+				pageArgs = append(append([]interface{}{}, args...), cursor.Time, cursor.ID)
+				query += fmt.Sprintf(" AND (received_at, id) %s ($%d, $%d)", cmp, len(pageArgs)-1, len(pageArgs))
+			}
+			query += fmt.Sprintf(" ORDER BY received_at %s, id %s LIMIT %d", order, order, bulkReplayPageSize)
+
+			rows, err := db.Pool.Query(ctx, query, pageArgs...)
+			if err != nil {
+				return err
+			}
+			var page []models.Request
+			for rows.Next() {
+				req, err := scanRequest(rows)
+				if err != nil {
+					rows.Close()
+					return err
+				}
+				page = append(page, req)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for _, req := range page {
+				if tick != nil {
+					select {
+					case <-tick:
+					case <-runner.stop:
+						return nil
+					}
+				}
+				select {
+				case work <- req:
+				case <-runner.stop:
+					return nil
+				}
+			}
+
+			if len(page) < bulkReplayPageSize {
+				return nil
+			}
+			last := page[len(page)-1]
+			cursor = &pageCursor{Time: last.ReceivedAt, ID: last.ID}
+		}
+	}()
+
+	close(work)
+	wg.Wait()
+
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	// A pause or cancel has already set the final status, and a runner that
+	// lost its lease may have stopped early
+	db.Pool.Exec(ctx,
+		`UPDATE bulk_replays SET status = 'completed', completed_at = now() WHERE id = $1 AND status = 'running' AND runner_id = $2`,
+		jobID, instanceID)
+}
+
+// claimBulkReplay takes the lease on a pending or running job unless another
+// live process holds it
+func claimBulkReplay(ctx context.Context, jobID uuid.UUID) (bool, error) {
+	tag, err := db.Pool.Exec(ctx,
+		`UPDATE bulk_replays SET runner_id = $2, lease_expires_at = now() + make_interval(secs => $3)
+		 WHERE id = $1 AND status IN ('pending', 'running')
+		   AND (runner_id IS NULL OR runner_id = $2 OR lease_expires_at < now())`,
+		jobID, instanceID, jobLeaseDuration.Seconds())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// renewBulkReplay extends this process's lease on a job and reports whether
+// the run should go on: the lease is still ours and the job not paused,
+// cancelled or failed
+func renewBulkReplay(ctx context.Context, jobID uuid.UUID) bool {
+	var status string
+	err := db.Pool.QueryRow(ctx,
+		`UPDATE bulk_replays SET lease_expires_at = now() + make_interval(secs => $3)
+		 WHERE id = $1 AND runner_id = $2
+		 RETURNING status`,
+		jobID, instanceID, jobLeaseDuration.Seconds(),
+	).Scan(&status)
+	if err == pgx.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		// The lease outlasts a few missed heartbeats
+		logger.WarnContext(ctx, "Failed to renew bulk replay lease", "bulk_replay_id", jobID, "error", err)
+		return true
+	}
+	return status == "pending" || status == "running"
+}
+
+// releaseBulkReplay gives up this process's lease on a job
+func releaseBulkReplay(ctx context.Context, jobID uuid.UUID) {
+	_, err := db.Pool.Exec(ctx,
+		`UPDATE bulk_replays SET runner_id = NULL, lease_expires_at = NULL WHERE id = $1 AND runner_id = $2`,
+		jobID, instanceID)
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to release bulk replay lease", "bulk_replay_id", jobID, "error", err)
+	}
+}
+
+// replayBulkItem replays one request and records its outcome on the job
+func replayBulkItem(ctx context.Context, job models.BulkReplay, rule *models.ForwardingRule, req models.Request) {
+	// Record the item before sending, so a runner that takes over after a
+	// crash does not send it again
+	tag, err := db.Pool.Exec(ctx,
+		`INSERT INTO bulk_replay_items (bulk_replay_id, request_id, status, completed_at)
+		 VALUES ($1, $2, 'in_flight', NULL)
+		 ON CONFLICT DO NOTHING`,
+		job.ID, req.ID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to record bulk replay item", "bulk_replay_id", job.ID, "request_id", req.ID, "error", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		return
+	}
+
+	var replayID *uuid.UUID
+	var outcome replayOutcome
+
+	if rule != nil {
+		// Redeliver through the rule, recording forward attempts as usual
+		result := forwardRequest(ctx, capturedFromRequest(req), *rule)
+		outcome = replayOutcome{Status: result.Status, ResponseStatus: result.StatusCode}
+		if result.Err != nil {
+			outcome.Error = result.Err.Error()
+		}
+	} else {
+		prepared, err := prepareReplay(ctx, req.ID, models.CreateReplayRequest{TargetURL: *job.TargetURL}, defaultReplayOptions)
+		if err != nil {
+			outcome = replayOutcome{Status: "failed", Error: err.Error()}
+		} else {
+			replayID = &prepared.ReplayID
+			outcome = executeReplay(ctx, prepared)
+		}
+	}
+
+	var responseStatus *int
+	if outcome.ResponseStatus != 0 {
+		responseStatus = &outcome.ResponseStatus
+	}
+	var errorMsg *string
+	if outcome.Error != "" {
+		errorMsg = &outcome.Error
+	}
+
+	succeeded, failed := 0, 1
+	if outcome.Status == "success" {
+		succeeded, failed = 1, 0
+	}
+
+	_, err = db.Pool.Exec(
+		ctx,
+		`WITH item AS (
+			UPDATE bulk_replay_items
+			SET replay_id = $3, status = $4, response_status = $5, error_message = $6, completed_at = now()
+			WHERE bulk_replay_id = $1 AND request_id = $2 AND status = 'in_flight'
+			RETURNING 1
+		)
+		UPDATE bulk_replays
+		SET processed = processed + 1, succeeded = succeeded + $7, failed = failed + $8
+		WHERE id = $1 AND EXISTS (SELECT 1 FROM item)`,
+		job.ID, req.ID, replayID, outcome.Status, responseStatus, errorMsg, succeeded, failed,
+	)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to record bulk replay item", "bulk_replay_id", job.ID, "request_id", req.ID, "error", err)
+	}
+}
+
+// capturedFromRequest rebuilds the forwarding input for a stored request
+func capturedFromRequest(req models.Request) capturedRequest {
+	headersJSON, _ := json.Marshal(req.Headers)
+	values := url.Values{}
+	for k, v := range req.QueryParams {
+		values[k] = stringValues(v)
+	}
+	return capturedRequest{
+		ID:          req.ID,
+		EndpointID:  req.EndpointID,
+		Method:      req.Method,
+		Subpath:     derefString(req.Subpath),
+		RawQuery:    values.Encode(),
+		HeadersJSON: string(headersJSON),
+		Body:        []byte(derefString(req.Body)),
+	}
+}
+
+// bulkReplayColumns is the column list read by scanBulkReplay
+const bulkReplayColumns = `id, endpoint_id, filter, target_url, forwarding_rule_id, rate_per_second, concurrency, sort_order,
+	snapshot_at, status, total, processed, succeeded, failed, error_message, created_at, started_at, completed_at`
+
+func getBulkReplay(ctx context.Context, jobID uuid.UUID) (models.BulkReplay, error) {
+	row := db.Pool.QueryRow(ctx, `SELECT `+bulkReplayColumns+` FROM bulk_replays WHERE id = $1`, jobID)
+	return scanBulkReplay(row)
+}
+
+func scanBulkReplay(scanner interface {
+	Scan(dest ...interface{}) error
+}) (models.BulkReplay, error) {
+	var job models.BulkReplay
+	var filterJSON []byte
+
+	err := scanner.Scan(
+		&job.ID,
+		&job.EndpointID,
+		&filterJSON,
+		&job.TargetURL,
+		&job.ForwardingRuleID,
+		&job.RatePerSecond,
+		&job.Concurrency,
+		&job.Order,
+		&job.SnapshotAt,
+		&job.Status,
+		&job.Total,
+		&job.Processed,
+		&job.Succeeded,
+		&job.Failed,
+		&job.ErrorMessage,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.CompletedAt,
+	)
+	if err != nil {
+		return job, err
+	}
+
+	json.Unmarshal(filterJSON, &job.Filter)
+	return job, nil
+}
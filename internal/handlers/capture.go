@@ -1,41 +1,55 @@
 package handlers
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"flowhook/internal/config"
 	"flowhook/internal/db"
+	"flowhook/internal/metrics"
 	"flowhook/internal/models"
+	"flowhook/internal/tracing"
 
 	"net"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // CaptureHandler handles ANY /e/:slug - captures incoming webhooks
 func CaptureHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract slug from path: /e/:slug
-	pathParts := strings.Split(strings.TrimPrefix(r.URL.Path, "/e/"), "/")
+	// Extract slug and subpath from path: /e/:slug/*subpath
+	pathParts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/e/"), "/", 2)
 	slug := pathParts[0]
 	if slug == "" {
 		http.Error(w, "Invalid endpoint", http.StatusBadRequest)
 		return
 	}
 
-	// Get endpoint ID
+	// Keep the subpath in its escaped form so it can be forwarded verbatim
+	subpath := strings.TrimPrefix(r.URL.EscapedPath(), "/e/"+slug)
+
+	// Get endpoint ID and delivery mode
 	var endpointID uuid.UUID
+	var mode string
+	var primaryRuleID *uuid.UUID
+	var encryptPayloads bool
 	err := db.Pool.QueryRow(
 		r.Context(),
-		`SELECT id FROM endpoints WHERE slug = $1`,
+		`SELECT e.id, COALESCE(s.mode, 'capture'), s.primary_rule_id, COALESCE(s.encrypt_payloads, false)
+		 FROM endpoints e
+		 LEFT JOIN endpoint_settings s ON s.endpoint_id = e.id
+		 WHERE e.slug = $1`,
 		slug,
-	).Scan(&endpointID)
+	).Scan(&endpointID, &mode, &primaryRuleID, &encryptPayloads)
 
 	if err == pgx.ErrNoRows {
 		http.Error(w, "Endpoint not found", http.StatusNotFound)
@@ -45,6 +59,8 @@ func CaptureHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
 	}
+	endpointLabel := metrics.EndpointLabel(slug, endpointID)
+	metrics.SetCaptureEndpoint(r.Context(), endpointLabel)
 
 	// Read request body
 	maxBodySize := int64(10 * 1024 * 1024) // 10MB default
@@ -61,14 +77,17 @@ func CaptureHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
 		return
 	}
+	metrics.ObserveCaptureBody(len(body))
 
 	// Check rate limit
 	allowed, err := CheckRateLimit(r.Context(), endpointID)
 	if err != nil {
+		metrics.RateLimitRejected(endpointLabel)
 		http.Error(w, err.Error(), http.StatusTooManyRequests)
 		return
 	}
 	if !allowed {
+		metrics.RateLimitRejected(endpointLabel)
 		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 		return
 	}
@@ -84,11 +103,24 @@ func CaptureHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Apply the endpoint's redaction policy to what is stored and published
+	redaction, err := endpointRedaction(r.Context(), endpointID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load redaction policy: %v", err), http.StatusInternalServerError)
+		return
+	}
+	redactedHeader, redactedQuery, redactedBody := r.Header, r.URL.Query(), body
+	if redaction.redactor != nil {
+		redactedHeader = redaction.redactor.Headers(r.Header)
+		redactedQuery = redaction.redactor.Query(redactedQuery)
+		redactedBody = redaction.redactor.Body(body)
+	}
+
 	// Convert headers to JSON
-	headersJSON, _ := json.Marshal(r.Header)
+	headersJSON, _ := json.Marshal(redactedHeader)
 
 	// Convert query params to JSON
-	queryParamsJSON, _ := json.Marshal(r.URL.Query())
+	queryParamsJSON, _ := json.Marshal(redactedQuery)
 
 	// Get client IP and clean it for PostgreSQL INET type
 	var ip *string
@@ -105,17 +137,21 @@ func CaptureHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Generate request ID
 	requestID := uuid.New()
+	tracing.SetAttributes(r.Context(),
+		attribute.String("flowhook.endpoint.id", endpointID.String()),
+		attribute.String("flowhook.request.id", requestID.String()),
+	)
 
 	// Convert body to string for storage (handle both text and binary)
 	var bodyStr *string
-	if len(body) > 0 {
+	if len(redactedBody) > 0 {
 		// Check if body is valid UTF-8 text
-		if utf8.Valid(body) {
-			bodyString := string(body)
+		if utf8.Valid(redactedBody) {
+			bodyString := string(redactedBody)
 			bodyStr = &bodyString
 		} else {
 			// For binary data, encode as base64
-			encoded := base64.StdEncoding.EncodeToString(body)
+			encoded := base64.StdEncoding.EncodeToString(redactedBody)
 			bodyStr = &encoded
 		}
 	}
@@ -127,22 +163,33 @@ func CaptureHandler(w http.ResponseWriter, r *http.Request) {
 		contentTypePtr = &contentType
 	}
 
+	storedHeaders, storedBody, encryptedPayload, err := sealRequestPayload(encryptPayloads, string(headersJSON), bodyStr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save request: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	// Insert request into database with body stored directly
-	_, err = db.Pool.Exec(
+	var receivedAt time.Time
+	err = db.Pool.QueryRow(
 		r.Context(),
-		`INSERT INTO requests (id, endpoint_id, method, path, headers, query_params, ip, body, body_size, content_type)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		`INSERT INTO requests (id, endpoint_id, method, path, subpath, headers, query_params, ip, body, body_size, content_type, encrypted_payload, correlation_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		 RETURNING received_at`,
 		requestID,
 		endpointID,
 		r.Method,
 		r.URL.Path,
-		string(headersJSON),
+		subpath,
+		storedHeaders,
 		string(queryParamsJSON),
 		ip,
-		bodyStr,
+		storedBody,
 		len(body),
 		contentTypePtr,
-	)
+		encryptedPayload,
+		correlationID(r.Context()),
+	).Scan(&receivedAt)
 
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to save request: %v", err), http.StatusInternalServerError)
@@ -150,10 +197,54 @@ func CaptureHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Publish event for realtime updates
-	publishRequestEvent(endpointID, requestID, r.Method)
+	event := models.Request{
+		ID:            requestID,
+		EndpointID:    endpointID,
+		Method:        r.Method,
+		Path:          &r.URL.Path,
+		Subpath:       &subpath,
+		IP:            ip,
+		Body:          bodyStr,
+		BodySize:      int64(len(body)),
+		ContentType:   contentTypePtr,
+		ReceivedAt:    receivedAt,
+		CorrelationID: correlationID(r.Context()),
+	}
+	json.Unmarshal(headersJSON, &event.Headers)
+	json.Unmarshal(queryParamsJSON, &event.QueryParams)
+	publishRequestEvent(event)
+
+	captured := capturedRequest{
+		ID:          requestID,
+		EndpointID:  endpointID,
+		Method:      r.Method,
+		Subpath:     subpath,
+		RawQuery:    r.URL.RawQuery,
+		HeadersJSON: string(headersJSON),
+		Body:        body,
+	}
+	if redaction.redactor != nil {
+		if redaction.forwardUnredacted {
+			originalHeadersJSON, _ := json.Marshal(r.Header)
+			captured.HeadersJSON = string(originalHeadersJSON)
+		} else {
+			captured.RawQuery = redactedQuery.Encode()
+			captured.Body = redactedBody
+		}
+	}
+
+	// Forwarding outlives the request but stays in its trace
+	forwardCtx := context.WithoutCancel(r.Context())
+
+	// In proxy mode the sender waits for the primary rule's response
+	if mode == "proxy" {
+		ruleID := proxyRequest(w, r, captured, primaryRuleID)
+		go triggerForwarding(forwardCtx, captured, ruleID)
+		return
+	}
 
 	// Trigger forwarding asynchronously
-	go triggerForwarding(endpointID, requestID, r.Method, string(headersJSON), body)
+	go triggerForwarding(forwardCtx, captured, nil)
 
 	// Return success response
 	w.WriteHeader(http.StatusOK)
@@ -188,13 +279,7 @@ func cleanIPAddress(addr string) string {
 }
 
 // publishRequestEvent publishes a request event for SSE subscribers
-func publishRequestEvent(endpointID, requestID uuid.UUID, method string) {
-	event := models.Request{
-		ID:         requestID,
-		EndpointID: endpointID,
-		Method:     method,
-	}
-
+func publishRequestEvent(event models.Request) {
 	// Send to all SSE connections for this endpoint
-	broadcastToSSE(endpointID.String(), event)
+	broadcastToSSE(event.EndpointID.String(), event)
 }
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// codeRequest is the request a code generator reproduces
+type codeRequest struct {
+	Method  string
+	URL     string
+	Headers [][2]string // Name/value pairs in stable order; repeated names are kept
+	Body    string
+}
+
+// codeSkipHeaders are set by the client or transport and must not be copied
+var codeSkipHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+	"Accept-Encoding":   true,
+}
+
+func newCodeRequest(method, url string, headers map[string]interface{}, body string) codeRequest {
+	req := codeRequest{Method: method, URL: url, Body: body}
+	for _, k := range sortedKeys(headers) {
+		if codeSkipHeaders[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		for _, val := range stringValues(headers[k]) {
+			req.Headers = append(req.Headers, [2]string{k, val})
+		}
+	}
+	return req
+}
+
+// joinedHeaders merges repeated headers with ", " for targets that take a map
+func (c codeRequest) joinedHeaders() [][2]string {
+	var out [][2]string
+	index := map[string]int{}
+	for _, h := range c.Headers {
+		if i, ok := index[h[0]]; ok {
+			out[i][1] += ", " + h[1]
+			continue
+		}
+		index[h[0]] = len(out)
+		out = append(out, h)
+	}
+	return out
+}
+
+// generateCurl generates a cURL command
+func generateCurl(c codeRequest) string {
+	var b strings.Builder
+	piped := hasControlChars(c.Body)
+	if piped {
+		b.WriteString(shellPrintf(c.Body) + " | \\\n")
+	}
+	// --globoff keeps [] and {} in the URL from being read as curl globs
+	fmt.Fprintf(&b, "curl --globoff --request %s \\\n  --url %s", shellQuote(c.Method), shellQuote(c.URL))
+	for _, h := range c.Headers {
+		header := h[0] + ": " + h[1]
+		if h[1] == "" {
+			// "Name:" would remove the header; "Name;" sends it empty
+			header = h[0] + ";"
+		}
+		fmt.Fprintf(&b, " \\\n  --header %s", shellQuote(header))
+	}
+	if piped {
+		b.WriteString(" \\\n  --data-binary @-")
+	} else if c.Body != "" {
+		// --data-raw sends the body as is, where --data-binary would upload
+		// the file named by a body starting with @
+		fmt.Fprintf(&b, " \\\n  --data-raw %s", shellQuote(c.Body))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// generateHTTPie generates an HTTPie (http CLI) command
+func generateHTTPie(c codeRequest) string {
+	var b strings.Builder
+	piped := hasControlChars(c.Body)
+	if piped {
+		// The body is read from stdin
+		fmt.Fprintf(&b, "%s | \\\n  http %s %s", shellPrintf(c.Body), shellQuote(c.Method), shellQuote(c.URL))
+	} else {
+		fmt.Fprintf(&b, "http --ignore-stdin %s %s", shellQuote(c.Method), shellQuote(c.URL))
+	}
+	for _, h := range c.Headers {
+		item := h[0] + ":" + h[1]
+		if h[1] == "" {
+			// As with curl, "Name;" sends an empty header
+			item = h[0] + ";"
+		}
+		fmt.Fprintf(&b, " \\\n  %s", shellQuote(item))
+	}
+	if c.Body != "" && !piped {
+		fmt.Fprintf(&b, " \\\n  --raw %s", shellQuote(c.Body))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// generateRawHTTP generates a plain HTTP message, as used by .http files
+func generateRawHTTP(c codeRequest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\n", c.Method, c.URL)
+	for _, h := range c.Headers {
+		fmt.Fprintf(&b, "%s: %s\n", h[0], h[1])
+	}
+	if c.Body != "" {
+		b.WriteString("\n" + c.Body)
+	}
+	return b.String()
+}
+
+// generateFetch generates a JavaScript fetch call
+func generateFetch(c codeRequest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "const response = await fetch(%s, {\n", quoteJSON(c.URL))
+	fmt.Fprintf(&b, "  method: %s,\n", quoteJSON(c.Method))
+	if headers := c.joinedHeaders(); len(headers) > 0 {
+		b.WriteString("  headers: {\n")
+		for _, h := range headers {
+			fmt.Fprintf(&b, "    %s: %s,\n", quoteJSON(h[0]), quoteJSON(h[1]))
+		}
+		b.WriteString("  },\n")
+	}
+	if c.Body != "" {
+		fmt.Fprintf(&b, "  body: %s,\n", quoteJSON(c.Body))
+	}
+	b.WriteString("});\n\nconsole.log(response.status, await response.text());\n")
+	return b.String()
+}
+
+// generatePython generates a Python script using requests
+func generatePython(c codeRequest) string {
+	var b strings.Builder
+	b.WriteString("import requests\n\n")
+	fmt.Fprintf(&b, "url = %s\n", quoteJSON(c.URL))
+
+	args := ""
+	if headers := c.joinedHeaders(); len(headers) > 0 {
+		b.WriteString("headers = {\n")
+		for _, h := range headers {
+			fmt.Fprintf(&b, "    %s: %s,\n", quoteJSON(h[0]), quoteJSON(h[1]))
+		}
+		b.WriteString("}\n")
+		args += ", headers=headers"
+	}
+	if c.Body != "" {
+		// Encode explicitly; requests would send str bodies as Latin-1
+		fmt.Fprintf(&b, "data = %s.encode(\"utf-8\")\n", quoteJSON(c.Body))
+		args += ", data=data"
+	}
+
+	fmt.Fprintf(&b, "\nresponse = requests.request(%s, url%s)\n", quoteJSON(c.Method), args)
+	b.WriteString("print(response.status_code)\nprint(response.text)\n")
+	return b.String()
+}
+
+// generateGo generates a Go program using net/http
+func generateGo(c codeRequest) string {
+	var b strings.Builder
+	b.WriteString("package main\n\nimport (\n\t\"fmt\"\n\t\"io\"\n\t\"net/http\"\n")
+	if c.Body != "" {
+		b.WriteString("\t\"strings\"\n")
+	}
+	b.WriteString(")\n\nfunc main() {\n")
+
+	bodyArg := "nil"
+	if c.Body != "" {
+		fmt.Fprintf(&b, "\tbody := strings.NewReader(%s)\n", strconv.Quote(c.Body))
+		bodyArg = "body"
+	}
+	fmt.Fprintf(&b, "\treq, err := http.NewRequest(%s, %s, %s)\n", strconv.Quote(c.Method), strconv.Quote(c.URL), bodyArg)
+	b.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	for _, h := range c.Headers {
+		fmt.Fprintf(&b, "\treq.Header.Add(%s, %s)\n", strconv.Quote(h[0]), strconv.Quote(h[1]))
+	}
+
+	b.WriteString(`
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(resp.Status)
+	fmt.Println(string(respBody))
+}
+`)
+	return b.String()
+}
+
+// shellQuote quotes s for POSIX shells. Single quotes disable every expansion,
+// so only embedded single quotes need escaping.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// hasControlChars reports whether s has control characters other than
+// newlines and tabs, which shell commands should not carry raw: pasted into
+// a terminal they can be interpreted by it
+func hasControlChars(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < 0x20 && c != '\n' && c != '\t') || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// shellPrintf returns a printf command writing s exactly, with newlines and
+// control characters escaped
+func shellPrintf(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case (c < 0x20 && c != '\t') || c == 0x7f:
+			fmt.Fprintf(&b, `\0%03o`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "printf '%b' " + shellQuote(b.String())
+}
+
+// quoteJSON renders s as a JSON string literal, which is also a valid
+// JavaScript and Python string literal
+func quoteJSON(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// codegenCases are the requests every code format is checked against
+var codegenCases = []struct {
+	name    string
+	method  string
+	url     string
+	headers map[string]interface{}
+	body    string
+}{
+	{
+		// Shell metacharacters, quotes and curl glob characters must survive
+		name:   "quoting",
+		method: "POST",
+		url:    "https://hooks.example.com/e/demo/orders?filter[status]=paid&q=it%27s&ids={1,2}",
+		headers: map[string]interface{}{
+			"Content-Type": "application/json",
+			"X-Note":       "it's $HOME `date` \"quoted\" \\ backslash",
+		},
+		body: `{"msg":"it's \"quoted\" $HOME $(id) \\ backslash","path":"C:\\temp"}`,
+	},
+	{
+		// A body curl's --data-binary would read as a file name
+		name:   "at_sign",
+		method: "POST",
+		url:    "https://hooks.example.com/e/demo",
+		headers: map[string]interface{}{
+			"Content-Type": "text/plain",
+		},
+		body: "@/etc/passwd",
+	},
+	{
+		// Control characters and non-ASCII text
+		name:   "binary",
+		method: "PUT",
+		url:    "https://hooks.example.com/e/demo/upload",
+		headers: map[string]interface{}{
+			"Content-Type": "application/octet-stream",
+		},
+		body: "@/etc/passwd\r\n\x1b[31mred\x1b[0m\ttab café \u2028 end\n",
+	},
+	{
+		// Repeated headers are kept apart where the target allows it, empty
+		// ones are sent empty and transport headers are dropped
+		name:   "multi_value_headers",
+		method: "GET",
+		url:    "https://hooks.example.com/e/demo",
+		headers: map[string]interface{}{
+			"Accept":         []interface{}{"text/html", "application/json"},
+			"X-Empty":        "",
+			"Host":           "hooks.example.com",
+			"Content-Length": "0",
+		},
+	},
+}
+
+func TestCodeFormatsGolden(t *testing.T) {
+	formats := make([]string, 0, len(codeFormats))
+	for name := range codeFormats {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats)
+
+	for _, tc := range codegenCases {
+		req := newCodeRequest(tc.method, tc.url, tc.headers, tc.body)
+		for _, format := range formats {
+			t.Run(tc.name+"/"+format, func(t *testing.T) {
+				got := codeFormats[format].Generate(req)
+				path := filepath.Join("testdata", "codegen", tc.name+"."+format+".golden")
+
+				if *updateGolden {
+					if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("missing golden file, run go test -update: %v", err)
+				}
+				if got != string(want) {
+					t.Errorf("%s output differs from %s:\ngot:\n%s\nwant:\n%s", format, path, got, want)
+				}
+			})
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"flowhook/internal/config"
 	"flowhook/internal/db"
 	"flowhook/internal/logger"
 
@@ -36,7 +39,7 @@ func GetDeliveryStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err != nil {
-		logger.Error("Database error: %v", err)
+		logger.ErrorContext(r.Context(), "Database error", "error", err)
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -49,7 +52,7 @@ func GetDeliveryStats(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	since := time.Now().Add(-time.Duration(hours) * 24 * time.Hour)
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
 
 	// Get forwarding rules for this endpoint
 	rows, err := db.Pool.Query(
@@ -58,7 +61,7 @@ func GetDeliveryStats(w http.ResponseWriter, r *http.Request) {
 		endpointID,
 	)
 	if err != nil {
-		logger.Error("Failed to fetch forwarding rules: %v", err)
+		logger.ErrorContext(r.Context(), "Failed to fetch forwarding rules", "error", err)
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -75,6 +78,7 @@ func GetDeliveryStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var allStats []RuleStats
+	var hourlyData []map[string]interface{}
 
 	for rows.Next() {
 		var ruleID uuid.UUID
@@ -84,30 +88,27 @@ func GetDeliveryStats(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Get stats for this rule
-		var stats RuleStats
-		err := db.Pool.QueryRow(
-			r.Context(),
-			`SELECT 
-				COUNT(*) as total,
-				COUNT(*) FILTER (WHERE status = 'success') as successful,
-				COUNT(*) FILTER (WHERE status = 'failed') as failed,
-				AVG(duration_ms) as avg_duration
-			 FROM forward_attempts 
-			 WHERE forwarding_rule_id = $1 AND attempted_at >= $2`,
-			ruleID,
-			since,
-		).Scan(
-			&stats.Total,
-			&stats.Successful,
-			&stats.Failed,
-			&stats.AvgDuration,
-		)
-
-		if err != nil && err != pgx.ErrNoRows {
-			logger.Error("Failed to fetch stats: %v", err)
+		buckets, err := deliveryBuckets(r.Context(), ruleID, since)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to fetch stats", "error", err)
 			continue
 		}
 
+		var stats RuleStats
+		var durationCount int
+		var totalDurationMs int64
+		for _, bucket := range buckets {
+			stats.Total += bucket.total
+			stats.Successful += bucket.successful
+			stats.Failed += bucket.failed
+			durationCount += bucket.durationCount
+			totalDurationMs += bucket.totalDurationMs
+		}
+		if durationCount > 0 {
+			avg := float64(totalDurationMs) / float64(durationCount)
+			stats.AvgDuration = &avg
+		}
+
 		stats.RuleID = ruleID
 		stats.TargetURL = targetURL
 		if stats.Total > 0 {
@@ -115,39 +116,10 @@ func GetDeliveryStats(w http.ResponseWriter, r *http.Request) {
 		}
 
 		allStats = append(allStats, stats)
-	}
 
-	// Get hourly breakdown for the first rule (or aggregate)
-	var hourlyData []map[string]interface{}
-	if len(allStats) > 0 {
-		hourlyRows, err := db.Pool.Query(
-			r.Context(),
-			`SELECT 
-				DATE_TRUNC('hour', attempted_at) as hour,
-				COUNT(*) as total,
-				COUNT(*) FILTER (WHERE status = 'success') as successful,
-				COUNT(*) FILTER (WHERE status = 'failed') as failed
-			 FROM forward_attempts 
-			 WHERE forwarding_rule_id = $1 AND attempted_at >= $2
-			 GROUP BY hour
-			 ORDER BY hour`,
-			allStats[0].RuleID,
-			since,
-		)
-		if err == nil {
-			defer hourlyRows.Close()
-			for hourlyRows.Next() {
-				var hour time.Time
-				var total, successful, failed int
-				if err := hourlyRows.Scan(&hour, &total, &successful, &failed); err == nil {
-					hourlyData = append(hourlyData, map[string]interface{}{
-						"hour":      hour.Format(time.RFC3339),
-						"total":     total,
-						"successful": successful,
-						"failed":    failed,
-					})
-				}
-			}
+		// Hourly breakdown for the first rule
+		if len(allStats) == 1 {
+			hourlyData = hourlyBreakdown(buckets)
 		}
 	}
 
@@ -176,29 +148,38 @@ func GetRuleDeliveryTimeline(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse limit (default to 100)
-	limit := 100
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := time.ParseDuration(limitStr + "h"); err == nil {
-			limit = int(l.Hours())
+	// bucket=hour returns hourly totals instead of individual attempts
+	if bucket := r.URL.Query().Get("bucket"); bucket != "" {
+		if bucket != "hour" {
+			http.Error(w, "bucket must be hour", http.StatusBadRequest)
+			return
 		}
+		getRuleHourlyTimeline(w, r, ruleID)
+		return
 	}
 
-	rows, err := db.Pool.Query(
-		r.Context(),
+	// Parse cursor and limit (default to 100)
+	cursor, limit, err := parsePageParams(r, 100, 500)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query, args := applyKeyset(
 		`SELECT 
 			id, request_id, attempt_number, status, response_status, 
-			error_message, duration_ms, attempted_at
+			error_message, duration_ms, attempted_at,
+			(SELECT json_build_object('id', sa.id, 'status', sa.status, 'response_status', sa.response_status,
+			                          'duration_ms', sa.duration_ms, 'status_match', sa.status_match, 'match', sa.match)
+			 FROM shadow_attempts sa WHERE sa.primary_attempt_id = forward_attempts.id LIMIT 1)
 		 FROM forward_attempts 
-		 WHERE forwarding_rule_id = $1 
-		 ORDER BY attempted_at DESC 
-		 LIMIT $2`,
-		ruleID,
-		limit,
+		 WHERE forwarding_rule_id = $1`,
+		[]interface{}{ruleID}, "attempted_at", cursor, limit,
 	)
+	rows, err := db.Pool.Query(r.Context(), query, args...)
 
 	if err != nil {
-		logger.Error("Failed to fetch timeline: %v", err)
+		logger.ErrorContext(r.Context(), "Failed to fetch timeline", "error", err)
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -213,11 +194,13 @@ func GetRuleDeliveryTimeline(w http.ResponseWriter, r *http.Request) {
 		ErrorMessage  *string     `json:"error_message,omitempty"`
 		DurationMs    *int        `json:"duration_ms,omitempty"`
 		AttemptedAt   time.Time   `json:"attempted_at"`
+		Shadow        json.RawMessage `json:"shadow,omitempty"` // Shadow comparison, when the rule mirrors
 	}
 
 	var timeline []TimelineEntry
 	for rows.Next() {
 		var entry TimelineEntry
+		var shadowJSON []byte
 		err := rows.Scan(
 			&entry.ID,
 			&entry.RequestID,
@@ -227,15 +210,216 @@ func GetRuleDeliveryTimeline(w http.ResponseWriter, r *http.Request) {
 			&entry.ErrorMessage,
 			&entry.DurationMs,
 			&entry.AttemptedAt,
+			&shadowJSON,
 		)
 		if err != nil {
-			logger.Error("Failed to scan timeline entry: %v", err)
+			logger.ErrorContext(r.Context(), "Failed to scan timeline entry", "error", err)
 			continue
 		}
+		if len(shadowJSON) > 0 {
+			entry.Shadow = shadowJSON
+		}
 		timeline = append(timeline, entry)
 	}
 
+	timeline, nextCursor, prevCursor := finishPage(timeline, cursor, limit, func(entry TimelineEntry) (time.Time, uuid.UUID) {
+		return entry.AttemptedAt, entry.ID
+	})
+	setCursorHeaders(w, nextCursor, prevCursor)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeline)
+}
+
+// getRuleHourlyTimeline writes the hourly delivery totals of a rule over the
+// last hours hours (default 24)
+func getRuleHourlyTimeline(w http.ResponseWriter, r *http.Request, ruleID uuid.UUID) {
+	hours := 24
+	if hoursStr := r.URL.Query().Get("hours"); hoursStr != "" {
+		h, err := strconv.Atoi(hoursStr)
+		if err != nil || h < 1 {
+			http.Error(w, "hours must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		hours = h
+	}
+
+	buckets, err := deliveryBuckets(r.Context(), ruleID, time.Now().Add(-time.Duration(hours)*time.Hour))
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to fetch timeline", "error", err)
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	timeline := hourlyBreakdown(buckets)
+	if timeline == nil {
+		timeline = []map[string]interface{}{}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(timeline)
 }
 
+// deliveryBucket is one hour of a forwarding rule's attempts
+type deliveryBucket struct {
+	hour            time.Time
+	total           int
+	successful      int
+	failed          int
+	durationCount   int
+	totalDurationMs int64
+}
+
+// deliveryBucketsQuery reads the hourly buckets of rule $1 from the hour of
+// $2 on: the hours rolled up in delivery_stats, then the attempts made since
+// its last refresh. Without a refresh, everything comes from forward_attempts.
+const deliveryBucketsQuery = `
+	WITH watermark AS (
+		SELECT COALESCE(
+			(SELECT refreshed_through FROM materialized_view_refreshes WHERE view_name = 'delivery_stats'),
+			'-infinity'
+		) AS through
+	)
+	SELECT hour, total_attempts, successful, failed, duration_count, total_duration_ms
+	FROM delivery_stats, watermark
+	WHERE forwarding_rule_id = $1
+	  AND hour >= DATE_TRUNC('hour', $2::timestamptz)
+	  AND hour < watermark.through
+	UNION ALL
+	SELECT
+		DATE_TRUNC('hour', attempted_at),
+		COUNT(*),
+		COUNT(*) FILTER (WHERE status = 'success'),
+		COUNT(*) FILTER (WHERE status = 'failed'),
+		COUNT(duration_ms),
+		COALESCE(SUM(duration_ms), 0)
+	FROM forward_attempts, watermark
+	WHERE forwarding_rule_id = $1
+	  AND attempted_at >= GREATEST(DATE_TRUNC('hour', $2::timestamptz), watermark.through)
+	GROUP BY DATE_TRUNC('hour', attempted_at)
+	ORDER BY hour`
+
+// deliveryBuckets returns the hourly buckets of a rule's attempts since the
+// hour of since, oldest first
+func deliveryBuckets(ctx context.Context, ruleID uuid.UUID, since time.Time) ([]deliveryBucket, error) {
+	rows, err := db.Pool.Query(ctx, deliveryBucketsQuery, ruleID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []deliveryBucket
+	for rows.Next() {
+		var bucket deliveryBucket
+		err := rows.Scan(
+			&bucket.hour,
+			&bucket.total,
+			&bucket.successful,
+			&bucket.failed,
+			&bucket.durationCount,
+			&bucket.totalDurationMs,
+		)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+// hourlyBreakdown formats buckets as delivery-stats hourly_breakdown entries
+func hourlyBreakdown(buckets []deliveryBucket) []map[string]interface{} {
+	var hourly []map[string]interface{}
+	for _, bucket := range buckets {
+		hourly = append(hourly, map[string]interface{}{
+			"hour":       bucket.hour.Format(time.RFC3339),
+			"total":      bucket.total,
+			"successful": bucket.successful,
+			"failed":     bucket.failed,
+		})
+	}
+	return hourly
+}
+
+// StartDeliveryStatsWorker refreshes delivery_stats once each hour has ended,
+// checking every STATS_REFRESH_INTERVAL minutes until ctx is cancelled. Only
+// one replica refreshes at a time.
+func StartDeliveryStatsWorker(ctx context.Context) {
+	interval := 5 * time.Minute
+	if config.AppConfig != nil && config.AppConfig.StatsRefreshInterval > 0 {
+		interval = time.Duration(config.AppConfig.StatsRefreshInterval) * time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			runCtx := logger.WithJob(ctx, "delivery stats refresh")
+			ran, err := db.WithAdvisoryLock(runCtx, db.LockDeliveryStats, refreshStaleDeliveryStats)
+			if err != nil {
+				logger.ErrorContext(runCtx, "Delivery stats refresh failed", "error", err)
+			} else if !ran {
+				logger.DebugContext(runCtx, "Delivery stats refresh skipped, another replica holds the lock")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// refreshStaleDeliveryStats refreshes delivery_stats unless it already covers
+// every hour that has ended. The view only gains rows when an hour ends, so
+// refreshing it more often would recompute the same rollup.
+func refreshStaleDeliveryStats(ctx context.Context) error {
+	var current bool
+	err := db.Pool.QueryRow(
+		ctx,
+		`SELECT EXISTS (
+			SELECT 1 FROM materialized_view_refreshes
+			WHERE view_name = 'delivery_stats' AND refreshed_through >= DATE_TRUNC('hour', now())
+		)`,
+	).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if current {
+		return nil
+	}
+	return RefreshDeliveryStats(ctx)
+}
+
+// RefreshDeliveryStats rolls up the attempts of every hour that has ended into
+// delivery_stats, without blocking readers. The view and its watermark are
+// updated in one transaction, so both use the same now().
+func RefreshDeliveryStats(ctx context.Context) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY delivery_stats`); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO materialized_view_refreshes (view_name, refreshed_through)
+		 VALUES ('delivery_stats', DATE_TRUNC('hour', now()))
+		 ON CONFLICT (view_name) DO UPDATE
+		 SET refreshed_through = EXCLUDED.refreshed_through, refreshed_at = clock_timestamp()`,
+	)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	logger.DebugContext(ctx, "Refreshed delivery stats")
+	return nil
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"flowhook/internal/db"
+	"flowhook/internal/diff"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// diffSources maps a source name to the query loading its response
+var diffSources = map[string]string{
+	"replay": `SELECT response_status, response_headers, response_body, transformed_response_body,
+	                  NULL::integer, COALESCE(last_attempt_at, created_at)
+	           FROM replays WHERE id = $1`,
+	"replay_attempt": `SELECT response_status, response_headers, response_body, transformed_response_body,
+	                          duration_ms, attempted_at
+	                   FROM replay_attempts WHERE id = $1`,
+	"forward_attempt": `SELECT response_status, response_headers, response_body, transformed_response_body,
+	                           duration_ms, attempted_at
+	                    FROM forward_attempts WHERE id = $1`,
+	"shadow_attempt": `SELECT response_status, response_headers, response_body, NULL::text,
+	                          duration_ms, attempted_at
+	                   FROM shadow_attempts WHERE id = $1`,
+}
+
+// diffSide describes one of the compared responses
+type diffSide struct {
+	Source     string    `json:"source"`
+	ID         uuid.UUID `json:"id"`
+	Status     int       `json:"status"`
+	DurationMs *int      `json:"duration_ms,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// diffResponse is the result of DiffResponses
+type diffResponse struct {
+	Base    diffSide `json:"base"`
+	Compare diffSide `json:"compare"`
+	diff.Result
+}
+
+// DiffResponses handles GET /api/v1/diff?base=<source>:<id>&compare=<source>:<id>&ignore=<path>
+// where source is replay, replay_attempt, forward_attempt or shadow_attempt. ignore may be repeated;
+// transformed=true compares transformed response bodies where available.
+func DiffResponses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	transformed := query.Get("transformed") == "true"
+
+	base, baseResp, err := loadDiffResponse(r.Context(), query.Get("base"), transformed)
+	if err != nil {
+		writeDiffLoadError(w, "base", err)
+		return
+	}
+	compare, compareResp, err := loadDiffResponse(r.Context(), query.Get("compare"), transformed)
+	if err != nil {
+		writeDiffLoadError(w, "compare", err)
+		return
+	}
+
+	response := diffResponse{
+		Base:    base,
+		Compare: compare,
+		Result:  diff.Compare(baseResp, compareResp, query["ignore"]),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// errInvalidDiffRef is returned for a malformed <source>:<id> reference
+var errInvalidDiffRef = errors.New("must be <source>:<id> with source one of: replay, replay_attempt, forward_attempt, shadow_attempt")
+
+func writeDiffLoadError(w http.ResponseWriter, param string, err error) {
+	switch err {
+	case errInvalidDiffRef:
+		http.Error(w, fmt.Sprintf("%s %v", param, err), http.StatusBadRequest)
+	case pgx.ErrNoRows:
+		http.Error(w, fmt.Sprintf("%s response not found", param), http.StatusNotFound)
+	default:
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// loadDiffResponse loads the response referenced by a <source>:<id> string
+func loadDiffResponse(ctx context.Context, ref string, transformed bool) (diffSide, diff.Response, error) {
+	source, idStr, _ := strings.Cut(ref, ":")
+	query, ok := diffSources[source]
+	id, err := uuid.Parse(idStr)
+	if !ok || err != nil {
+		return diffSide{}, diff.Response{}, errInvalidDiffRef
+	}
+
+	side := diffSide{Source: source, ID: id}
+	var status *int
+	var headersJSON []byte
+	var body, transformedBody *string
+	err = db.Pool.QueryRow(ctx, query, id).Scan(&status, &headersJSON, &body, &transformedBody, &side.DurationMs, &side.At)
+	if err != nil {
+		return side, diff.Response{}, err
+	}
+
+	resp := diff.Response{Body: body}
+	if status != nil {
+		side.Status = *status
+		resp.Status = *status
+	}
+	if len(headersJSON) > 0 {
+		json.Unmarshal(headersJSON, &resp.Headers)
+	}
+	if transformed && transformedBody != nil {
+		resp.Body = transformedBody
+	}
+	return side, resp, nil
+}
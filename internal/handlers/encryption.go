@@ -0,0 +1,637 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"flowhook/internal/db"
+	"flowhook/internal/logger"
+	"flowhook/internal/secrets"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// maskedValue replaces secrets in API responses; sending it back on update
+// keeps the stored value
+const maskedValue = "***"
+
+// sensitiveHeaderParts mark forwarding rule headers whose values are
+// encrypted at rest and masked in responses
+var sensitiveHeaderParts = []string{"authorization", "cookie", "token", "secret", "password", "api-key", "apikey", "signature"}
+
+// isSensitiveHeader reports whether a header's value should be treated as a secret
+func isSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range sensitiveHeaderParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// sealRuleHeaders encrypts the values of sensitive rule headers for storage.
+// A masked value keeps the matching header of existing, the stored rule's
+// decrypted headers.
+func sealRuleHeaders(headers, existing map[string]interface{}) (map[string]interface{}, error) {
+	if headers == nil {
+		return nil, nil
+	}
+	sealed := make(map[string]interface{}, len(headers))
+	for name, value := range headers {
+		str, ok := value.(string)
+		if !ok || !isSensitiveHeader(name) {
+			sealed[name] = value
+			continue
+		}
+		if str == maskedValue {
+			previous, ok := existing[name].(string)
+			if !ok {
+				return nil, fmt.Errorf("header %s has no stored value to keep", name)
+			}
+			str = previous
+		}
+		encrypted, err := secrets.EncryptString(str)
+		if err != nil {
+			return nil, err
+		}
+		sealed[name] = encrypted
+	}
+	return sealed, nil
+}
+
+// openRuleHeaders decrypts stored rule header values in place
+func openRuleHeaders(headers map[string]interface{}) error {
+	for name, value := range headers {
+		if str, ok := value.(string); ok && secrets.IsEncrypted(str) {
+			plaintext, err := secrets.DecryptString(str)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt header %s: %v", name, err)
+			}
+			headers[name] = plaintext
+		}
+	}
+	return nil
+}
+
+// maskRuleHeaders returns headers with sensitive values masked
+func maskRuleHeaders(headers map[string]interface{}) map[string]interface{} {
+	if headers == nil {
+		return nil
+	}
+	masked := make(map[string]interface{}, len(headers))
+	for name, value := range headers {
+		if _, ok := value.(string); ok && isSensitiveHeader(name) {
+			value = maskedValue
+		}
+		masked[name] = value
+	}
+	return masked
+}
+
+// requestPayload is the encrypted form of a captured request's headers and body
+type requestPayload struct {
+	Headers json.RawMessage `json:"headers"`
+	Body    *string         `json:"body,omitempty"`
+}
+
+// sealRequestPayload returns the headers, body and encrypted payload to store
+// for a captured request. With encrypt set, headers and body are replaced by
+// empty values and only the encrypted payload holds them.
+func sealRequestPayload(encrypt bool, headersJSON string, body *string) (string, *string, *string, error) {
+	if !encrypt {
+		return headersJSON, body, nil, nil
+	}
+	plaintext, _ := json.Marshal(requestPayload{Headers: json.RawMessage(headersJSON), Body: body})
+	encrypted, err := secrets.Encrypt(plaintext)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to encrypt request: %v", err)
+	}
+	return "{}", nil, &encrypted, nil
+}
+
+// openRequestPayload decrypts a stored payload, returning the request's
+// headers JSON and body
+func openRequestPayload(encrypted string) (string, *string, error) {
+	plaintext, err := secrets.Decrypt(encrypted)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decrypt request: %v", err)
+	}
+	var payload requestPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return "", nil, fmt.Errorf("failed to decode request: %v", err)
+	}
+	return string(payload.Headers), payload.Body, nil
+}
+
+// endpointEncryptsPayloads reports whether an endpoint stores captured
+// requests encrypted
+func endpointEncryptsPayloads(ctx context.Context, endpointID uuid.UUID) (bool, error) {
+	var encrypt bool
+	err := db.Pool.QueryRow(
+		ctx,
+		`SELECT COALESCE((SELECT encrypt_payloads FROM endpoint_settings WHERE endpoint_id = $1), false)`,
+		endpointID,
+	).Scan(&encrypt)
+	return encrypt, err
+}
+
+// rotationBatchSize is the number of captured requests re-encrypted per query
+const rotationBatchSize = 500
+
+// keyRotation is the latest master key rotation, as recorded in key_rotations
+type keyRotation struct {
+	Running    bool           `json:"running"`
+	KeyID      string         `json:"key_id,omitempty"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Rotated    map[string]int `json:"rotated,omitempty"` // Values re-encrypted, by kind
+	Error      *string        `json:"error,omitempty"`
+}
+
+// errRotationRunning is returned when another replica holds the rotation lock
+var errRotationRunning = errors.New("key rotation already running")
+
+// GetEncryptionStatus handles GET /api/v1/encryption
+func GetEncryptionStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current, err := latestKeyRotation(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"configured":     secrets.Configured(),
+		"current_key_id": secrets.CurrentKeyID(),
+		"rotation":       current,
+	})
+}
+
+// RotateEncryptionKeys handles POST /api/v1/encryption/rotate. It re-encrypts
+// every stored secret and encrypted request under the current master key in
+// the background, and encrypts HMAC secrets and sensitive rule headers stored
+// before encryption was configured.
+func RotateEncryptionKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !secrets.Configured() {
+		http.Error(w, secrets.ErrNotConfigured.Error(), http.StatusBadRequest)
+		return
+	}
+
+	started := make(chan error, 1)
+	go runKeyRotation(started)
+	if err := <-started; err == errRotationRunning {
+		http.Error(w, "A key rotation is already running", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to start key rotation: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	current, err := latestKeyRotation(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(current)
+}
+
+// latestKeyRotation reads the most recent rotation. A rotation still marked
+// running whose lease expired was interrupted by its replica stopping.
+func latestKeyRotation(ctx context.Context) (keyRotation, error) {
+	var rotation keyRotation
+	var status string
+	var leaseActive bool
+	var rotatedJSON []byte
+	err := db.Pool.QueryRow(
+		ctx,
+		`SELECT key_id, status, COALESCE(lease_expires_at > now(), false), started_at, finished_at, rotated, error_message
+		 FROM key_rotations
+		 ORDER BY started_at DESC
+		 LIMIT 1`,
+	).Scan(&rotation.KeyID, &status, &leaseActive, &rotation.StartedAt, &rotation.FinishedAt, &rotatedJSON, &rotation.Error)
+	if err == pgx.ErrNoRows {
+		return keyRotation{}, nil
+	}
+	if err != nil {
+		return keyRotation{}, err
+	}
+
+	json.Unmarshal(rotatedJSON, &rotation.Rotated)
+	if status == "running" {
+		rotation.Running = leaseActive
+		if !leaseActive {
+			msg := "Interrupted: the server running it stopped"
+			rotation.Error = &msg
+		}
+	}
+	return rotation, nil
+}
+
+// runKeyRotation performs a rotation while holding the rotation lock, so only
+// one replica rotates at a time. It sends on started once the rotation is
+// recorded, or the reason it could not start.
+func runKeyRotation(started chan<- error) {
+	ctx := logger.WithJob(context.Background(), "key rotation")
+	ran, err := db.WithAdvisoryLock(ctx, db.LockKeyRotation, func(ctx context.Context) error {
+		rotationID, err := beginKeyRotation(ctx)
+		started <- err
+		if err != nil {
+			return nil
+		}
+		rotateKeys(ctx, rotationID)
+		return nil
+	})
+	if !ran {
+		if err == nil {
+			err = errRotationRunning
+		}
+		started <- err
+	}
+}
+
+// beginKeyRotation records a new rotation. Rotations left running hold no
+// lock, as the caller has it, so they were interrupted and are failed.
+func beginKeyRotation(ctx context.Context) (uuid.UUID, error) {
+	_, err := db.Pool.Exec(
+		ctx,
+		`UPDATE key_rotations
+		 SET status = 'failed', error_message = 'Interrupted: the server running it stopped', finished_at = now(), lease_expires_at = NULL
+		 WHERE status = 'running'`,
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	var rotationID uuid.UUID
+	err = db.Pool.QueryRow(
+		ctx,
+		`INSERT INTO key_rotations (key_id, lease_expires_at) VALUES ($1, now() + make_interval(secs => $2)) RETURNING id`,
+		secrets.CurrentKeyID(), jobLeaseDuration.Seconds(),
+	).Scan(&rotationID)
+	return rotationID, err
+}
+
+// rotateKeys re-encrypts every kind of stored value, recording progress on the
+// rotation's row as it goes
+func rotateKeys(ctx context.Context, rotationID uuid.UUID) {
+	var mu sync.Mutex
+	rotated := map[string]int{}
+	counted := func(kind string) func(int) {
+		return func(n int) {
+			mu.Lock()
+			rotated[kind] += n
+			mu.Unlock()
+		}
+	}
+	progress := func() []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		encoded, _ := json.Marshal(rotated)
+		return encoded
+	}
+
+	// Renew the lease and publish progress while rotating
+	heartbeatDone := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heartbeatDone:
+				return
+			case <-ticker.C:
+				_, err := db.Pool.Exec(ctx,
+					`UPDATE key_rotations SET rotated = $2, lease_expires_at = now() + make_interval(secs => $3) WHERE id = $1`,
+					rotationID, progress(), jobLeaseDuration.Seconds())
+				if err != nil {
+					// The lease outlasts a few missed heartbeats
+					logger.WarnContext(ctx, "Failed to renew key rotation lease", "error", err)
+				}
+			}
+		}
+	}()
+
+	err := rotateHMACSecrets(ctx, counted("hmac_secrets"))
+	if err == nil {
+		err = rotateInstallSecrets(ctx, counted("install_secrets"))
+	}
+	if err == nil {
+		err = rotateRuleSecrets(ctx, counted("rule_secrets"))
+	}
+	if err == nil {
+		err = rotatePayloads(ctx, "requests", counted("requests"))
+	}
+	if err == nil {
+		err = rotatePayloads(ctx, "replays", counted("replays"))
+	}
+	close(heartbeatDone)
+
+	status := "completed"
+	var errMsg *string
+	if err != nil {
+		status = "failed"
+		msg := err.Error()
+		errMsg = &msg
+		logger.ErrorContext(ctx, "Key rotation failed", "key_id", secrets.CurrentKeyID(), "error", err)
+	}
+	_, dbErr := db.Pool.Exec(
+		ctx,
+		`UPDATE key_rotations
+		 SET status = $2, rotated = $3, error_message = $4, finished_at = now(), lease_expires_at = NULL
+		 WHERE id = $1`,
+		rotationID, status, progress(), errMsg,
+	)
+	if dbErr != nil {
+		logger.ErrorContext(ctx, "Failed to record key rotation outcome", "error", dbErr)
+	}
+}
+
+func rotateHMACSecrets(ctx context.Context, count func(int)) error {
+	rows, err := db.Pool.Query(ctx, `SELECT endpoint_id, hmac_secret FROM endpoint_settings WHERE hmac_secret IS NOT NULL AND hmac_secret != ''`)
+	if err != nil {
+		return err
+	}
+	type setting struct {
+		endpointID uuid.UUID
+		secret     string
+	}
+	var settings []setting
+	for rows.Next() {
+		var s setting
+		if err := rows.Scan(&s.endpointID, &s.secret); err != nil {
+			rows.Close()
+			return err
+		}
+		settings = append(settings, s)
+	}
+	rows.Close()
+
+	for _, s := range settings {
+		if !secrets.NeedsRotation(s.secret) {
+			continue
+		}
+		rotated, err := secrets.Rotate(s.secret)
+		if err != nil {
+			return fmt.Errorf("endpoint %s hmac_secret: %v", s.endpointID, err)
+		}
+		_, err = db.Pool.Exec(ctx, `UPDATE endpoint_settings SET hmac_secret = $1 WHERE endpoint_id = $2 AND hmac_secret = $3`, rotated, s.endpointID, s.secret)
+		if err != nil {
+			return err
+		}
+		count(1)
+	}
+	return nil
+}
+
+func rotateInstallSecrets(ctx context.Context, count func(int)) error {
+	rows, err := db.Pool.Query(ctx, `SELECT name, value FROM install_secrets`)
+	if err != nil {
+		return err
+	}
+	type installValue struct {
+		name  string
+		value string
+	}
+	var values []installValue
+	for rows.Next() {
+		var v installValue
+		if err := rows.Scan(&v.name, &v.value); err != nil {
+			rows.Close()
+			return err
+		}
+		values = append(values, v)
+	}
+	rows.Close()
+
+	for _, v := range values {
+		if !secrets.NeedsRotation(v.value) {
+			continue
+		}
+		rotated, err := secrets.Rotate(v.value)
+		if err != nil {
+			return fmt.Errorf("install secret %s: %v", v.name, err)
+		}
+		_, err = db.Pool.Exec(ctx, `UPDATE install_secrets SET value = $1 WHERE name = $2 AND value = $3`, rotated, v.name, v.value)
+		if err != nil {
+			return err
+		}
+		count(1)
+	}
+	return nil
+}
+
+// installSecret returns the random per-install secret called name, creating
+// it with size bytes on first use. When replicas race to create it, the first
+// stored value wins.
+func installSecret(ctx context.Context, name string, size int) ([]byte, error) {
+	generated := make([]byte, size)
+	if _, err := rand.Read(generated); err != nil {
+		return nil, err
+	}
+	stored, err := secrets.EncryptString(base64.StdEncoding.EncodeToString(generated))
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Pool.Exec(ctx, `INSERT INTO install_secrets (name, value) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING`, name, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	var value string
+	if err := db.Pool.QueryRow(ctx, `SELECT value FROM install_secrets WHERE name = $1`, name).Scan(&value); err != nil {
+		return nil, err
+	}
+	encoded, err := secrets.DecryptString(value)
+	if err != nil {
+		return nil, fmt.Errorf("install secret %s: %v", name, err)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+func rotateRuleSecrets(ctx context.Context, count func(int)) error {
+	rows, err := db.Pool.Query(ctx, `SELECT id FROM forwarding_rules`)
+	if err != nil {
+		return err
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		changed, err := rotateRuleSecret(ctx, id)
+		if err != nil {
+			return err
+		}
+		count(changed)
+	}
+	return nil
+}
+
+// rotateRuleSecret re-encrypts the secrets of one rule, locking its row so
+// an update made meanwhile through the API is neither lost nor overwritten.
+// Returns how many secrets were rotated.
+func rotateRuleSecret(ctx context.Context, id uuid.UUID) (int, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var headersJSON []byte
+	var headers map[string]interface{}
+	var clientKey, authSecret *string
+	err = tx.QueryRow(
+		ctx,
+		`SELECT headers, transport_client_key, auth_secret FROM forwarding_rules WHERE id = $1 FOR UPDATE`,
+		id,
+	).Scan(&headersJSON, &clientKey, &authSecret)
+	if err == pgx.ErrNoRows {
+		return 0, nil // Deleted meanwhile
+	}
+	if err != nil {
+		return 0, err
+	}
+	json.Unmarshal(headersJSON, &headers)
+
+	rotate := func(value *string) (bool, error) {
+		if value == nil || !secrets.NeedsRotation(*value) {
+			return false, nil
+		}
+		rotated, err := secrets.Rotate(*value)
+		if err != nil {
+			return false, err
+		}
+		*value = rotated
+		return true, nil
+	}
+
+	changed := 0
+	for name, value := range headers {
+		str, ok := value.(string)
+		if !ok || !isSensitiveHeader(name) {
+			continue
+		}
+		ok, err := rotate(&str)
+		if err != nil {
+			return 0, fmt.Errorf("rule %s header %s: %v", id, name, err)
+		}
+		if ok {
+			headers[name] = str
+			changed++
+		}
+	}
+	for _, value := range []*string{clientKey, authSecret} {
+		ok, err := rotate(value)
+		if err != nil {
+			return 0, fmt.Errorf("rule %s: %v", id, err)
+		}
+		if ok {
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+
+	headersJSON, _ = json.Marshal(headers)
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE forwarding_rules SET headers = $1, transport_client_key = $2, auth_secret = $3 WHERE id = $4`,
+		string(headersJSON), clientKey, authSecret, id,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return changed, tx.Commit(ctx)
+}
+
+// rotatePayloads re-encrypts the encrypted_payload column of table, requests
+// or replays, in batches
+func rotatePayloads(ctx context.Context, table string, count func(int)) error {
+	ident := pgx.Identifier{table}.Sanitize()
+	keyPrefix := "v2:" + secrets.CurrentKeyID() + ":"
+	lastID := uuid.Nil
+	for {
+		rows, err := db.Pool.Query(
+			ctx,
+			`SELECT id, encrypted_payload FROM `+ident+`
+			 WHERE encrypted_payload IS NOT NULL AND id > $1 AND NOT starts_with(encrypted_payload, $2)
+			 ORDER BY id LIMIT $3`,
+			lastID, keyPrefix, rotationBatchSize,
+		)
+		if err != nil {
+			return err
+		}
+		ids := make([]uuid.UUID, 0, rotationBatchSize)
+		payloads := make([]string, 0, rotationBatchSize)
+		for rows.Next() {
+			var id uuid.UUID
+			var payload string
+			if err := rows.Scan(&id, &payload); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+			payloads = append(payloads, payload)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		for i, payload := range payloads {
+			rotated, err := secrets.Rotate(payload)
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", table, ids[i], err)
+			}
+			payloads[i] = rotated
+		}
+		_, err = db.Pool.Exec(
+			ctx,
+			`UPDATE `+ident+` r SET encrypted_payload = p.payload
+			 FROM unnest($1::uuid[], $2::text[]) AS p(id, payload)
+			 WHERE r.id = p.id`,
+			ids, payloads,
+		)
+		if err != nil {
+			return err
+		}
+		count(len(ids))
+		lastID = ids[len(ids)-1]
+	}
+}
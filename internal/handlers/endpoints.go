@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 
 	"flowhook/internal/db"
@@ -49,11 +48,7 @@ func CreateEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build endpoint URL
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = "http://localhost:8080"
-	}
-	endpointURL := fmt.Sprintf("%s/e/%s", baseURL, slug)
+	endpointURL := fmt.Sprintf("%s/e/%s", publicBaseURL(), slug)
 
 	response := models.CreateEndpointResponse{
 		ID:   id,
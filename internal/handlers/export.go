@@ -13,7 +13,23 @@ import (
 	"github.com/jackc/pgx/v5"
 )
 
-// ExportRequest handles GET /api/v1/requests/:id/export?format=curl|json|httpie|har
+// codeFormat is a single-request export target
+type codeFormat struct {
+	Generate    func(codeRequest) string
+	ContentType string
+	Extension   string
+}
+
+var codeFormats = map[string]codeFormat{
+	"curl":   {Generate: generateCurl, ContentType: "text/plain", Extension: "sh"},
+	"httpie": {Generate: generateHTTPie, ContentType: "text/plain", Extension: "sh"},
+	"raw":    {Generate: generateRawHTTP, ContentType: "text/plain", Extension: "http"},
+	"fetch":  {Generate: generateFetch, ContentType: "text/javascript", Extension: "js"},
+	"python": {Generate: generatePython, ContentType: "text/x-python", Extension: "py"},
+	"go":     {Generate: generateGo, ContentType: "text/plain", Extension: "go"},
+}
+
+// ExportRequest handles GET /api/v1/requests/:id/export?format=curl|httpie|raw|fetch|python|go|json|har
 func ExportRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -34,31 +50,17 @@ func ExportRequest(w http.ResponseWriter, r *http.Request) {
 	if format == "" {
 		format = "curl" // Default
 	}
-
-	// Fetch request
-	var req struct {
-		Method      string
-		Path        *string
-		Headers     string
-		QueryParams string
-		Body        *string
-		ContentType *string
+	if _, ok := codeFormats[format]; !ok && format != "json" && format != "har" {
+		http.Error(w, "format must be one of: curl, httpie, raw, fetch, python, go, json, har", http.StatusBadRequest)
+		return
 	}
 
-	err = db.Pool.QueryRow(
+	// Fetch request
+	req, err := scanRequest(db.Pool.QueryRow(
 		r.Context(),
-		`SELECT method, path, headers, query_params, body, content_type
-		 FROM requests WHERE id = $1`,
+		`SELECT `+requestColumns+` FROM requests WHERE id = $1`,
 		requestID,
-	).Scan(
-		&req.Method,
-		&req.Path,
-		&req.Headers,
-		&req.QueryParams,
-		&req.Body,
-		&req.ContentType,
-	)
-
+	))
 	if err == pgx.ErrNoRows {
 		http.Error(w, "Request not found", http.StatusNotFound)
 		return
@@ -68,42 +70,16 @@ func ExportRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse headers and query params
-	var headers map[string]interface{}
-	var queryParams map[string]interface{}
-	json.Unmarshal([]byte(req.Headers), &headers)
-	json.Unmarshal([]byte(req.QueryParams), &queryParams)
-
-	// Get body from database
-	var body string
-	if req.Body != nil && *req.Body != "" {
-		body = *req.Body
+	var slug string
+	err = db.Pool.QueryRow(r.Context(), `SELECT slug FROM endpoints WHERE id = $1`, req.EndpointID).Scan(&slug)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Build URL (we'll use a placeholder since we don't have the original endpoint URL)
-	url := "https://example.com" + func() string {
-		if req.Path != nil {
-			return *req.Path
-		}
-		return "/"
-	}()
-
-	// Add query params to URL
-	if len(queryParams) > 0 {
-		url += "?"
-		first := true
-		for k, v := range queryParams {
-			if !first {
-				url += "&"
-			}
-			if str, ok := v.(string); ok {
-				url += fmt.Sprintf("%s=%s", k, str)
-			} else {
-				url += fmt.Sprintf("%s=%v", k, v)
-			}
-			first = false
-		}
-	}
+	// Rebuild the public capture URL the request was sent to
+	url := captureURL(publicBaseURL(), slug, req)
+	body := derefString(req.Body)
 
 	// Generate export based on format
 	var exportContent string
@@ -113,44 +89,30 @@ func ExportRequest(w http.ResponseWriter, r *http.Request) {
 	switch format {
 	case "json":
 		exportData := map[string]interface{}{
-			"method":  req.Method,
-			"url":     url,
-			"headers": headers,
-			"body":    body,
+			"method":       req.Method,
+			"url":          url,
+			"headers":      req.Headers,
+			"query_params": req.QueryParams,
+			"body":         body,
+			"received_at":  req.ReceivedAt,
 		}
 		jsonBytes, _ := json.MarshalIndent(exportData, "", "  ")
 		exportContent = string(jsonBytes)
 		contentType = "application/json"
 		filename = fmt.Sprintf("request-%s.json", requestID.String()[:8])
 
-	case "httpie":
-		exportContent = fmt.Sprintf("%s %s\n", req.Method, url)
-		for k, v := range headers {
-			if arr, ok := v.([]interface{}); ok {
-				for _, val := range arr {
-					exportContent += fmt.Sprintf("%s: %v\n", k, val)
-				}
-			} else {
-				exportContent += fmt.Sprintf("%s: %v\n", k, v)
-			}
-		}
-		if body != "" {
-			exportContent += "\n" + body
-		}
-		contentType = "text/plain"
-		filename = fmt.Sprintf("request-%s.http", requestID.String()[:8])
-
 	case "har":
-		har := generateHAR(req.Method, url, headers, queryParams, body)
+		har := generateHAR(req.Method, url, req.Headers, req.QueryParams, body)
 		jsonBytes, _ := json.MarshalIndent(har, "", "  ")
 		exportContent = string(jsonBytes)
 		contentType = "application/json"
 		filename = fmt.Sprintf("request-%s.har", requestID.String()[:8])
 
-	default: // curl
-		exportContent = generateCurl(req.Method, url, headers, body)
-		contentType = "text/plain"
-		filename = fmt.Sprintf("request-%s.sh", requestID.String()[:8])
+	default:
+		codeFmt := codeFormats[format]
+		exportContent = codeFmt.Generate(newCodeRequest(req.Method, url, req.Headers, body))
+		contentType = codeFmt.ContentType
+		filename = fmt.Sprintf("request-%s.%s", requestID.String()[:8], codeFmt.Extension)
 	}
 
 	// Set headers for download
@@ -159,56 +121,8 @@ func ExportRequest(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(exportContent))
 }
 
-// generateCurl generates a cURL command
-func generateCurl(method, url string, headers map[string]interface{}, body string) string {
-	curl := fmt.Sprintf("curl -X %s \"%s\"", method, url)
-
-	// Add headers
-	for k, v := range headers {
-		if arr, ok := v.([]interface{}); ok {
-			for _, val := range arr {
-				curl += fmt.Sprintf(" \\\n  -H \"%s: %v\"", k, val)
-			}
-		} else {
-			curl += fmt.Sprintf(" \\\n  -H \"%s: %v\"", k, v)
-		}
-	}
-
-	// Add body
-	if body != "" {
-		// Escape single quotes for shell
-		escapedBody := strings.ReplaceAll(body, "'", "'\\''")
-		curl += fmt.Sprintf(" \\\n  -d '%s'", escapedBody)
-	}
-
-	return curl
-}
-
 // generateHAR generates a HAR (HTTP Archive) format
 func generateHAR(method, url string, headers map[string]interface{}, queryParams map[string]interface{}, body string) map[string]interface{} {
-	harHeaders := []map[string]string{}
-	for k, v := range headers {
-		if arr, ok := v.([]interface{}); ok {
-			for _, val := range arr {
-				harHeaders = append(harHeaders, map[string]string{
-					"name":  k,
-					"value": fmt.Sprintf("%v", val),
-				})
-			}
-		} else {
-			harHeaders = append(harHeaders, map[string]string{
-				"name":  k,
-				"value": fmt.Sprintf("%v", v),
-			})
-		}
-	}
-
-	postData := map[string]interface{}{}
-	if body != "" {
-		postData["mimeType"] = "application/json"
-		postData["text"] = body
-	}
-
 	return map[string]interface{}{
 		"log": map[string]interface{}{
 			"version": "1.2",
@@ -217,27 +131,7 @@ func generateHAR(method, url string, headers map[string]interface{}, queryParams
 				"version": "1.0",
 			},
 			"entries": []map[string]interface{}{
-				{
-					"request": map[string]interface{}{
-						"method":      method,
-						"url":         url,
-						"httpVersion": "HTTP/1.1",
-						"headers":     harHeaders,
-						"queryString": func() []map[string]string {
-							result := []map[string]string{}
-							for k, v := range queryParams {
-								result = append(result, map[string]string{
-									"name":  k,
-									"value": fmt.Sprintf("%v", v),
-								})
-							}
-							return result
-						}(),
-						"postData": postData,
-					},
-					"startedDateTime": time.Now().Format(time.RFC3339),
-					"time":            0,
-				},
+				harEntry(method, url, headers, queryParams, body, time.Now()),
 			},
 		},
 	}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"flowhook/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// exportFormat describes a bulk export file type
+type exportFormat struct {
+	ContentType string
+	Extension   string
+}
+
+var exportFormats = map[string]exportFormat{
+	"ndjson":  {ContentType: "application/x-ndjson", Extension: "ndjson"},
+	"csv":     {ContentType: "text/csv", Extension: "csv"},
+	"har":     {ContentType: "application/json", Extension: "har"},
+	"postman": {ContentType: "application/json", Extension: "postman_collection.json"},
+}
+
+// requestExporter streams captured requests into an export file
+type requestExporter interface {
+	WriteRequest(req models.Request, requestURL string) error
+	Close() error
+}
+
+// newRequestExporter starts an export in the given format. name is used where
+// the format carries a title (Postman collections).
+func newRequestExporter(format string, w io.Writer, name string) (requestExporter, error) {
+	switch format {
+	case "ndjson":
+		return &ndjsonExporter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return newCSVExporter(w)
+	case "har":
+		return newJSONArrayExporter(w,
+			`{"log":{"version":"1.2","creator":{"name":"FlowHook","version":"1.0"},"entries":[`,
+			`]}}`,
+			func(req models.Request, requestURL string) interface{} {
+				return harEntry(req.Method, requestURL, req.Headers, req.QueryParams, derefString(req.Body), req.ReceivedAt)
+			})
+	case "postman":
+		info, _ := json.Marshal(map[string]string{
+			"_postman_id": uuid.New().String(),
+			"name":        name,
+			"schema":      "https://schema.getpostman.com/json/collection/v2.1.0/collection.json",
+		})
+		return newJSONArrayExporter(w, `{"info":`+string(info)+`,"item":[`, `]}`, postmanItem)
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// ndjsonExporter writes one request per line with its capture URL
+type ndjsonExporter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExporter) WriteRequest(req models.Request, requestURL string) error {
+	return e.enc.Encode(struct {
+		models.Request
+		URL string `json:"url"`
+	}{req, requestURL})
+}
+
+func (e *ndjsonExporter) Close() error {
+	return nil
+}
+
+// csvExporter writes one row per request; headers and query params are JSON encoded
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func newCSVExporter(w io.Writer) (*csvExporter, error) {
+	e := &csvExporter{w: csv.NewWriter(w)}
+	err := e.w.Write([]string{
+		"id", "received_at", "method", "url", "path", "subpath", "ip",
+		"content_type", "body_size", "headers", "query_params", "body",
+	})
+	return e, err
+}
+
+func (e *csvExporter) WriteRequest(req models.Request, requestURL string) error {
+	headersJSON, _ := json.Marshal(req.Headers)
+	queryJSON, _ := json.Marshal(req.QueryParams)
+	return e.w.Write([]string{
+		req.ID.String(),
+		req.ReceivedAt.Format(time.RFC3339Nano),
+		req.Method,
+		requestURL,
+		derefString(req.Path),
+		derefString(req.Subpath),
+		derefString(req.IP),
+		derefString(req.ContentType),
+		strconv.FormatInt(req.BodySize, 10),
+		string(headersJSON),
+		string(queryJSON),
+		derefString(req.Body),
+	})
+}
+
+func (e *csvExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonArrayExporter streams a JSON document whose entries array is written one
+// element at a time, so exports never hold every request in memory
+type jsonArrayExporter struct {
+	w      io.Writer
+	suffix string
+	item   func(models.Request, string) interface{}
+	count  int
+}
+
+func newJSONArrayExporter(w io.Writer, prefix, suffix string, item func(models.Request, string) interface{}) (*jsonArrayExporter, error) {
+	_, err := io.WriteString(w, prefix)
+	return &jsonArrayExporter{w: w, suffix: suffix, item: item}, err
+}
+
+func (e *jsonArrayExporter) WriteRequest(req models.Request, requestURL string) error {
+	data, err := json.Marshal(e.item(req, requestURL))
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.count++
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonArrayExporter) Close() error {
+	_, err := io.WriteString(e.w, e.suffix)
+	return err
+}
+
+// harEntry builds a HAR 1.2 entry for a captured request. Captures have no
+// response, so the response carries HAR's "not available" values.
+func harEntry(method, requestURL string, headers, queryParams map[string]interface{}, body string, startedAt time.Time) map[string]interface{} {
+	harHeaders := []map[string]string{}
+	mimeType := ""
+	for _, k := range sortedKeys(headers) {
+		for _, val := range stringValues(headers[k]) {
+			harHeaders = append(harHeaders, map[string]string{"name": k, "value": val})
+			if http.CanonicalHeaderKey(k) == "Content-Type" {
+				mimeType = val
+			}
+		}
+	}
+
+	queryString := []map[string]string{}
+	for _, k := range sortedKeys(queryParams) {
+		for _, val := range stringValues(queryParams[k]) {
+			queryString = append(queryString, map[string]string{"name": k, "value": val})
+		}
+	}
+
+	request := map[string]interface{}{
+		"method":      method,
+		"url":         requestURL,
+		"httpVersion": "HTTP/1.1",
+		"cookies":     []interface{}{},
+		"headers":     harHeaders,
+		"queryString": queryString,
+		"headersSize": -1,
+		"bodySize":    len(body),
+	}
+	if body != "" {
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		request["postData"] = map[string]interface{}{
+			"mimeType": mimeType,
+			"text":     body,
+		}
+	}
+
+	return map[string]interface{}{
+		"startedDateTime": startedAt.Format(time.RFC3339Nano),
+		"time":            0,
+		"request":         request,
+		"response": map[string]interface{}{
+			"status":      0,
+			"statusText":  "",
+			"httpVersion": "HTTP/1.1",
+			"cookies":     []interface{}{},
+			"headers":     []interface{}{},
+			"content":     map[string]interface{}{"size": 0, "mimeType": ""},
+			"redirectURL": "",
+			"headersSize": -1,
+			"bodySize":    -1,
+		},
+		"cache":   map[string]interface{}{},
+		"timings": map[string]int{"send": 0, "wait": 0, "receive": 0},
+	}
+}
+
+// postmanItem builds a Postman v2.1 collection item for a captured request
+func postmanItem(req models.Request, requestURL string) interface{} {
+	header := []map[string]string{}
+	for _, k := range sortedKeys(req.Headers) {
+		for _, val := range stringValues(req.Headers[k]) {
+			header = append(header, map[string]string{"key": k, "value": val})
+		}
+	}
+
+	request := map[string]interface{}{
+		"method": req.Method,
+		"header": header,
+		"url":    requestURL,
+	}
+	if body := derefString(req.Body); body != "" {
+		request["body"] = map[string]interface{}{
+			"mode": "raw",
+			"raw":  body,
+		}
+	}
+
+	return map[string]interface{}{
+		"name":    fmt.Sprintf("%s %s (%s)", req.Method, derefString(req.Path), req.ReceivedAt.Format(time.RFC3339)),
+		"request": request,
+	}
+}
+
+// captureURL rebuilds the public URL a request was captured on
+func captureURL(baseURL, slug string, req models.Request) string {
+	requestURL := baseURL
+	if req.Subpath != nil {
+		requestURL += "/e/" + slug + *req.Subpath
+	} else if req.Path != nil {
+		requestURL += *req.Path
+	} else {
+		requestURL += "/e/" + slug
+	}
+
+	values := url.Values{}
+	for k, v := range req.QueryParams {
+		values[k] = stringValues(v)
+	}
+	if len(values) > 0 {
+		requestURL += "?" + values.Encode()
+	}
+	return requestURL
+}
+
+// publicBaseURL returns the externally reachable base URL of the service
+func publicBaseURL() string {
+	if baseURL := os.Getenv("BASE_URL"); baseURL != "" {
+		return baseURL
+	}
+	return "http://localhost:8080"
+}
+
+// stringValues normalizes a stored header or query value (string or list) to a list
+func stringValues(v interface{}) []string {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{val}
+	case []string:
+		return val
+	case []interface{}:
+		out := make([]string, 0, len(val))
+		for _, item := range val {
+			out = append(out, fmt.Sprintf("%v", item))
+		}
+		return out
+	default:
+		return []string{fmt.Sprintf("%v", val)}
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -8,78 +8,90 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
 	"flowhook/internal/db"
+	"flowhook/internal/logger"
+	"flowhook/internal/metrics"
 	"flowhook/internal/models"
+	"flowhook/internal/tracing"
 	"flowhook/internal/transform"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
-// triggerForwarding checks for forwarding rules and triggers forwarding
-func triggerForwarding(endpointID, requestID uuid.UUID, method, headersJSON string, body []byte) {
-	ctx := context.Background()
+// capturedRequest carries the parts of a captured webhook needed for forwarding
+type capturedRequest struct {
+	ID          uuid.UUID
+	EndpointID  uuid.UUID
+	Method      string
+	Subpath     string // Path after /e/:slug, e.g. "/orders/123"
+	RawQuery    string
+	HeadersJSON string
+	Body        []byte
+}
+
+// triggerForwarding checks for forwarding rules and triggers forwarding.
+// skipRuleID excludes a rule that has already been forwarded to synchronously.
+// Forwards are traced as children of the span in ctx.
+func triggerForwarding(ctx context.Context, captured capturedRequest, skipRuleID *uuid.UUID) {
+	evalCtx, span := tracing.Start(ctx, "evaluate forwarding rules",
+		attribute.String("flowhook.request.id", captured.ID.String()),
+	)
+	var err error
+	matched := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("flowhook.rules.matched", matched))
+		tracing.End(span, err)
+	}()
 
 	// Fetch enabled forwarding rules for this endpoint
 	rows, err := db.Pool.Query(
-		ctx,
-		`SELECT id, endpoint_id, target_url, method, headers, max_retries, backoff_config, condition_type, condition_config
+		evalCtx,
+		`SELECT `+forwardingRuleColumns+`
 		 FROM forwarding_rules WHERE endpoint_id = $1 AND enabled = TRUE`,
-		endpointID,
+		captured.EndpointID,
 	)
 
 	if err != nil {
-		fmt.Printf("Failed to fetch forwarding rules: %v\n", err)
+		logger.ErrorContext(evalCtx, "Failed to fetch forwarding rules", "endpoint_id", captured.EndpointID, "error", err)
 		return
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var rule models.ForwardingRule
-		var headersJSONStr, backoffJSON string
-		var conditionConfigJSON []byte
-		var ruleMethod, conditionType *string
-
-		err := rows.Scan(
-			&rule.ID,
-			&rule.EndpointID,
-			&rule.TargetURL,
-			&ruleMethod,
-			&headersJSONStr,
-			&rule.MaxRetries,
-			&backoffJSON,
-			&conditionType,
-			&conditionConfigJSON,
-		)
+		rule, err := scanForwardingRule(rows)
 		if err != nil {
-			fmt.Printf("Failed to scan forwarding rule: %v\n", err)
+			logger.ErrorContext(evalCtx, "Failed to scan forwarding rule", "endpoint_id", captured.EndpointID, "error", err)
 			continue
 		}
 
-		rule.Method = ruleMethod
-		rule.ConditionType = conditionType
-		json.Unmarshal([]byte(headersJSONStr), &rule.Headers)
-		json.Unmarshal([]byte(backoffJSON), &rule.BackoffConfig)
-		if len(conditionConfigJSON) > 0 {
-			json.Unmarshal(conditionConfigJSON, &rule.ConditionConfig)
+		if skipRuleID != nil && rule.ID == *skipRuleID {
+			continue
 		}
 
 		// Check condition if specified
 		if rule.ConditionType != nil {
-			if !checkForwardingCondition(*rule.ConditionType, rule.ConditionConfig, headersJSON, body) {
+			if !checkForwardingCondition(*rule.ConditionType, rule.ConditionConfig, captured) {
 				continue // Skip this rule if condition doesn't match
 			}
 		}
 
 		// Forward asynchronously
-		go forwardRequest(ctx, requestID, rule, method, headersJSON, body)
+		matched++
+		go forwardRequest(ctx, captured, rule)
 	}
 }
 
 // checkForwardingCondition checks if forwarding condition is met
-func checkForwardingCondition(conditionType string, conditionConfig map[string]interface{}, headersJSON string, body []byte) bool {
+func checkForwardingCondition(conditionType string, conditionConfig map[string]interface{}, captured capturedRequest) bool {
 	switch conditionType {
 	case "always":
 		return true
@@ -91,7 +103,7 @@ func checkForwardingCondition(conditionType string, conditionConfig map[string]i
 		}
 
 		var headers map[string]interface{}
-		json.Unmarshal([]byte(headersJSON), &headers)
+		json.Unmarshal([]byte(captured.HeadersJSON), &headers)
 		if val, exists := headers[headerName]; exists {
 			if strVal, ok := val.(string); ok {
 				return strVal == headerValue
@@ -107,23 +119,164 @@ func checkForwardingCondition(conditionType string, conditionConfig map[string]i
 			return false
 		}
 		// Simple substring match for now
-		return bytes.Contains(body, []byte(pattern))
+		return bytes.Contains(captured.Body, []byte(pattern))
+	case "subpath_match":
+		// "pattern" is a glob per segment (path.Match), "regex" a regular expression
+		if pattern, ok := conditionConfig["pattern"].(string); ok {
+			matched, err := path.Match(pattern, captured.Subpath)
+			return err == nil && matched
+		}
+		if expr, ok := conditionConfig["regex"].(string); ok {
+			re := conditionRegexp(expr)
+			return re != nil && re.MatchString(captured.Subpath)
+		}
+		return false
 	default:
 		return true
 	}
 }
 
-// forwardRequest performs the forwarding with retry logic
-func forwardRequest(ctx context.Context, requestID uuid.UUID, rule models.ForwardingRule, originalMethod, headersJSON string, body []byte) {
+// validateCondition checks the patterns of a subpath_match condition, which
+// would otherwise silently never match
+func validateCondition(conditionType *string, conditionConfig map[string]interface{}) error {
+	if conditionType == nil || *conditionType != "subpath_match" {
+		return nil
+	}
+	if pattern, ok := conditionConfig["pattern"].(string); ok {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		return nil
+	}
+	if expr, ok := conditionConfig["regex"].(string); ok {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid regex %q: %v", expr, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("subpath_match requires a pattern or regex")
+}
+
+// maxConditionRegexps bounds the compiled condition regexps kept; the cache
+// starts over when rules have changed enough to fill it
+const maxConditionRegexps = 1000
+
+var (
+	conditionRegexpsMu sync.Mutex
+	conditionRegexps   = make(map[string]*regexp.Regexp)
+)
+
+// conditionRegexp returns the compiled regex of a subpath_match condition,
+// compiling each expression once. It is nil for an invalid expression stored
+// before conditions were validated.
+func conditionRegexp(expr string) *regexp.Regexp {
+	conditionRegexpsMu.Lock()
+	defer conditionRegexpsMu.Unlock()
+	if re, ok := conditionRegexps[expr]; ok {
+		return re
+	}
+
+	re, _ := regexp.Compile(expr)
+	if len(conditionRegexps) >= maxConditionRegexps {
+		conditionRegexps = make(map[string]*regexp.Regexp)
+	}
+	conditionRegexps[expr] = re
+	return re
+}
+
+// resolveTargetURL expands {subpath} and {query} placeholders in a rule's target URL
+// and, when the rule appends subpaths, adds the captured subpath and query string
+func resolveTargetURL(rule models.ForwardingRule, captured capturedRequest) string {
+	target := rule.TargetURL
+
+	if strings.Contains(target, "{subpath}") || strings.Contains(target, "{query}") {
+		target = strings.ReplaceAll(target, "{subpath}", strings.TrimPrefix(captured.Subpath, "/"))
+		target = strings.ReplaceAll(target, "{query}", captured.RawQuery)
+		return strings.TrimSuffix(target, "?")
+	}
+
+	if !rule.AppendSubpath {
+		return target
+	}
+
+	if captured.Subpath != "" {
+		if i := strings.IndexAny(target, "?#"); i >= 0 {
+			target = strings.TrimSuffix(target[:i], "/") + captured.Subpath + target[i:]
+		} else {
+			target = strings.TrimSuffix(target, "/") + captured.Subpath
+		}
+	}
+	if captured.RawQuery != "" {
+		if strings.Contains(target, "?") {
+			target += "&" + captured.RawQuery
+		} else {
+			target += "?" + captured.RawQuery
+		}
+	}
+	return target
+}
+
+// forwardRequest performs the forwarding with retry logic and returns the last attempt
+func forwardRequest(ctx context.Context, captured capturedRequest, rule models.ForwardingRule) *forwardResult {
+	ctx, span := tracing.Start(ctx, "forward",
+		attribute.String("flowhook.request.id", captured.ID.String()),
+		attribute.String("flowhook.rule.id", rule.ID.String()),
+	)
+	defer span.End()
+
+	forwardMethod, requestHeaders, forwardBody := buildForwardPayload(ctx, rule, captured)
+	forwardHeaders := withRuleHeaders(rule, requestHeaders)
+	targetURL := resolveTargetURL(rule, captured)
+	span.SetAttributes(attribute.String("url.full", targetURL))
+
+	// Retry loop
+	maxRetries := rule.MaxRetries
+	if maxRetries < 1 {
+		maxRetries = 1
+	}
+
+	// A shadow target receives the request once, alongside the first attempt
+	compareShadow := startShadow(ctx, rule, captured, forwardMethod, requestHeaders, forwardBody)
+
+	var result *forwardResult
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		result = executeForward(ctx, captured.ID, rule, attempt, targetURL, forwardMethod, forwardHeaders, forwardBody)
+
+		if result.Success() {
+			break // Success, stop retrying
+		}
+
+		// Calculate backoff delay
+		if attempt < maxRetries {
+			delay := calculateBackoff(attempt, rule.BackoffConfig)
+			time.Sleep(delay)
+		}
+	}
+
+	if compareShadow != nil {
+		compareShadow(result)
+	}
+	if !result.Success() {
+		span.SetStatus(codes.Error, "delivery failed")
+	}
+	return result
+}
+
+// buildForwardPayload resolves the method, headers and body sent to a rule's target,
+// with request transformations applied. The headers are the captured request's;
+// withRuleHeaders adds the rule's own.
+func buildForwardPayload(ctx context.Context, rule models.ForwardingRule, captured capturedRequest) (string, map[string]interface{}, []byte) {
+	body := captured.Body
+
 	// Determine method
-	forwardMethod := originalMethod
+	forwardMethod := captured.Method
 	if rule.Method != nil && *rule.Method != "" {
 		forwardMethod = *rule.Method
 	}
 
 	// Parse original headers
 	var originalHeaders map[string]interface{}
-	json.Unmarshal([]byte(headersJSON), &originalHeaders)
+	json.Unmarshal([]byte(captured.HeadersJSON), &originalHeaders)
 
 	// Parse body for transformation
 	var bodyData interface{}
@@ -138,21 +291,12 @@ func forwardRequest(ctx context.Context, requestID uuid.UUID, rule models.Forwar
 	// Apply transformations to request data
 	transformedHeaders, transformedBody, err := transform.ApplyRequestTransformations(ctx, rule.EndpointID, originalHeaders, bodyData)
 	if err != nil {
-		fmt.Printf("Warning: Failed to apply transformations: %v\n", err)
+		logger.WarnContext(ctx, "Failed to apply transformations", "rule_id", rule.ID, "request_id", captured.ID, "error", err)
 		// Continue with original data if transformation fails
 		transformedHeaders = originalHeaders
 		transformedBody = bodyData
 	}
 
-	// Merge transformed headers with rule headers
-	forwardHeaders := make(map[string]interface{})
-	for k, v := range transformedHeaders {
-		forwardHeaders[k] = v
-	}
-	for k, v := range rule.Headers {
-		forwardHeaders[k] = v
-	}
-
 	// Convert transformed body back to bytes
 	var forwardBody []byte
 	if transformedBody != nil {
@@ -171,115 +315,225 @@ func forwardRequest(ctx context.Context, requestID uuid.UUID, rule models.Forwar
 		forwardBody = body
 	}
 
-	// Retry loop
-	maxRetries := rule.MaxRetries
-	if maxRetries < 1 {
-		maxRetries = 1
+	return forwardMethod, transformedHeaders, forwardBody
+}
+
+// withRuleHeaders returns the request headers with the rule's headers
+// overriding captured ones
+func withRuleHeaders(rule models.ForwardingRule, headers map[string]interface{}) map[string]interface{} {
+	forwardHeaders := make(map[string]interface{}, len(headers)+len(rule.Headers))
+	for k, v := range headers {
+		forwardHeaders[k] = v
 	}
+	for k, v := range rule.Headers {
+		forwardHeaders[k] = v
+	}
+	return forwardHeaders
+}
 
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		success := executeForward(ctx, requestID, rule.ID, attempt, rule.TargetURL, forwardMethod, forwardHeaders, forwardBody)
+// forwardResult holds the outcome of a single forward attempt
+type forwardResult struct {
+	AttemptID  uuid.UUID // Recorded forward_attempts row; zero if recording failed
+	StatusCode int
+	Headers    http.Header
+	Body       []byte
+	// TransformedBody is Body after response transformations; it equals Body when none apply
+	TransformedBody []byte
+	DurationMs      int
+	Status          string
+	Err             error
+}
+
+// Success reports whether the attempt was recorded as successful
+func (fr *forwardResult) Success() bool {
+	return fr.Err == nil && fr.Status == "success"
+}
 
-		if success {
-			return // Success, stop retrying
+// executeForward performs a single forward attempt and records it
+func executeForward(ctx context.Context, requestID uuid.UUID, rule models.ForwardingRule, attemptNumber int, targetURL, method string, headers map[string]interface{}, body []byte) *forwardResult {
+	ruleID := rule.ID
+
+	ctx, span := tracing.Start(ctx, "forward attempt",
+		attribute.String("flowhook.rule.id", ruleID.String()),
+		attribute.Int("flowhook.attempt", attemptNumber),
+		attribute.String("http.request.method", method),
+	)
+
+	var result *forwardResult
+	if client, err := ruleClient(&rule, ruleTimeout(rule), true); err != nil {
+		result = &forwardResult{Status: "failed", Err: err}
+	} else {
+		result = sendForward(ctx, client, rule.Auth, targetURL, method, headers, body)
+	}
+	defer func() {
+		outcome := result.Status
+		if result.Err != nil {
+			outcome = "error"
 		}
+		metrics.ObserveForwardAttempt(metrics.RuleLabel(ruleID), attemptNumber, outcome, time.Duration(result.DurationMs)*time.Millisecond)
 
-		// Calculate backoff delay
-		if attempt < maxRetries {
-			delay := calculateBackoff(attempt, rule.BackoffConfig)
-			time.Sleep(delay)
+		span.SetAttributes(attribute.String("flowhook.outcome", outcome))
+		if result.StatusCode != 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", result.StatusCode))
+		}
+		if result.Err == nil && outcome != "success" {
+			span.SetStatus(codes.Error, outcome)
+		}
+		tracing.End(span, result.Err)
+	}()
+	if result.Err != nil {
+		errMsg := result.Err.Error()
+		var duration *int
+		if result.DurationMs > 0 {
+			duration = &result.DurationMs
 		}
+		result.AttemptID = recordForwardAttempt(ctx, requestID, ruleID, attemptNumber, "failed", 0, nil, nil, nil, &errMsg, duration)
+		return result
 	}
-}
 
-// executeForward performs a single forward attempt
-func executeForward(ctx context.Context, requestID, ruleID uuid.UUID, attemptNumber int, targetURL, method string, headers map[string]interface{}, body []byte) bool {
-	startTime := time.Now()
+	// Convert response headers to JSON
+	respHeadersJSON, _ := json.Marshal(headersToMap(result.Headers))
 
-	// Create HTTP request
-	var bodyReader io.Reader
-	if len(body) > 0 {
-		bodyReader = bytes.NewReader(body)
-	}
+	// Handle response body
+	respBodyStr := encodeResponseBody(result.Body)
 
-	req, err := http.NewRequestWithContext(ctx, method, targetURL, bodyReader)
+	// Apply response transformations, keeping the raw body alongside
+	transformedBody, applied, err := transform.TransformResponseBody(ctx, rule.EndpointID, result.Body)
 	if err != nil {
-		errMsg := err.Error()
-		recordForwardAttempt(requestID, ruleID, attemptNumber, "failed", 0, nil, nil, &errMsg, nil)
-		return false
+		logger.WarnContext(ctx, "Failed to apply response transformations", "rule_id", rule.ID, "request_id", requestID, "attempt", attemptNumber, "error", err)
+	}
+	var transformedBodyStr *string
+	if applied {
+		transformedBodyStr = encodeResponseBody(transformedBody)
+	}
+	result.TransformedBody = transformedBody
+
+	status := "success"
+	var errMsg *string
+	if result.StatusCode >= 400 {
+		status = "failed"
+	} else if !checkResponseCondition(rule.ConditionConfig, transformedBody) {
+		status = "failed"
+		msg := "Response did not match success condition"
+		errMsg = &msg
 	}
+	result.Status = status
+
+	result.AttemptID = recordForwardAttempt(ctx, requestID, ruleID, attemptNumber, status, result.StatusCode, respHeadersJSON, respBodyStr, transformedBodyStr, errMsg, &result.DurationMs)
+	return result
+}
+
+// sendForward sends one HTTP request and reads up to 1MB of the response.
+// Status is "failed" with Err set when no response was received. With OAuth2
+// auth, a 401 is retried once with a freshly fetched token.
+func sendForward(ctx context.Context, client *http.Client, auth *models.AuthConfig, targetURL, method string, headers map[string]interface{}, body []byte) *forwardResult {
+	startTime := time.Now()
+
+	// sentToken is the OAuth2 token of the last attempt, replaced on a 401
+	var sentToken string
+	send := func(rejectedToken string) (*http.Response, error) {
+		// Create HTTP request
+		var bodyReader io.Reader
+		if len(body) > 0 {
+			bodyReader = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, targetURL, bodyReader)
+		if err != nil {
+			return nil, err
+		}
 
-	// Set headers
-	for key, value := range headers {
-		if arr, ok := value.([]interface{}); ok {
-			for _, v := range arr {
-				req.Header.Set(key, fmt.Sprintf("%v", v))
+		// Set headers
+		for key, value := range headers {
+			if arr, ok := value.([]interface{}); ok {
+				for _, v := range arr {
+					req.Header.Set(key, fmt.Sprintf("%v", v))
+				}
+			} else {
+				req.Header.Set(key, fmt.Sprintf("%v", value))
 			}
-		} else {
-			req.Header.Set(key, fmt.Sprintf("%v", value))
 		}
+		if err := authorizeRequest(ctx, auth, req, rejectedToken); err != nil {
+			return nil, err
+		}
+		sentToken = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		tracing.Inject(ctx, req.Header)
+
+		return client.Do(req)
 	}
 
 	// Execute request
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	resp, err := send("")
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && refreshesOnUnauthorized(auth) {
+		resp.Body.Close()
+		resp, err = send(sentToken)
 	}
-
-	resp, err := client.Do(req)
 	if err != nil {
-		duration := int(time.Since(startTime).Milliseconds())
-		errMsg := err.Error()
-		recordForwardAttempt(requestID, ruleID, attemptNumber, "failed", 0, nil, nil, &errMsg, &duration)
-		return false
+		return &forwardResult{DurationMs: int(time.Since(startTime).Milliseconds()), Status: "failed", Err: err}
 	}
 	defer resp.Body.Close()
 
 	// Read response body
 	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024*1024)) // Limit to 1MB
-	duration := int(time.Since(startTime).Milliseconds())
 
-	// Convert response headers to JSON
-	respHeaders := make(map[string]interface{})
-	for k, v := range resp.Header {
-		if len(v) == 1 {
-			respHeaders[k] = v[0]
-		} else {
-			respHeaders[k] = v
-		}
+	return &forwardResult{
+		StatusCode:      resp.StatusCode,
+		Headers:         resp.Header,
+		Body:            respBody,
+		TransformedBody: respBody,
+		DurationMs:      int(time.Since(startTime).Milliseconds()),
+		Status:          "success",
 	}
-	respHeadersJSON, _ := json.Marshal(respHeaders)
+}
 
-	// Handle response body
-	var respBodyStr *string
-	if len(respBody) > 0 {
-		if utf8.Valid(respBody) {
-			bodyStr := string(respBody)
-			respBodyStr = &bodyStr
+// headersToMap converts response headers to the form stored as JSON
+func headersToMap(header http.Header) map[string]interface{} {
+	headers := make(map[string]interface{})
+	for k, v := range header {
+		if len(v) == 1 {
+			headers[k] = v[0]
 		} else {
-			encoded := base64.StdEncoding.EncodeToString(respBody)
-			bodyStr := fmt.Sprintf("[BINARY DATA - Base64 Encoded]\n%s", encoded)
-			respBodyStr = &bodyStr
+			headers[k] = v
 		}
 	}
+	return headers
+}
 
-	status := "success"
-	if resp.StatusCode >= 400 {
-		status = "failed"
+// checkResponseCondition evaluates a rule's optional success condition against the
+// (transformed) downstream response body. Rules without one always pass.
+func checkResponseCondition(conditionConfig map[string]interface{}, responseBody []byte) bool {
+	pattern, ok := conditionConfig["success_body_match"].(string)
+	if !ok || pattern == "" {
+		return true
 	}
+	return bytes.Contains(responseBody, []byte(pattern))
+}
 
-	recordForwardAttempt(requestID, ruleID, attemptNumber, status, resp.StatusCode, respHeadersJSON, respBodyStr, nil, &duration)
-
-	return status == "success"
+// encodeResponseBody converts a downstream body into its stored text form
+func encodeResponseBody(body []byte) *string {
+	if len(body) == 0 {
+		return nil
+	}
+	if utf8.Valid(body) {
+		bodyStr := string(body)
+		return &bodyStr
+	}
+	encoded := base64.StdEncoding.EncodeToString(body)
+	bodyStr := fmt.Sprintf("[BINARY DATA - Base64 Encoded]\n%s", encoded)
+	return &bodyStr
 }
 
-// recordForwardAttempt records a forward attempt in the database
-func recordForwardAttempt(requestID, ruleID uuid.UUID, attemptNumber int, status string, responseStatus int, responseHeaders []byte, responseBody *string, errorMsg *string, durationMs *int) {
-	ctx := context.Background()
+// recordForwardAttempt records a forward attempt in the database, with the
+// correlation ID of ctx. The record is written even if ctx is canceled.
+func recordForwardAttempt(ctx context.Context, requestID, ruleID uuid.UUID, attemptNumber int, status string, responseStatus int, responseHeaders []byte, responseBody, transformedResponseBody *string, errorMsg *string, durationMs *int) uuid.UUID {
+	ctx = context.WithoutCancel(ctx)
 
-	_, err := db.Pool.Exec(
+	var attemptID uuid.UUID
+	err := db.Pool.QueryRow(
 		ctx,
-		`INSERT INTO forward_attempts (request_id, forwarding_rule_id, attempt_number, status, response_status, response_headers, response_body, error_message, duration_ms)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		`INSERT INTO forward_attempts (request_id, forwarding_rule_id, attempt_number, status, response_status, response_headers, response_body, transformed_response_body, error_message, duration_ms, correlation_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		 RETURNING id`,
 		requestID,
 		ruleID,
 		attemptNumber,
@@ -287,13 +541,24 @@ func recordForwardAttempt(requestID, ruleID uuid.UUID, attemptNumber int, status
 		responseStatus,
 		responseHeaders,
 		responseBody,
+		transformedResponseBody,
 		errorMsg,
 		durationMs,
-	)
+		correlationID(ctx),
+	).Scan(&attemptID)
 
 	if err != nil {
-		fmt.Printf("Failed to record forward attempt: %v\n", err)
+		logger.ErrorContext(ctx, "Failed to record forward attempt", "rule_id", ruleID, "request_id", requestID, "attempt", attemptNumber, "status", status, "error", err)
+	}
+	return attemptID
+}
+
+// correlationID returns the correlation ID of ctx for storage, nil if it has none
+func correlationID(ctx context.Context) *string {
+	if id := logger.CorrelationID(ctx); id != "" {
+		return &id
 	}
+	return nil
 }
 
 // calculateBackoff calculates the delay for retry based on backoff config
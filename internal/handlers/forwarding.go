@@ -6,10 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"flowhook/internal/db"
 	"flowhook/internal/models"
-	// "flowhook/internal/validation"
+	"flowhook/internal/outbound"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -58,6 +59,10 @@ func CreateForwardingRule(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "target_url is required", http.StatusBadRequest)
 		return
 	}
+	if err := checkRuleTargetURL(req.TargetURL); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid target_url: %v", err), http.StatusBadRequest)
+		return
+	}
 
 	// Set defaults
 	maxRetries := 3
@@ -77,8 +82,41 @@ func CreateForwardingRule(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	headersJSON, _ := json.Marshal(req.Headers)
+	if req.ShadowTargetURL != nil && *req.ShadowTargetURL == "" {
+		req.ShadowTargetURL = nil
+	}
+	if req.ShadowTargetURL != nil {
+		if err := checkRuleTargetURL(*req.ShadowTargetURL); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid shadow_target_url: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ShadowIgnorePaths == nil {
+		req.ShadowIgnorePaths = []string{}
+	}
+
+	sealedHeaders, err := sealRuleHeaders(req.Headers, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid headers: %v", err), http.StatusBadRequest)
+		return
+	}
+	headersJSON, _ := json.Marshal(sealedHeaders)
 	backoffJSON, _ := json.Marshal(backoffConfig)
+	shadowIgnoreJSON, _ := json.Marshal(req.ShadowIgnorePaths)
+	transportJSON, clientKey, err := prepareTransportConfig(req.Transport, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid transport: %v", err), http.StatusBadRequest)
+		return
+	}
+	authJSON, authSecret, err := prepareAuthConfig(req.Auth, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid auth: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := validateCondition(req.ConditionType, req.ConditionConfig); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid condition_config: %v", err), http.StatusBadRequest)
+		return
+	}
 	var conditionConfigJSON []byte
 	if req.ConditionConfig != nil {
 		conditionConfigJSON, _ = json.Marshal(req.ConditionConfig)
@@ -88,8 +126,10 @@ func CreateForwardingRule(w http.ResponseWriter, r *http.Request) {
 	var ruleID uuid.UUID
 	err = db.Pool.QueryRow(
 		r.Context(),
-		`INSERT INTO forwarding_rules (endpoint_id, target_url, method, headers, max_retries, backoff_config, condition_type, condition_config)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`INSERT INTO forwarding_rules (endpoint_id, target_url, method, headers, max_retries, backoff_config, condition_type, condition_config, append_subpath,
+		                               shadow_target_url, shadow_ignore_paths, transport_config, transport_client_key,
+		                               auth_config, auth_secret)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 		 RETURNING id`,
 		endpointID,
 		req.TargetURL,
@@ -99,6 +139,13 @@ func CreateForwardingRule(w http.ResponseWriter, r *http.Request) {
 		string(backoffJSON),
 		req.ConditionType,
 		conditionConfigJSON,
+		req.AppendSubpath,
+		req.ShadowTargetURL,
+		string(shadowIgnoreJSON),
+		transportJSON,
+		clientKey,
+		authJSON,
+		authSecret,
 	).Scan(&ruleID)
 
 	if err != nil {
@@ -114,7 +161,7 @@ func CreateForwardingRule(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(rule)
+	json.NewEncoder(w).Encode(publicForwardingRule(rule))
 }
 
 // GetForwardingRules handles GET /api/v1/endpoints/:slug/forwarding-rules
@@ -152,7 +199,7 @@ func GetForwardingRules(w http.ResponseWriter, r *http.Request) {
 	// Fetch forwarding rules
 	rows, err := db.Pool.Query(
 		r.Context(),
-		`SELECT id, endpoint_id, target_url, method, headers, enabled, max_retries, backoff_config, condition_type, condition_config, created_at, updated_at
+		`SELECT `+forwardingRuleColumns+`
 		 FROM forwarding_rules WHERE endpoint_id = $1 ORDER BY created_at DESC`,
 		endpointID,
 	)
@@ -170,7 +217,7 @@ func GetForwardingRules(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("Failed to scan rule: %v", err), http.StatusInternalServerError)
 			return
 		}
-		rules = append(rules, rule)
+		rules = append(rules, publicForwardingRule(rule))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -202,6 +249,11 @@ func UpdateForwardingRule(w http.ResponseWriter, r *http.Request) {
 		BackoffConfig  map[string]interface{} `json:"backoff_config,omitempty"`
 		ConditionType  *string                 `json:"condition_type,omitempty"`
 		ConditionConfig map[string]interface{} `json:"condition_config,omitempty"`
+		AppendSubpath  *bool                  `json:"append_subpath,omitempty"`
+		ShadowTargetURL *string               `json:"shadow_target_url,omitempty"` // "" removes the shadow target
+		ShadowIgnorePaths []string            `json:"shadow_ignore_paths,omitempty"`
+		Transport      *models.TransportConfig `json:"transport,omitempty"` // {} removes the configuration
+		Auth           *models.AuthConfig      `json:"auth,omitempty"`      // {} removes the configuration
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -215,6 +267,10 @@ func UpdateForwardingRule(w http.ResponseWriter, r *http.Request) {
 	argIndex := 1
 
 	if req.TargetURL != nil {
+		if err := checkRuleTargetURL(*req.TargetURL); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid target_url: %v", err), http.StatusBadRequest)
+			return
+		}
 		updates = append(updates, fmt.Sprintf("target_url = $%d", argIndex))
 		args = append(args, *req.TargetURL)
 		argIndex++
@@ -224,12 +280,6 @@ func UpdateForwardingRule(w http.ResponseWriter, r *http.Request) {
 		args = append(args, *req.Method)
 		argIndex++
 	}
-	if req.Headers != nil {
-		headersJSON, _ := json.Marshal(req.Headers)
-		updates = append(updates, fmt.Sprintf("headers = $%d", argIndex))
-		args = append(args, string(headersJSON))
-		argIndex++
-	}
 	if req.Enabled != nil {
 		updates = append(updates, fmt.Sprintf("enabled = $%d", argIndex))
 		args = append(args, *req.Enabled)
@@ -257,6 +307,87 @@ func UpdateForwardingRule(w http.ResponseWriter, r *http.Request) {
 		args = append(args, conditionJSON)
 		argIndex++
 	}
+	if req.AppendSubpath != nil {
+		updates = append(updates, fmt.Sprintf("append_subpath = $%d", argIndex))
+		args = append(args, *req.AppendSubpath)
+		argIndex++
+	}
+	if req.ShadowTargetURL != nil {
+		if *req.ShadowTargetURL != "" {
+			if err := checkRuleTargetURL(*req.ShadowTargetURL); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid shadow_target_url: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		updates = append(updates, fmt.Sprintf("shadow_target_url = NULLIF($%d, '')", argIndex))
+		args = append(args, *req.ShadowTargetURL)
+		argIndex++
+	}
+	if req.ShadowIgnorePaths != nil {
+		shadowIgnoreJSON, _ := json.Marshal(req.ShadowIgnorePaths)
+		updates = append(updates, fmt.Sprintf("shadow_ignore_paths = $%d", argIndex))
+		args = append(args, string(shadowIgnoreJSON))
+		argIndex++
+	}
+
+	var existingAuth *models.AuthConfig
+	if req.Headers != nil || req.Transport != nil || req.Auth != nil || req.ConditionType != nil || req.ConditionConfig != nil {
+		existing, err := getForwardingRuleByID(r.Context(), ruleID)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Rule not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if req.ConditionType != nil || req.ConditionConfig != nil {
+			// Check the condition the rule ends up with, as only one half may change
+			conditionType, conditionConfig := existing.ConditionType, existing.ConditionConfig
+			if req.ConditionType != nil {
+				conditionType = req.ConditionType
+			}
+			if req.ConditionConfig != nil {
+				conditionConfig = req.ConditionConfig
+			}
+			if err := validateCondition(conditionType, conditionConfig); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid condition_config: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Headers != nil {
+			sealedHeaders, err := sealRuleHeaders(req.Headers, existing.Headers)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid headers: %v", err), http.StatusBadRequest)
+				return
+			}
+			headersJSON, _ := json.Marshal(sealedHeaders)
+			updates = append(updates, fmt.Sprintf("headers = $%d", argIndex))
+			args = append(args, string(headersJSON))
+			argIndex++
+		}
+		if req.Transport != nil {
+			transportJSON, clientKey, err := prepareTransportConfig(req.Transport, existing.Transport)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid transport: %v", err), http.StatusBadRequest)
+				return
+			}
+			updates = append(updates, fmt.Sprintf("transport_config = $%d", argIndex), fmt.Sprintf("transport_client_key = $%d", argIndex+1))
+			args = append(args, transportJSON, clientKey)
+			argIndex += 2
+		}
+		if req.Auth != nil {
+			existingAuth = existing.Auth
+			authJSON, authSecret, err := prepareAuthConfig(req.Auth, existing.Auth)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid auth: %v", err), http.StatusBadRequest)
+				return
+			}
+			updates = append(updates, fmt.Sprintf("auth_config = $%d", argIndex), fmt.Sprintf("auth_secret = $%d", argIndex+1))
+			args = append(args, authJSON, authSecret)
+			argIndex += 2
+		}
+	}
 
 	if len(updates) == 0 {
 		http.Error(w, "No fields to update", http.StatusBadRequest)
@@ -272,6 +403,12 @@ func UpdateForwardingRule(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to update rule: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if req.Transport != nil {
+		forgetRuleTransport(ruleID)
+	}
+	if req.Auth != nil {
+		forgetOAuth2Token(existingAuth)
+	}
 
 	// Fetch updated rule
 	rule, err := getForwardingRuleByID(r.Context(), ruleID)
@@ -281,7 +418,7 @@ func UpdateForwardingRule(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(rule)
+	json.NewEncoder(w).Encode(publicForwardingRule(rule))
 }
 
 // DeleteForwardingRule handles DELETE /api/v1/forwarding-rules/:id
@@ -299,11 +436,18 @@ func DeleteForwardingRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Read first so the rule's cached token can be dropped once it is gone
+	existing, existingErr := getForwardingRuleByID(r.Context(), ruleID)
+
 	_, err = db.Pool.Exec(r.Context(), "DELETE FROM forwarding_rules WHERE id = $1", ruleID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to delete rule: %v", err), http.StatusInternalServerError)
 		return
 	}
+	forgetRuleTransport(ruleID)
+	if existingErr == nil {
+		forgetOAuth2Token(existing.Auth)
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -324,12 +468,18 @@ func GetForwardAttempts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := db.Pool.Query(
-		r.Context(),
-		`SELECT id, request_id, forwarding_rule_id, attempt_number, status, response_status, response_headers, response_body, error_message, duration_ms, attempted_at
-		 FROM forward_attempts WHERE request_id = $1 ORDER BY attempted_at DESC`,
-		requestID,
+	cursor, limit, err := parsePageParams(r, 100, 500)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query, args := applyKeyset(
+		`SELECT id, request_id, forwarding_rule_id, attempt_number, status, response_status, response_headers, response_body, transformed_response_body, error_message, duration_ms, attempted_at, correlation_id
+		 FROM forward_attempts WHERE request_id = $1`,
+		[]interface{}{requestID}, "attempted_at", cursor, limit,
 	)
+	rows, err := db.Pool.Query(r.Context(), query, args...)
 
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
@@ -351,9 +501,11 @@ func GetForwardAttempts(w http.ResponseWriter, r *http.Request) {
 			&attempt.ResponseStatus,
 			&responseHeadersJSON,
 			&attempt.ResponseBody,
+			&attempt.TransformedResponseBody,
 			&attempt.ErrorMessage,
 			&attempt.DurationMs,
 			&attempt.AttemptedAt,
+			&attempt.CorrelationID,
 		)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to scan attempt: %v", err), http.StatusInternalServerError)
@@ -367,15 +519,31 @@ func GetForwardAttempts(w http.ResponseWriter, r *http.Request) {
 		attempts = append(attempts, attempt)
 	}
 
+	attempts, nextCursor, prevCursor := finishPage(attempts, cursor, limit, func(attempt models.ForwardAttempt) (time.Time, uuid.UUID) {
+		return attempt.AttemptedAt, attempt.ID
+	})
+	setCursorHeaders(w, nextCursor, prevCursor)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(attempts)
 }
 
+// checkRuleTargetURL validates a rule target, whose {subpath} and {query}
+// placeholders are only filled in when forwarding
+func checkRuleTargetURL(target string) error {
+	target = strings.NewReplacer("{subpath}", "", "{query}", "").Replace(target)
+	return outbound.CheckURL(target)
+}
+
+// forwardingRuleColumns is the column list read by scanForwardingRule
+const forwardingRuleColumns = `id, endpoint_id, target_url, method, headers, enabled, max_retries, backoff_config,
+	condition_type, condition_config, append_subpath, shadow_target_url, shadow_ignore_paths, transport_config, transport_client_key, auth_config, auth_secret, created_at, updated_at`
+
 // Helper functions
 func getForwardingRuleByID(ctx context.Context, ruleID uuid.UUID) (models.ForwardingRule, error) {
 	row := db.Pool.QueryRow(
 		ctx,
-		`SELECT id, endpoint_id, target_url, method, headers, enabled, max_retries, backoff_config, condition_type, condition_config, created_at, updated_at
+		`SELECT `+forwardingRuleColumns+`
 		 FROM forwarding_rules WHERE id = $1`,
 		ruleID,
 	)
@@ -387,7 +555,8 @@ func scanForwardingRule(scanner interface {
 }) (models.ForwardingRule, error) {
 	var rule models.ForwardingRule
 	var headersJSON, backoffJSON string
-	var conditionConfigJSON []byte
+	var conditionConfigJSON, shadowIgnoreJSON, transportJSON, authJSON []byte
+	var clientKey, authSecret *string
 	var method, conditionType *string
 
 	err := scanner.Scan(
@@ -401,6 +570,13 @@ func scanForwardingRule(scanner interface {
 		&backoffJSON,
 		&conditionType,
 		&conditionConfigJSON,
+		&rule.AppendSubpath,
+		&rule.ShadowTargetURL,
+		&shadowIgnoreJSON,
+		&transportJSON,
+		&clientKey,
+		&authJSON,
+		&authSecret,
 		&rule.CreatedAt,
 		&rule.UpdatedAt,
 	)
@@ -412,10 +588,32 @@ func scanForwardingRule(scanner interface {
 	rule.ConditionType = conditionType
 
 	json.Unmarshal([]byte(headersJSON), &rule.Headers)
+	if err := openRuleHeaders(rule.Headers); err != nil {
+		return rule, err
+	}
 	json.Unmarshal([]byte(backoffJSON), &rule.BackoffConfig)
 	if len(conditionConfigJSON) > 0 {
 		json.Unmarshal(conditionConfigJSON, &rule.ConditionConfig)
 	}
+	if len(shadowIgnoreJSON) > 0 {
+		json.Unmarshal(shadowIgnoreJSON, &rule.ShadowIgnorePaths)
+	}
+	if len(transportJSON) > 0 {
+		rule.Transport = &models.TransportConfig{}
+		json.Unmarshal(transportJSON, rule.Transport)
+		if clientKey != nil {
+			rule.Transport.ClientKeyEncrypted = *clientKey
+			rule.Transport.HasClientKey = true
+		}
+	}
+	if len(authJSON) > 0 {
+		rule.Auth = &models.AuthConfig{}
+		json.Unmarshal(authJSON, rule.Auth)
+		if authSecret != nil {
+			rule.Auth.SecretEncrypted = *authSecret
+			rule.Auth.HasSecret = true
+		}
+	}
 
 	return rule, nil
 }
@@ -0,0 +1,510 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"flowhook/internal/db"
+	"flowhook/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxImportSize     = 100 * 1024 * 1024 // 100MB
+	importBatchSize   = 500
+	maxImportErrors   = 20
+	maxNDJSONLineSize = 16 * 1024 * 1024
+)
+
+// importedRequest is a request parsed from an import file, before it is stored
+type importedRequest struct {
+	Method      string
+	Subpath     string // Escaped path below /e/:slug
+	Headers     http.Header
+	QueryParams url.Values
+	IP          *string
+	Body        []byte
+	ReceivedAt  time.Time
+}
+
+// importResult summarizes an import
+type importResult struct {
+	Format    string   `json:"format"`
+	Imported  int      `json:"imported"`
+	Skipped   int      `json:"skipped"`
+	Forwarded bool     `json:"forwarded"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// ImportRequests handles POST /api/v1/endpoints/:slug/import?format=har|ndjson|json&skip_forwarding=true
+func ImportRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := strings.TrimPrefix(r.URL.Path, "/api/v1/endpoints/")
+	slug = strings.TrimSuffix(slug, "/import")
+
+	var endpointID uuid.UUID
+	err := db.Pool.QueryRow(r.Context(), `SELECT id FROM endpoints WHERE slug = $1`, slug).Scan(&endpointID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Large uploads can outlast the server's read and write timeouts
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, maxImportSize))
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format, err = detectImportFormat(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result := importResult{Format: format, Forwarded: r.URL.Query().Get("skip_forwarding") != "true"}
+	var entries []importedRequest
+
+	switch format {
+	case "har":
+		entries, err = parseHARImport(body, &result)
+	case "ndjson":
+		entries, err = parseNDJSONImport(body, &result)
+	case "json":
+		entries, err = parseExportJSONImport(body, &result)
+	default:
+		http.Error(w, "format must be one of: har, ndjson, json", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid %s file: %v", format, err), http.StatusBadRequest)
+		return
+	}
+
+	captured, err := storeImportedRequests(r.Context(), endpointID, slug, entries)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save requests: %v", err), http.StatusInternalServerError)
+		return
+	}
+	result.Imported = len(captured)
+
+	if result.Forwarded {
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			for _, c := range captured {
+				triggerForwarding(ctx, c, nil)
+			}
+		}()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// detectImportFormat guesses the format from the start of the upload. HAR and
+// ExportRequest JSON are single documents; NDJSON has one object per line.
+func detectImportFormat(body *bufio.Reader) (string, error) {
+	head, _ := body.Peek(64 * 1024)
+	trimmed := bytes.TrimSpace(head)
+	if len(trimmed) == 0 {
+		return "", fmt.Errorf("import file is empty")
+	}
+	if trimmed[0] == '[' {
+		return "json", nil
+	}
+	if trimmed[0] != '{' {
+		return "", fmt.Errorf("could not detect import format; pass ?format=har|ndjson|json")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	var first map[string]json.RawMessage
+	if err := dec.Decode(&first); err != nil {
+		// The first document is larger than the peeked window
+		start := trimmed[:min(len(trimmed), 256)]
+		if bytes.Contains(start, []byte(`"log"`)) {
+			return "har", nil
+		}
+		if bytes.Contains(trimmed, []byte("\n{")) {
+			return "ndjson", nil
+		}
+		return "json", nil
+	}
+	if _, ok := first["log"]; ok {
+		return "har", nil
+	}
+	if bytes.Contains(trimmed[dec.InputOffset():], []byte("{")) {
+		return "ndjson", nil
+	}
+	return "json", nil
+}
+
+// parseHARImport reads the request side of every HAR entry
+func parseHARImport(body io.Reader, result *importResult) ([]importedRequest, error) {
+	var har struct {
+		Log struct {
+			Entries []struct {
+				StartedDateTime string `json:"startedDateTime"`
+				Request         struct {
+					Method  string `json:"method"`
+					URL     string `json:"url"`
+					Headers []struct {
+						Name  string `json:"name"`
+						Value string `json:"value"`
+					} `json:"headers"`
+					PostData *struct {
+						MimeType string `json:"mimeType"`
+						Text     string `json:"text"`
+						Encoding string `json:"encoding"`
+					} `json:"postData"`
+				} `json:"request"`
+			} `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.NewDecoder(body).Decode(&har); err != nil {
+		return nil, err
+	}
+
+	var entries []importedRequest
+	for i, entry := range har.Log.Entries {
+		headers := http.Header{}
+		for _, h := range entry.Request.Headers {
+			// HTTP/2 pseudo-headers are not real request headers
+			if strings.HasPrefix(h.Name, ":") {
+				continue
+			}
+			headers.Add(h.Name, h.Value)
+		}
+
+		var reqBody []byte
+		if pd := entry.Request.PostData; pd != nil && pd.Text != "" {
+			reqBody = []byte(pd.Text)
+			if pd.Encoding == "base64" {
+				decoded, err := base64.StdEncoding.DecodeString(pd.Text)
+				if err != nil {
+					result.addError(fmt.Sprintf("entry %d: invalid base64 body", i))
+					continue
+				}
+				reqBody = decoded
+			}
+			if headers.Get("Content-Type") == "" && pd.MimeType != "" {
+				headers.Set("Content-Type", pd.MimeType)
+			}
+		}
+
+		receivedAt, _ := time.Parse(time.RFC3339Nano, entry.StartedDateTime)
+
+		imported, err := newImportedRequest(entry.Request.Method, entry.Request.URL, "", headers, nil, reqBody, receivedAt)
+		if err != nil {
+			result.addError(fmt.Sprintf("entry %d: %v", i, err))
+			continue
+		}
+		entries = append(entries, imported)
+	}
+	return entries, nil
+}
+
+// parseNDJSONImport reads one request per line, as written by the bulk export
+func parseNDJSONImport(body io.Reader, result *importResult) ([]importedRequest, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxNDJSONLineSize)
+
+	var entries []importedRequest
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var record struct {
+			models.Request
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(line, &record); err != nil {
+			result.addError(fmt.Sprintf("line %d: %v", lineNumber, err))
+			continue
+		}
+
+		imported, err := importedFromRecord(record.Request, record.URL)
+		if err != nil {
+			result.addError(fmt.Sprintf("line %d: %v", lineNumber, err))
+			continue
+		}
+		entries = append(entries, imported)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// parseExportJSONImport reads the single-request JSON written by ExportRequest
+// (format=json), or an array of them
+func parseExportJSONImport(body io.Reader, result *importResult) ([]importedRequest, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	type exportJSON struct {
+		Method     string                 `json:"method"`
+		URL        string                 `json:"url"`
+		Headers    map[string]interface{} `json:"headers"`
+		Body       string                 `json:"body"`
+		ReceivedAt time.Time              `json:"received_at"`
+	}
+	var records []exportJSON
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &records)
+	} else {
+		var record exportJSON
+		err = json.Unmarshal(trimmed, &record)
+		records = []exportJSON{record}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []importedRequest
+	for i, record := range records {
+		imported, err := importedFromRecord(models.Request{
+			Method:     record.Method,
+			Headers:    record.Headers,
+			Body:       &record.Body,
+			ReceivedAt: record.ReceivedAt,
+		}, record.URL)
+		if err != nil {
+			result.addError(fmt.Sprintf("record %d: %v", i, err))
+			continue
+		}
+		entries = append(entries, imported)
+	}
+	return entries, nil
+}
+
+// importedFromRecord converts a FlowHook request record, preferring its stored
+// subpath and query params over those in its URL
+func importedFromRecord(req models.Request, requestURL string) (importedRequest, error) {
+	headers := http.Header{}
+	for k, v := range req.Headers {
+		for _, val := range stringValues(v) {
+			headers.Add(k, val)
+		}
+	}
+
+	var query url.Values
+	if len(req.QueryParams) > 0 {
+		query = url.Values{}
+		for k, v := range req.QueryParams {
+			query[k] = stringValues(v)
+		}
+	}
+
+	if requestURL == "" {
+		requestURL = derefString(req.Path)
+	}
+	body := []byte(derefString(req.Body))
+
+	imported, err := newImportedRequest(req.Method, requestURL, derefString(req.Subpath), headers, query, body, req.ReceivedAt)
+	// Drop addresses the INET column would reject
+	if req.IP != nil && net.ParseIP(*req.IP) != nil {
+		imported.IP = req.IP
+	}
+	return imported, err
+}
+
+// newImportedRequest validates an entry and derives its subpath and query from
+// the URL unless given explicitly
+func newImportedRequest(method, requestURL, subpath string, headers http.Header, query url.Values, body []byte, receivedAt time.Time) (importedRequest, error) {
+	method = strings.ToUpper(strings.TrimSpace(method))
+	if method == "" {
+		return importedRequest{}, fmt.Errorf("missing method")
+	}
+
+	if subpath == "" || query == nil {
+		parsed, err := url.Parse(requestURL)
+		if err != nil {
+			return importedRequest{}, fmt.Errorf("invalid url: %v", err)
+		}
+		if subpath == "" {
+			subpath = importSubpath(parsed.EscapedPath())
+		}
+		if query == nil {
+			query = parsed.Query()
+		}
+	}
+
+	if receivedAt.IsZero() {
+		receivedAt = time.Now()
+	}
+
+	return importedRequest{
+		Method:      method,
+		Subpath:     subpath,
+		Headers:     headers,
+		QueryParams: query,
+		Body:        body,
+		ReceivedAt:  receivedAt,
+	}, nil
+}
+
+// importSubpath strips a FlowHook capture prefix (/e/:slug) from a path. Other
+// paths are kept whole so browser recordings still route by path.
+func importSubpath(escapedPath string) string {
+	if strings.HasPrefix(escapedPath, "/e/") {
+		rest := strings.TrimPrefix(escapedPath, "/e/")
+		if i := strings.Index(rest, "/"); i >= 0 {
+			return rest[i:]
+		}
+		return ""
+	}
+	if escapedPath == "/" {
+		return ""
+	}
+	return escapedPath
+}
+
+// storeImportedRequests inserts entries in batches within one transaction,
+// keeping their original timestamps
+func storeImportedRequests(ctx context.Context, endpointID uuid.UUID, slug string, entries []importedRequest) ([]capturedRequest, error) {
+	captured := make([]capturedRequest, 0, len(entries))
+
+	encryptPayloads, err := endpointEncryptsPayloads(ctx, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	redaction, err := endpointRedaction(ctx, endpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	for start := 0; start < len(entries); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		batch := &pgx.Batch{}
+		for _, entry := range entries[start:end] {
+			requestID := uuid.New()
+
+			// Imports are redacted like captures
+			forwarded := entry
+			if redaction.redactor != nil {
+				entry.Headers = redaction.redactor.Headers(entry.Headers)
+				entry.QueryParams = redaction.redactor.Query(entry.QueryParams)
+				entry.Body = redaction.redactor.Body(entry.Body)
+				if !redaction.forwardUnredacted {
+					forwarded = entry
+				}
+			}
+
+			headersJSON, _ := json.Marshal(entry.Headers)
+			queryParamsJSON, _ := json.Marshal(entry.QueryParams)
+
+			// Store binary bodies base64 encoded, as capture does
+			var bodyStr *string
+			if len(entry.Body) > 0 {
+				bodyString := string(entry.Body)
+				if !utf8.Valid(entry.Body) {
+					bodyString = base64.StdEncoding.EncodeToString(entry.Body)
+				}
+				bodyStr = &bodyString
+			}
+
+			var contentType *string
+			if ct := entry.Headers.Get("Content-Type"); ct != "" {
+				contentType = &ct
+			}
+
+			storedHeaders, storedBody, encryptedPayload, err := sealRequestPayload(encryptPayloads, string(headersJSON), bodyStr)
+			if err != nil {
+				return nil, err
+			}
+
+			path := "/e/" + slug
+			if unescaped, err := url.PathUnescape(entry.Subpath); err == nil {
+				path += unescaped
+			} else {
+				path += entry.Subpath
+			}
+
+			batch.Queue(
+				`INSERT INTO requests (id, endpoint_id, method, path, subpath, headers, query_params, ip, body, body_size, content_type, received_at, encrypted_payload, correlation_id)
+				 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+				requestID,
+				endpointID,
+				entry.Method,
+				path,
+				entry.Subpath,
+				storedHeaders,
+				string(queryParamsJSON),
+				entry.IP,
+				storedBody,
+				len(entry.Body),
+				contentType,
+				entry.ReceivedAt,
+				encryptedPayload,
+				correlationID(ctx),
+			)
+
+			forwardedHeadersJSON, _ := json.Marshal(forwarded.Headers)
+			captured = append(captured, capturedRequest{
+				ID:          requestID,
+				EndpointID:  endpointID,
+				Method:      entry.Method,
+				Subpath:     entry.Subpath,
+				RawQuery:    forwarded.QueryParams.Encode(),
+				HeadersJSON: string(forwardedHeadersJSON),
+				Body:        forwarded.Body,
+			})
+		}
+
+		if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return captured, nil
+}
+
+func (r *importResult) addError(msg string) {
+	r.Skipped++
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, msg)
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Jobs kept in the database, bulk replays and export jobs, are run by the
+// process holding the job's lease. The runner renews the lease while it
+// works, so when its process dies the lease expires and another replica can
+// tell the job was abandoned.
+const (
+	jobLeaseDuration     = 30 * time.Second
+	jobHeartbeatInterval = 10 * time.Second
+)
+
+// instanceID identifies this process as the holder of job leases
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	id := uuid.NewString()
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host + "/" + id
+	}
+	return id
+}
@@ -186,7 +186,7 @@ func GetMetrics(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(metrics); err != nil {
-		logger.Error("Failed to encode metrics: %v", err)
+		logger.ErrorContext(ctx, "Failed to encode metrics", "error", err)
 		http.Error(w, "Failed to encode metrics", http.StatusInternalServerError)
 	}
 }
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"flowhook/internal/db"
+
+	"github.com/google/uuid"
+)
+
+// pageCursor is the position of a row in a newest-first listing ordered by (time, id).
+// It is handed to clients as an opaque base64 token.
+type pageCursor struct {
+	Time     time.Time `json:"t"`
+	ID       uuid.UUID `json:"id"`
+	Backward bool      `json:"b,omitempty"` // Page towards newer rows
+}
+
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string) (*pageCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == uuid.Nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &c, nil
+}
+
+// parsePageParams reads ?cursor= and ?limit= with the given default and maximum limit
+func parsePageParams(r *http.Request, defaultLimit, maxLimit int) (*pageCursor, int, error) {
+	limit := defaultLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	cursor, err := decodeCursor(r.URL.Query().Get("cursor"))
+	return cursor, limit, err
+}
+
+// applyKeyset appends the cursor condition, ordering and limit for a newest-first
+// listing on (timeColumn, id). One extra row is fetched to detect further pages.
+func applyKeyset(query string, args []interface{}, timeColumn string, cursor *pageCursor, limit int) (string, []interface{}) {
+	order := "DESC"
+	if cursor != nil {
+		cmp := "<"
+		if cursor.Backward {
+			cmp = ">"
+			order = "ASC"
+		}
+		args = append(args, cursor.Time, cursor.ID)
+		query += fmt.Sprintf(" AND (%s, id) %s ($%d, $%d)", timeColumn, cmp, len(args)-1, len(args))
+	}
+
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT $%d", timeColumn, order, order, len(args))
+	return query, args
+}
+
+// finishPage trims the look-ahead row, restores newest-first order for backward
+// pages and builds the next/prev cursors
+func finishPage[T any](items []T, cursor *pageCursor, limit int, key func(T) (time.Time, uuid.UUID)) ([]T, *string, *string) {
+	hasMore := len(items) > limit
+	if hasMore {
+		items = items[:limit]
+	}
+
+	backward := cursor != nil && cursor.Backward
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	if len(items) == 0 {
+		return items, nil, nil
+	}
+
+	var next, prev *string
+	// Older rows remain when paging forward found more, or always when paging back
+	if (!backward && hasMore) || backward {
+		t, id := key(items[len(items)-1])
+		token := encodeCursor(pageCursor{Time: t, ID: id})
+		next = &token
+	}
+	// Newer rows remain whenever a cursor was used forward, or paging back found more
+	if (cursor != nil && !backward) || (backward && hasMore) {
+		t, id := key(items[0])
+		token := encodeCursor(pageCursor{Time: t, ID: id, Backward: true})
+		prev = &token
+	}
+
+	return items, next, prev
+}
+
+// setCursorHeaders exposes page cursors on endpoints that return bare arrays
+func setCursorHeaders(w http.ResponseWriter, next, prev *string) {
+	if next != nil {
+		w.Header().Set("X-Next-Cursor", *next)
+	}
+	if prev != nil {
+		w.Header().Set("X-Prev-Cursor", *prev)
+	}
+}
+
+// estimateRowCount returns the planner's row estimate for a filtered query,
+// which is far cheaper than COUNT(*) on large tables
+func estimateRowCount(ctx context.Context, query string, args ...interface{}) (int, error) {
+	var plan []struct {
+		Plan struct {
+			PlanRows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	var planJSON []byte
+	if err := db.Pool.QueryRow(ctx, "EXPLAIN (FORMAT JSON) "+query, args...).Scan(&planJSON); err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(planJSON, &plan); err != nil || len(plan) == 0 {
+		return 0, fmt.Errorf("failed to read query plan: %v", err)
+	}
+	return int(plan[0].Plan.PlanRows), nil
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"flowhook/internal/db"
+	"flowhook/internal/logger"
+	"flowhook/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// partitionMonthsAhead is how many months of partitions are kept created
+// ahead of the current one
+const partitionMonthsAhead = 3
+
+// requestDependents are the tables whose rows are deleted with their request
+// (see delete_request_dependents in migration 021)
+var requestDependents = []string{"forward_attempts", "shadow_attempts", "bulk_replay_items", "replays"}
+
+// monthlyPartition is one month of a table partitioned by create_monthly_partitions
+type monthlyPartition struct {
+	name     string
+	from, to time.Time
+}
+
+// ensurePartitions creates the partitions of requests and forward_attempts
+// for the current month and partitionMonthsAhead months after it
+func ensurePartitions(ctx context.Context) error {
+	for _, table := range []string{"requests", "forward_attempts"} {
+		var created int
+		err := db.Pool.QueryRow(
+			ctx,
+			`SELECT create_monthly_partitions($1, now(), now() + make_interval(months => $2))`,
+			table,
+			partitionMonthsAhead,
+		).Scan(&created)
+		if err != nil {
+			return fmt.Errorf("failed to create %s partitions: %w", table, err)
+		}
+		if created > 0 {
+			logger.InfoContext(ctx, "Created partitions", "table", table, "count", created)
+		}
+	}
+	return nil
+}
+
+// monthlyPartitions lists the monthly partitions of table, oldest first. The
+// default partition is not included.
+func monthlyPartitions(ctx context.Context, table string) ([]monthlyPartition, error) {
+	rows, err := db.Pool.Query(
+		ctx,
+		`SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		 WHERE i.inhparent = $1::regclass`,
+		table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []monthlyPartition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		month, err := time.Parse("200601", strings.TrimPrefix(name, table+"_p"))
+		if err != nil {
+			continue
+		}
+		partitions = append(partitions, monthlyPartition{name: name, from: month, to: month.AddDate(0, 1, 0)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].from.Before(partitions[j].from) })
+	return partitions, nil
+}
+
+// dropExpiredPartitions drops the past requests partitions in which every
+// request is expired under its endpoint's policy, given the cutoffs computed
+// by CleanupOldRequests. Requests of endpoints that archive are archived
+// first. Forward attempt partitions are dropped once older than every
+// remaining request.
+func dropExpiredPartitions(ctx context.Context, policies map[uuid.UUID]models.RetentionPolicy, cutoffs map[uuid.UUID]time.Time) error {
+	partitions, err := monthlyPartitions(ctx, "requests")
+	if err != nil {
+		return err
+	}
+
+	for _, partition := range partitions {
+		if !partition.to.Before(time.Now()) {
+			break
+		}
+
+		endpoints, err := partitionEndpoints(ctx, db.Pool, partition.name)
+		if err != nil {
+			return err
+		}
+		if !partitionExpired(endpoints, cutoffs, partition.to) {
+			continue
+		}
+
+		for endpointID := range endpoints {
+			policy := policies[endpointID]
+			if !policy.ArchiveEnabled {
+				continue
+			}
+			archiveDir := ""
+			if policy.ArchivePath != nil {
+				archiveDir = *policy.ArchivePath
+			}
+			archived, err := archiveExpiredRequests(ctx, endpointID, archiveDir, partition.to)
+			if err != nil {
+				return fmt.Errorf("failed to archive requests of endpoint %s: %w", endpointID, err)
+			}
+			if archived > 0 {
+				logger.InfoContext(ctx, "Cleaned up old requests", "endpoint_id", endpointID, "count", archived)
+			}
+		}
+
+		dropped, err := dropRequestPartition(ctx, partition, policies, cutoffs)
+		if err != nil {
+			return fmt.Errorf("failed to drop partition %s: %w", partition.name, err)
+		}
+		if dropped {
+			logger.InfoContext(ctx, "Dropped expired partition", "partition", partition.name)
+		}
+	}
+
+	// Attempts always follow their request, so a partition older than every
+	// remaining request only holds attempts of deleted requests
+	var oldest *time.Time
+	if err := db.Pool.QueryRow(ctx, `SELECT MIN(received_at) FROM requests`).Scan(&oldest); err != nil {
+		return err
+	}
+	if oldest == nil {
+		now := time.Now()
+		oldest = &now
+	}
+
+	attemptPartitions, err := monthlyPartitions(ctx, "forward_attempts")
+	if err != nil {
+		return err
+	}
+	for _, partition := range attemptPartitions {
+		if partition.to.After(*oldest) {
+			break
+		}
+		if _, err := db.Pool.Exec(ctx, `DROP TABLE `+pgx.Identifier{partition.name}.Sanitize()); err != nil {
+			return fmt.Errorf("failed to drop partition %s: %w", partition.name, err)
+		}
+		logger.InfoContext(ctx, "Dropped expired partition", "partition", partition.name)
+	}
+
+	return nil
+}
+
+// dropRequestPartition drops a requests partition, with the rows referencing
+// its requests, if it is still expired once locked against new inserts
+func dropRequestPartition(ctx context.Context, partition monthlyPartition, policies map[uuid.UUID]models.RetentionPolicy, cutoffs map[uuid.UUID]time.Time) (bool, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	table := pgx.Identifier{partition.name}.Sanitize()
+	if _, err := tx.Exec(ctx, `LOCK TABLE `+table+` IN ACCESS EXCLUSIVE MODE`); err != nil {
+		return false, err
+	}
+
+	// Requests imported into the partition since it was checked, or left by
+	// an endpoint that archives, keep it for the next run
+	endpoints, err := partitionEndpoints(ctx, tx, partition.name)
+	if err != nil {
+		return false, err
+	}
+	if !partitionExpired(endpoints, cutoffs, partition.to) {
+		return false, nil
+	}
+	for endpointID := range endpoints {
+		if policies[endpointID].ArchiveEnabled {
+			return false, nil
+		}
+	}
+
+	for _, dependent := range requestDependents {
+		_, err := tx.Exec(ctx, `DELETE FROM `+pgx.Identifier{dependent}.Sanitize()+` WHERE request_id IN (SELECT id FROM `+table+`)`)
+		if err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(ctx, `DROP TABLE `+table); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// partitionEndpoints returns the endpoints with requests in a partition, with
+// the time their most recently restored request there was restored, if any
+func partitionEndpoints(ctx context.Context, q interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}, partition string) (map[uuid.UUID]*time.Time, error) {
+	rows, err := q.Query(ctx, `SELECT endpoint_id, MAX(restored_at) FROM `+pgx.Identifier{partition}.Sanitize()+` GROUP BY endpoint_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := make(map[uuid.UUID]*time.Time)
+	for rows.Next() {
+		var endpointID uuid.UUID
+		var restoredAt *time.Time
+		if err := rows.Scan(&endpointID, &restoredAt); err != nil {
+			return nil, err
+		}
+		endpoints[endpointID] = restoredAt
+	}
+	return endpoints, rows.Err()
+}
+
+// partitionExpired reports whether every request in a partition ending at end
+// is expired: each endpoint with requests there must expire requests up to at
+// least end, and restored requests are kept until their restore expires
+func partitionExpired(endpoints map[uuid.UUID]*time.Time, cutoffs map[uuid.UUID]time.Time, end time.Time) bool {
+	for endpointID, restoredAt := range endpoints {
+		cutoff, ok := cutoffs[endpointID]
+		if !ok || cutoff.Before(end) {
+			return false
+		}
+		if restoredAt != nil && !restoredAt.Before(cutoff) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"flowhook/internal/db"
+	"flowhook/internal/logger"
+	"flowhook/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// hopByHopHeaders are connection-specific headers that must not be relayed by a proxy
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Content-Length":      true,
+	"Content-Encoding":    true,
+}
+
+// proxyWriteMargin is the time left to relay the response once the
+// downstream answers
+const proxyWriteMargin = 10 * time.Second
+
+// proxyRequest synchronously forwards a captured request to the endpoint's primary
+// forwarding rule and relays the downstream status, headers and body to the sender.
+// Returns the ID of the rule that was used so async forwarding can skip it.
+func proxyRequest(w http.ResponseWriter, r *http.Request, captured capturedRequest, primaryRuleID *uuid.UUID) *uuid.UUID {
+	ctx := r.Context()
+
+	rule, err := getPrimaryForwardingRule(ctx, captured.EndpointID, primaryRuleID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "No primary forwarding rule configured for proxy mode", http.StatusBadGateway)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return nil
+	}
+	if !rule.Enabled {
+		http.Error(w, "Primary forwarding rule is disabled", http.StatusBadGateway)
+		return nil
+	}
+
+	// The downstream may take longer than the server's write timeout allows
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(ruleTimeout(rule) + proxyWriteMargin))
+
+	method, requestHeaders, forwardBody := buildForwardPayload(ctx, rule, captured)
+	headers := withRuleHeaders(rule, requestHeaders)
+
+	// Let the transport negotiate compression so the relayed body is always decoded
+	for k := range headers {
+		if http.CanonicalHeaderKey(k) == "Accept-Encoding" {
+			delete(headers, k)
+		}
+	}
+
+	compareShadow := startShadow(ctx, rule, captured, method, requestHeaders, forwardBody)
+	result := executeForward(ctx, captured.ID, rule, 1, resolveTargetURL(rule, captured), method, headers, forwardBody)
+	if compareShadow != nil {
+		go compareShadow(result)
+	}
+	if result.Err != nil {
+		http.Error(w, fmt.Sprintf("Upstream request failed: %v", result.Err), http.StatusBadGateway)
+		return &rule.ID
+	}
+
+	for k, values := range result.Headers {
+		// The response keeps this capture's X-Request-ID rather than the upstream's
+		if hopByHopHeaders[http.CanonicalHeaderKey(k)] || http.CanonicalHeaderKey(k) == logger.RequestIDHeader {
+			continue
+		}
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	w.Header().Set("X-FlowHook-Request-ID", captured.ID.String())
+	w.WriteHeader(result.StatusCode)
+	w.Write(result.TransformedBody)
+
+	return &rule.ID
+}
+
+// getPrimaryForwardingRule returns the configured primary rule, falling back to
+// the oldest enabled rule for the endpoint
+func getPrimaryForwardingRule(ctx context.Context, endpointID uuid.UUID, primaryRuleID *uuid.UUID) (models.ForwardingRule, error) {
+	if primaryRuleID != nil {
+		return getForwardingRuleByID(ctx, *primaryRuleID)
+	}
+
+	row := db.Pool.QueryRow(
+		ctx,
+		`SELECT `+forwardingRuleColumns+`
+		 FROM forwarding_rules WHERE endpoint_id = $1 AND enabled = TRUE
+		 ORDER BY created_at ASC LIMIT 1`,
+		endpointID,
+	)
+	return scanForwardingRule(row)
+}
@@ -5,6 +5,14 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+
+	"flowhook/internal/db"
+	"flowhook/internal/metrics"
+	"flowhook/internal/models"
+	"flowhook/internal/search"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 // SSE connection manager
@@ -15,6 +23,7 @@ type sseManager struct {
 
 type sseConnection struct {
 	endpointID string
+	filter     *search.Query
 	ch         chan []byte
 }
 
@@ -22,7 +31,7 @@ var sseMgr = &sseManager{
 	connections: make(map[string]map[*sseConnection]bool),
 }
 
-// RealtimeHandler handles GET /api/v1/realtime?endpoint=:slug
+// RealtimeHandler handles GET /api/v1/realtime?endpoint=:slug[&q=search]
 func RealtimeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -36,8 +45,30 @@ func RealtimeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get endpoint ID (we'll use slug as the key for simplicity)
-	endpointKey := slug
+	// Optional search filter, same syntax as the request list
+	filter, err := search.Parse(r.URL.Query().Get("q"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid search query: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Events are published by endpoint ID, so resolve the slug
+	var endpointID uuid.UUID
+	err = db.Pool.QueryRow(
+		r.Context(),
+		`SELECT id FROM endpoints WHERE slug = $1`,
+		slug,
+	).Scan(&endpointID)
+
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	endpointKey := endpointID.String()
 
 	// Set up SSE headers
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -49,6 +80,7 @@ func RealtimeHandler(w http.ResponseWriter, r *http.Request) {
 	// Create connection
 	conn := &sseConnection{
 		endpointID: endpointKey,
+		filter:     filter,
 		ch:         make(chan []byte, 10),
 	}
 
@@ -59,6 +91,7 @@ func RealtimeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	sseMgr.connections[endpointKey][conn] = true
 	sseMgr.mu.Unlock()
+	metrics.SSEConnected()
 
 	// Send initial connection message
 	fmt.Fprintf(w, "data: %s\n\n", `{"type":"connected"}`)
@@ -82,6 +115,7 @@ func RealtimeHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			sseMgr.mu.Unlock()
 			close(conn.ch)
+			metrics.SSEDisconnected()
 			return
 
 		case data := <-conn.ch:
@@ -94,31 +128,33 @@ func RealtimeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// broadcastToSSE sends an event to all SSE connections for an endpoint
-func broadcastToSSE(endpointKey string, event interface{}) {
+// broadcastToSSE sends a captured request to all SSE connections for an endpoint
+// whose filter matches it. The body is used for matching but not sent.
+func broadcastToSSE(endpointKey string, req models.Request) {
 	sseMgr.mu.RLock()
-	connections := sseMgr.connections[endpointKey]
-	sseMgr.mu.RUnlock()
+	defer sseMgr.mu.RUnlock()
 
-	if connections == nil || len(connections) == 0 {
+	connections := sseMgr.connections[endpointKey]
+	if len(connections) == 0 {
 		return
 	}
 
-	// Marshal event to JSON
+	event := req
+	event.Body = nil
 	data, err := json.Marshal(event)
 	if err != nil {
 		return
 	}
 
-	// Send to all connections (non-blocking)
-	sseMgr.mu.RLock()
+	// Send to all matching connections (non-blocking)
 	for conn := range connections {
+		if !conn.filter.Match(req) {
+			continue
+		}
 		select {
 		case conn.ch <- data:
 		default:
 			// Channel full, skip
 		}
 	}
-	sseMgr.mu.RUnlock()
 }
-
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"flowhook/internal/db"
+	"flowhook/internal/models"
+	"flowhook/internal/redact"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// GetRedactionPolicy handles GET /api/v1/endpoints/:slug/redaction
+func GetRedactionPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := strings.TrimPrefix(r.URL.Path, "/api/v1/endpoints/")
+	slug = strings.TrimSuffix(slug, "/redaction")
+
+	var endpointID uuid.UUID
+	err := db.Pool.QueryRow(
+		r.Context(),
+		`SELECT id FROM endpoints WHERE slug = $1`,
+		slug,
+	).Scan(&endpointID)
+
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	policy, err := getRedactionPolicy(r.Context(), endpointID)
+	if err == pgx.ErrNoRows {
+		// Return an empty policy, which redacts nothing
+		policy = models.RedactionPolicy{
+			EndpointID:  endpointID,
+			MaskHeaders: []string{},
+			HashPaths:   []string{},
+			DropPaths:   []string{},
+			Detectors:   []string{},
+			Patterns:    []string{},
+		}
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// UpdateRedactionPolicy handles PUT /api/v1/endpoints/:slug/redaction. The
+// policy is replaced as a whole and applies to requests captured afterwards.
+func UpdateRedactionPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := strings.TrimPrefix(r.URL.Path, "/api/v1/endpoints/")
+	slug = strings.TrimSuffix(slug, "/redaction")
+
+	var endpointID uuid.UUID
+	err := db.Pool.QueryRow(
+		r.Context(),
+		`SELECT id FROM endpoints WHERE slug = $1`,
+		slug,
+	).Scan(&endpointID)
+
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var req models.UpdateRedactionPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	policy := models.RedactionPolicy{
+		MaskHeaders:       nonNil(req.MaskHeaders),
+		HashPaths:         nonNil(req.HashPaths),
+		DropPaths:         nonNil(req.DropPaths),
+		Detectors:         nonNil(req.Detectors),
+		Patterns:          nonNil(req.Patterns),
+		ForwardUnredacted: req.ForwardUnredacted,
+	}
+	hashKey, err := redactionHashKey(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load redaction hash key: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := redact.New(policy, hashKey); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	maskHeadersJSON, _ := json.Marshal(policy.MaskHeaders)
+	hashPathsJSON, _ := json.Marshal(policy.HashPaths)
+	dropPathsJSON, _ := json.Marshal(policy.DropPaths)
+	detectorsJSON, _ := json.Marshal(policy.Detectors)
+	patternsJSON, _ := json.Marshal(policy.Patterns)
+
+	_, err = db.Pool.Exec(
+		r.Context(),
+		`INSERT INTO redaction_policies (endpoint_id, mask_headers, hash_paths, drop_paths, detectors, patterns, forward_unredacted, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, now())
+		 ON CONFLICT (endpoint_id)
+		 DO UPDATE SET
+		   mask_headers = $2,
+		   hash_paths = $3,
+		   drop_paths = $4,
+		   detectors = $5,
+		   patterns = $6,
+		   forward_unredacted = $7,
+		   updated_at = now()`,
+		endpointID,
+		string(maskHeadersJSON),
+		string(hashPathsJSON),
+		string(dropPathsJSON),
+		string(detectorsJSON),
+		string(patternsJSON),
+		policy.ForwardUnredacted,
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update redaction policy: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	GetRedactionPolicy(w, r)
+}
+
+func getRedactionPolicy(ctx context.Context, endpointID uuid.UUID) (models.RedactionPolicy, error) {
+	var policy models.RedactionPolicy
+	var maskHeadersJSON, hashPathsJSON, dropPathsJSON, detectorsJSON, patternsJSON []byte
+
+	err := db.Pool.QueryRow(
+		ctx,
+		`SELECT id, endpoint_id, mask_headers, hash_paths, drop_paths, detectors, patterns, forward_unredacted, created_at, updated_at
+		 FROM redaction_policies WHERE endpoint_id = $1`,
+		endpointID,
+	).Scan(
+		&policy.ID,
+		&policy.EndpointID,
+		&maskHeadersJSON,
+		&hashPathsJSON,
+		&dropPathsJSON,
+		&detectorsJSON,
+		&patternsJSON,
+		&policy.ForwardUnredacted,
+		&policy.CreatedAt,
+		&policy.UpdatedAt,
+	)
+	if err != nil {
+		return policy, err
+	}
+
+	json.Unmarshal(maskHeadersJSON, &policy.MaskHeaders)
+	json.Unmarshal(hashPathsJSON, &policy.HashPaths)
+	json.Unmarshal(dropPathsJSON, &policy.DropPaths)
+	json.Unmarshal(detectorsJSON, &policy.Detectors)
+	json.Unmarshal(patternsJSON, &policy.Patterns)
+	return policy, nil
+}
+
+// nonNil returns s, or an empty slice so the stored JSON is [] rather than null
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// compiledRedaction is an endpoint's compiled policy, cached until it is updated
+type compiledRedaction struct {
+	updatedAt         time.Time
+	redactor          *redact.Redactor
+	forwardUnredacted bool
+}
+
+var (
+	redactionCacheMu sync.Mutex
+	redactionCache   = make(map[uuid.UUID]compiledRedaction)
+)
+
+// endpointRedaction returns the compiled redaction policy of an endpoint. The
+// redactor is nil when the endpoint has no policy or it redacts nothing.
+func endpointRedaction(ctx context.Context, endpointID uuid.UUID) (compiledRedaction, error) {
+	var updatedAt time.Time
+	err := db.Pool.QueryRow(ctx, `SELECT updated_at FROM redaction_policies WHERE endpoint_id = $1`, endpointID).Scan(&updatedAt)
+	if err == pgx.ErrNoRows {
+		return compiledRedaction{}, nil
+	}
+	if err != nil {
+		return compiledRedaction{}, err
+	}
+
+	redactionCacheMu.Lock()
+	cached, ok := redactionCache[endpointID]
+	redactionCacheMu.Unlock()
+	if ok && cached.updatedAt.Equal(updatedAt) {
+		return cached, nil
+	}
+
+	policy, err := getRedactionPolicy(ctx, endpointID)
+	if err != nil {
+		return compiledRedaction{}, err
+	}
+	hashKey, err := redactionHashKey(ctx)
+	if err != nil {
+		return compiledRedaction{}, err
+	}
+	redactor, err := redact.New(policy, hashKey)
+	if err != nil {
+		return compiledRedaction{}, err
+	}
+	compiled := compiledRedaction{updatedAt: policy.UpdatedAt, redactor: redactor, forwardUnredacted: policy.ForwardUnredacted}
+
+	redactionCacheMu.Lock()
+	redactionCache[endpointID] = compiled
+	redactionCacheMu.Unlock()
+	return compiled, nil
+}
+
+// redactionHashKeyName is the install secret hash_paths digests are keyed with
+const redactionHashKeyName = "redaction_hash_key"
+
+var (
+	redactionHashKeyMu     sync.Mutex
+	cachedRedactionHashKey []byte
+)
+
+// redactionHashKey returns the key hash_paths digests are computed with. It is
+// shared by every replica, so equal values hash alike across the install.
+func redactionHashKey(ctx context.Context) ([]byte, error) {
+	redactionHashKeyMu.Lock()
+	defer redactionHashKeyMu.Unlock()
+	if cachedRedactionHashKey != nil {
+		return cachedRedactionHashKey, nil
+	}
+
+	key, err := installSecret(ctx, redactionHashKeyName, 32)
+	if err != nil {
+		return nil, err
+	}
+	cachedRedactionHashKey = key
+	return key, nil
+}
@@ -3,21 +3,24 @@ package handlers
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"time"
-	"unicode/utf8"
 
 	"flowhook/internal/db"
+	"flowhook/internal/logger"
 	"flowhook/internal/models"
+	"flowhook/internal/outbound"
+	"flowhook/internal/tracing"
 	"flowhook/internal/transform"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 // ReplayRequest handles POST /api/v1/requests/:id/replay
@@ -47,38 +50,156 @@ func ReplayRequest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "target_url is required", http.StatusBadRequest)
 		return
 	}
+	if err := outbound.CheckURL(replayReq.TargetURL); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid target_url: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := defaultReplayOptions.withOverrides(replayReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prepared, err := prepareReplay(r.Context(), requestID, replayReq, opts)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+	if err == errInvalidTransportRule {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create replay: %v", err), http.StatusInternalServerError)
+		return
+	}
 
+	// Execute replay asynchronously
+	go executeReplay(context.WithoutCancel(r.Context()), prepared)
+
+	response := models.CreateReplayResponse{
+		ReplayID: prepared.ReplayID,
+		Status:   "pending",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// preparedReplay is a replay record ready to be sent
+type preparedReplay struct {
+	ReplayID   uuid.UUID
+	EndpointID uuid.UUID
+	TargetURL  string
+	Method     string
+	Headers    map[string]interface{}
+	Body       string
+	Options    replayOptions
+}
+
+// replayOptions control how a replay is delivered
+type replayOptions struct {
+	MaxRetries      int // Total attempts
+	BackoffConfig   map[string]interface{}
+	TimeoutMs       int
+	FollowRedirects bool
+	TransportRuleID *uuid.UUID // Forwarding rule whose transport configuration is used
+}
+
+const maxReplayRetries = 10
+
+// defaultReplayOptions match the single 30s attempt replays have always made
+var defaultReplayOptions = replayOptions{
+	MaxRetries:      1,
+	BackoffConfig:   map[string]interface{}{},
+	TimeoutMs:       30000,
+	FollowRedirects: true,
+}
+
+// withOverrides returns o with the options set in req applied. Backoff keys
+// are merged, as they are when creating a forwarding rule.
+func (o replayOptions) withOverrides(req models.CreateReplayRequest) (replayOptions, error) {
+	if req.MaxRetries != nil {
+		if *req.MaxRetries < 1 || *req.MaxRetries > maxReplayRetries {
+			return o, fmt.Errorf("max_retries must be between 1 and %d", maxReplayRetries)
+		}
+		o.MaxRetries = *req.MaxRetries
+	}
+	if req.TimeoutMs != nil {
+		if *req.TimeoutMs < 1 || *req.TimeoutMs > maxTransportTimeoutMs {
+			return o, fmt.Errorf("timeout_ms must be between 1 and %d", maxTransportTimeoutMs)
+		}
+		o.TimeoutMs = *req.TimeoutMs
+	}
+	if req.FollowRedirects != nil {
+		o.FollowRedirects = *req.FollowRedirects
+	}
+	if req.TransportRuleID != nil {
+		o.TransportRuleID = req.TransportRuleID
+	}
+	if req.BackoffConfig != nil {
+		merged := make(map[string]interface{}, len(o.BackoffConfig)+len(req.BackoffConfig))
+		for k, v := range o.BackoffConfig {
+			merged[k] = v
+		}
+		for k, v := range req.BackoffConfig {
+			merged[k] = v
+		}
+		if t, ok := merged["type"]; ok && t != "exponential" && t != "linear" && t != "fixed" {
+			return o, fmt.Errorf("backoff_config.type must be one of: exponential, linear, fixed")
+		}
+		for _, key := range []string{"base", "min_ms", "max_ms"} {
+			if v, ok := merged[key]; ok {
+				if n, isNum := v.(float64); !isNum || n < 0 {
+					return o, fmt.Errorf("backoff_config.%s must be a non-negative number", key)
+				}
+			}
+		}
+		o.BackoffConfig = merged
+	}
+	return o, nil
+}
+
+// replayOutcome is the final result of a replay
+type replayOutcome struct {
+	Status         string // success|failed
+	ResponseStatus int
+	Error          string
+}
+
+// prepareReplay applies overrides and request transformations to a captured
+// request and creates its pending replay record
+func prepareReplay(ctx context.Context, requestID uuid.UUID, replayReq models.CreateReplayRequest, opts replayOptions) (*preparedReplay, error) {
 	// Fetch original request
 	var originalReq models.Request
-	var headersJSON, queryParamsJSON string
-	var path, ip, bodyStr, contentType *string
+	var headersJSON string
+	var bodyStr, encryptedPayload *string
 
-	err = db.Pool.QueryRow(
-		r.Context(),
-		`SELECT id, endpoint_id, method, path, headers, query_params, ip, body, body_size, content_type, received_at
+	err := db.Pool.QueryRow(
+		ctx,
+		`SELECT endpoint_id, method, headers, body, encrypted_payload
 		 FROM requests WHERE id = $1`,
 		requestID,
 	).Scan(
-		&originalReq.ID,
 		&originalReq.EndpointID,
 		&originalReq.Method,
-		&path,
 		&headersJSON,
-		&queryParamsJSON,
-		&ip,
 		&bodyStr,
-		&originalReq.BodySize,
-		&contentType,
-		&originalReq.ReceivedAt,
+		&encryptedPayload,
 	)
-
-	if err == pgx.ErrNoRows {
-		http.Error(w, "Request not found", http.StatusNotFound)
-		return
-	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	if encryptedPayload != nil {
+		headersJSON, bodyStr, err = openRequestPayload(*encryptedPayload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkTransportRule(ctx, opts.TransportRuleID, originalReq.EndpointID); err != nil {
+		return nil, err
 	}
 
 	// Parse original headers
@@ -123,10 +244,10 @@ func ReplayRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Apply transformations
-	transformedHeaders, transformedBody, err := transform.ApplyRequestTransformations(r.Context(), originalReq.EndpointID, replayHeaders, bodyData)
+	transformedHeaders, transformedBody, err := transform.ApplyRequestTransformations(ctx, originalReq.EndpointID, replayHeaders, bodyData)
 	if err != nil {
 		// Log but continue - transformations are optional
-		fmt.Printf("Warning: Failed to apply transformations during replay: %v\n", err)
+		logger.WarnContext(ctx, "Failed to apply transformations during replay", "request_id", requestID, "error", err)
 		transformedHeaders = replayHeaders
 		transformedBody = bodyData
 	}
@@ -148,59 +269,155 @@ func ReplayRequest(w http.ResponseWriter, r *http.Request) {
 		finalBody = replayBody
 	}
 
-	// Create replay record
-	replayID := uuid.New()
-	replayHeadersJSON, _ := json.Marshal(transformedHeaders)
+	prepared := &preparedReplay{
+		ReplayID:   uuid.New(),
+		EndpointID: originalReq.EndpointID,
+		TargetURL:  replayReq.TargetURL,
+		Method:     replayMethod,
+		Headers:    transformedHeaders,
+		Body:       finalBody,
+		Options:    opts,
+	}
+	if err := insertReplay(ctx, requestID, nil, prepared); err != nil {
+		return nil, err
+	}
+	return prepared, nil
+}
+
+// insertReplay creates the pending replay record for p. On endpoints that
+// encrypt payloads, its headers and body are stored encrypted.
+func insertReplay(ctx context.Context, requestID uuid.UUID, sourceReplayID *uuid.UUID, p *preparedReplay) error {
+	headersJSON, _ := json.Marshal(p.Headers)
+	backoffJSON, _ := json.Marshal(p.Options.BackoffConfig)
+
+	encryptPayloads, err := endpointEncryptsPayloads(ctx, p.EndpointID)
+	if err != nil {
+		return err
+	}
+	storedHeaders, storedBody, encryptedPayload, err := sealRequestPayload(encryptPayloads, string(headersJSON), &p.Body)
+	if err != nil {
+		return err
+	}
 
-	// Insert replay record
 	_, err = db.Pool.Exec(
-		r.Context(),
-		`INSERT INTO replays (id, request_id, target_url, method, headers, body, status)
-		 VALUES ($1, $2, $3, $4, $5, $6, 'pending')`,
-		replayID,
+		ctx,
+		`INSERT INTO replays (id, request_id, target_url, method, headers, body, encrypted_payload, status,
+		                      max_retries, backoff_config, timeout_ms, follow_redirects, source_replay_id, transport_rule_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, 'pending', $8, $9, $10, $11, $12, $13)`,
+		p.ReplayID,
 		requestID,
-		replayReq.TargetURL,
-		replayMethod,
-		string(replayHeadersJSON),
-		finalBody,
+		p.TargetURL,
+		p.Method,
+		storedHeaders,
+		storedBody,
+		encryptedPayload,
+		p.Options.MaxRetries,
+		string(backoffJSON),
+		p.Options.TimeoutMs,
+		p.Options.FollowRedirects,
+		sourceReplayID,
+		p.Options.TransportRuleID,
+	)
+	return err
+}
+
+// executeReplay sends the replay, retrying failed attempts with backoff,
+// and returns the outcome of the last attempt. It is traced as a child of
+// the span in ctx.
+func executeReplay(ctx context.Context, p *preparedReplay) (outcome replayOutcome) {
+	ctx, span := tracing.Start(ctx, "replay",
+		attribute.String("flowhook.replay.id", p.ReplayID.String()),
+		attribute.String("url.full", p.TargetURL),
 	)
+	defer func() {
+		if outcome.Status != "success" {
+			span.SetStatus(codes.Error, "replay failed")
+		}
+		span.End()
+	}()
 
+	timeout := time.Duration(p.Options.TimeoutMs) * time.Millisecond
+	client, err := transportRuleClient(ctx, p.Options.TransportRuleID, timeout, p.Options.FollowRedirects)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create replay: %v", err), http.StatusInternalServerError)
-		return
+		// A broken transport configuration will not fix itself between retries
+		errMsg := fmt.Sprintf("Failed to configure transport: %v", err)
+		recordReplayAttempt(ctx, p.ReplayID, 1, "failed", 0, nil, nil, nil, &errMsg, nil)
+		updateReplayStatus(ctx, p.ReplayID, "failed", 0, nil, nil, nil, &errMsg)
+		return replayOutcome{Status: "failed", Error: errMsg}
 	}
 
-	// Execute replay asynchronously
-	go executeReplay(replayID, replayReq.TargetURL, replayMethod, transformedHeaders, finalBody)
-
-	response := models.CreateReplayResponse{
-		ReplayID: replayID,
-		Status:   "pending",
+	maxRetries := p.Options.MaxRetries
+	if maxRetries < 1 {
+		maxRetries = 1
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		outcome = executeReplayAttempt(ctx, client, p, attempt, attempt == maxRetries)
+		if outcome.Status == "success" {
+			break
+		}
+		if attempt < maxRetries {
+			time.Sleep(calculateBackoff(attempt, p.Options.BackoffConfig))
+		}
+	}
+	return outcome
 }
 
-// executeReplay performs the actual HTTP request and updates the replay record
-func executeReplay(replayID uuid.UUID, targetURL, method string, headers map[string]interface{}, body string) {
-	ctx := context.Background()
+// executeReplayAttempt performs a single HTTP request, records it and updates
+// the replay record. Failed attempts that will be retried leave the replay
+// in the retrying state.
+func executeReplayAttempt(ctx context.Context, client *http.Client, p *preparedReplay, attemptNumber int, final bool) replayOutcome {
+	ctx, span := tracing.Start(ctx, "replay attempt",
+		attribute.String("flowhook.replay.id", p.ReplayID.String()),
+		attribute.Int("flowhook.attempt", attemptNumber),
+		attribute.String("http.request.method", p.Method),
+	)
+	startTime := time.Now()
+
+	finish := func(status string, responseStatus int, respHeaders []byte, respBody, transformedBody *string, errMsg *string) replayOutcome {
+		span.SetAttributes(attribute.String("flowhook.outcome", status))
+		if responseStatus != 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", responseStatus))
+		}
+		if status != "success" {
+			description := status
+			if errMsg != nil {
+				description = *errMsg
+			}
+			span.SetStatus(codes.Error, description)
+		}
+		span.End()
+
+		duration := int(time.Since(startTime).Milliseconds())
+		recordReplayAttempt(ctx, p.ReplayID, attemptNumber, status, responseStatus, respHeaders, respBody, transformedBody, errMsg, &duration)
+
+		replayStatus := status
+		if status == "failed" && !final {
+			replayStatus = "retrying"
+		}
+		updateReplayStatus(ctx, p.ReplayID, replayStatus, responseStatus, respHeaders, respBody, transformedBody, errMsg)
+
+		outcome := replayOutcome{Status: status, ResponseStatus: responseStatus}
+		if errMsg != nil {
+			outcome.Error = *errMsg
+		}
+		return outcome
+	}
 
 	// Create HTTP request
 	var bodyReader io.Reader
-	if body != "" {
-		bodyReader = bytes.NewReader([]byte(body))
+	if p.Body != "" {
+		bodyReader = bytes.NewReader([]byte(p.Body))
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, targetURL, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, p.Method, p.TargetURL, bodyReader)
 	if err != nil {
 		errMsg := err.Error()
-		updateReplayStatus(replayID, "failed", 0, nil, nil, &errMsg)
-		return
+		return finish("failed", 0, nil, nil, nil, &errMsg)
 	}
 
 	// Set headers
-	for key, value := range headers {
+	for key, value := range p.Headers {
 		// Handle array values (like Accept: [application/json])
 		if arr, ok := value.([]interface{}); ok {
 			for _, v := range arr {
@@ -210,17 +427,12 @@ func executeReplay(replayID uuid.UUID, targetURL, method string, headers map[str
 			req.Header.Set(key, fmt.Sprintf("%v", value))
 		}
 	}
-
-	// Execute request
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
+	tracing.Inject(ctx, req.Header)
 
 	resp, err := client.Do(req)
 	if err != nil {
 		errMsg := err.Error()
-		updateReplayStatus(replayID, "failed", 0, nil, nil, &errMsg)
-		return
+		return finish("failed", 0, nil, nil, nil, &errMsg)
 	}
 	defer resp.Body.Close()
 
@@ -228,8 +440,7 @@ func executeReplay(replayID uuid.UUID, targetURL, method string, headers map[str
 	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024)) // Limit to 1MB
 	if err != nil {
 		errMsg := fmt.Sprintf("Failed to read response: %v", err)
-		updateReplayStatus(replayID, "failed", resp.StatusCode, nil, nil, &errMsg)
-		return
+		return finish("failed", resp.StatusCode, nil, nil, nil, &errMsg)
 	}
 
 	// Convert response headers to JSON
@@ -244,19 +455,16 @@ func executeReplay(replayID uuid.UUID, targetURL, method string, headers map[str
 	respHeadersJSON, _ := json.Marshal(respHeaders)
 
 	// Handle response body - check if it's valid UTF-8
-	var respBodyStr *string
-	if len(respBody) > 0 {
-		// Check if the body is valid UTF-8
-		if utf8.Valid(respBody) {
-			// Valid UTF-8, store as string
-			bodyStr := string(respBody)
-			respBodyStr = &bodyStr
-		} else {
-			// Binary data, encode as base64
-			encoded := base64.StdEncoding.EncodeToString(respBody)
-			bodyStr := fmt.Sprintf("[BINARY DATA - Base64 Encoded]\n%s", encoded)
-			respBodyStr = &bodyStr
-		}
+	respBodyStr := encodeResponseBody(respBody)
+
+	// Apply response transformations, keeping the raw body alongside
+	var transformedBodyStr *string
+	transformedBody, applied, err := transform.TransformResponseBody(ctx, p.EndpointID, respBody)
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to apply response transformations during replay", "replay_id", p.ReplayID, "attempt", attemptNumber, "error", err)
+	}
+	if applied {
+		transformedBodyStr = encodeResponseBody(transformedBody)
 	}
 
 	status := "success"
@@ -264,17 +472,42 @@ func executeReplay(replayID uuid.UUID, targetURL, method string, headers map[str
 		status = "failed"
 	}
 
-	updateReplayStatus(replayID, status, resp.StatusCode, respHeadersJSON, respBodyStr, nil)
+	return finish(status, resp.StatusCode, respHeadersJSON, respBodyStr, transformedBodyStr, nil)
+}
+
+// recordReplayAttempt records a replay attempt in the database
+func recordReplayAttempt(ctx context.Context, replayID uuid.UUID, attemptNumber int, status string, responseStatus int, responseHeaders []byte, responseBody, transformedResponseBody *string, errorMsg *string, durationMs *int) {
+	ctx = context.WithoutCancel(ctx)
+
+	_, err := db.Pool.Exec(
+		ctx,
+		`INSERT INTO replay_attempts (replay_id, attempt_number, status, response_status, response_headers, response_body, transformed_response_body, error_message, duration_ms)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		replayID,
+		attemptNumber,
+		status,
+		responseStatus,
+		responseHeaders,
+		responseBody,
+		transformedResponseBody,
+		errorMsg,
+		durationMs,
+	)
+
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to record replay attempt", "replay_id", replayID, "attempt", attemptNumber, "status", status, "error", err)
+	}
 }
 
 // updateReplayStatus updates the replay record with the result
-func updateReplayStatus(replayID uuid.UUID, status string, responseStatus int, responseHeaders []byte, responseBody *string, errorMsg *string) {
-	ctx := context.Background()
+func updateReplayStatus(ctx context.Context, replayID uuid.UUID, status string, responseStatus int, responseHeaders []byte, responseBody, transformedResponseBody *string, errorMsg *string) {
+	ctx = context.WithoutCancel(ctx)
 
 	query := `UPDATE replays 
 			  SET status = $1, attempts = attempts + 1, last_attempt_at = now(),
-			      response_status = $2, response_headers = $3, response_body = $4, error_message = $5
-			  WHERE id = $6`
+			      response_status = $2, response_headers = $3, response_body = $4,
+			      transformed_response_body = $5, error_message = $6
+			  WHERE id = $7`
 
 	_, err := db.Pool.Exec(
 		ctx,
@@ -283,13 +516,14 @@ func updateReplayStatus(replayID uuid.UUID, status string, responseStatus int, r
 		responseStatus,
 		responseHeaders,
 		responseBody,
+		transformedResponseBody,
 		errorMsg,
 		replayID,
 	)
 
 	if err != nil {
 		// Log error but don't fail - this is async
-		fmt.Printf("Failed to update replay status: %v\n", err)
+		logger.ErrorContext(ctx, "Failed to update replay status", "replay_id", replayID, "status", status, "error", err)
 	}
 }
 
@@ -309,14 +543,18 @@ func GetReplays(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cursor, limit, err := parsePageParams(r, 100, 500)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Fetch replays for this request
-	rows, err := db.Pool.Query(
-		r.Context(),
-		`SELECT id, request_id, target_url, method, headers, body, attempts, status,
-		        response_status, response_headers, response_body, error_message, last_attempt_at, created_at
-		 FROM replays WHERE request_id = $1 ORDER BY created_at DESC`,
-		requestID,
+	query, args := applyKeyset(
+		`SELECT `+replayColumns+` FROM replays WHERE request_id = $1`,
+		[]interface{}{requestID}, "created_at", cursor, limit,
 	)
+	rows, err := db.Pool.Query(r.Context(), query, args...)
 
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
@@ -326,40 +564,283 @@ func GetReplays(w http.ResponseWriter, r *http.Request) {
 
 	var replays []models.Replay
 	for rows.Next() {
-		var replay models.Replay
-		var headersJSON string
-		var responseHeadersJSON []byte
+		replay, err := scanReplay(rows)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to scan replay: %v", err), http.StatusInternalServerError)
+			return
+		}
+		replays = append(replays, replay)
+	}
+
+	replays, nextCursor, prevCursor := finishPage(replays, cursor, limit, func(replay models.Replay) (time.Time, uuid.UUID) {
+		return replay.CreatedAt, replay.ID
+	})
+	setCursorHeaders(w, nextCursor, prevCursor)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replays)
+}
 
+// GetReplay handles GET /api/v1/replays/:id
+func GetReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	replayID, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, "/api/v1/replays/"))
+	if err != nil {
+		http.Error(w, "Invalid replay ID", http.StatusBadRequest)
+		return
+	}
+
+	replay, err := scanReplay(db.Pool.QueryRow(r.Context(), `SELECT `+replayColumns+` FROM replays WHERE id = $1`, replayID))
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Replay not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replay)
+}
+
+// GetReplayAttempts handles GET /api/v1/replays/:id/attempts
+func GetReplayAttempts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	replayIDStr := strings.TrimPrefix(r.URL.Path, "/api/v1/replays/")
+	replayIDStr = strings.TrimSuffix(replayIDStr, "/attempts")
+	replayID, err := uuid.Parse(replayIDStr)
+	if err != nil {
+		http.Error(w, "Invalid replay ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Pool.Query(
+		r.Context(),
+		`SELECT id, replay_id, attempt_number, status, response_status, response_headers, response_body,
+		        transformed_response_body, error_message, duration_ms, attempted_at
+		 FROM replay_attempts WHERE replay_id = $1
+		 ORDER BY attempt_number ASC`,
+		replayID,
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	attempts := []models.ReplayAttempt{}
+	for rows.Next() {
+		var attempt models.ReplayAttempt
+		var responseHeadersJSON []byte
 		err := rows.Scan(
-			&replay.ID,
-			&replay.RequestID,
-			&replay.TargetURL,
-			&replay.Method,
-			&headersJSON,
-			&replay.Body,
-			&replay.Attempts,
-			&replay.Status,
-			&replay.ResponseStatus,
+			&attempt.ID,
+			&attempt.ReplayID,
+			&attempt.AttemptNumber,
+			&attempt.Status,
+			&attempt.ResponseStatus,
 			&responseHeadersJSON,
-			&replay.ResponseBody,
-			&replay.ErrorMessage,
-			&replay.LastAttemptAt,
-			&replay.CreatedAt,
+			&attempt.ResponseBody,
+			&attempt.TransformedResponseBody,
+			&attempt.ErrorMessage,
+			&attempt.DurationMs,
+			&attempt.AttemptedAt,
 		)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to scan replay: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to scan replay attempt: %v", err), http.StatusInternalServerError)
 			return
 		}
-
-		// Parse JSON fields
-		json.Unmarshal([]byte(headersJSON), &replay.Headers)
 		if len(responseHeadersJSON) > 0 {
-			json.Unmarshal(responseHeadersJSON, &replay.ResponseHeaders)
+			json.Unmarshal(responseHeadersJSON, &attempt.ResponseHeaders)
 		}
+		attempts = append(attempts, attempt)
+	}
 
-		replays = append(replays, replay)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
+
+// RerunReplay handles POST /api/v1/replays/:id/rerun. The new replay sends the
+// same method, headers and body as the original, which already had request
+// transformations applied; any field in the body overrides it.
+func RerunReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	replayIDStr := strings.TrimPrefix(r.URL.Path, "/api/v1/replays/")
+	replayIDStr = strings.TrimSuffix(replayIDStr, "/rerun")
+	replayID, err := uuid.Parse(replayIDStr)
+	if err != nil {
+		http.Error(w, "Invalid replay ID", http.StatusBadRequest)
+		return
+	}
+
+	// Every field is optional; an empty body re-runs with identical parameters
+	var overrides models.CreateReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	source, err := scanReplay(db.Pool.QueryRow(r.Context(), `SELECT `+replayColumns+` FROM replays WHERE id = $1`, replayID))
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Replay not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	opts, err := replayOptions{
+		MaxRetries:      source.MaxRetries,
+		BackoffConfig:   source.BackoffConfig,
+		TimeoutMs:       source.TimeoutMs,
+		FollowRedirects: source.FollowRedirects,
+		TransportRuleID: source.TransportRuleID,
+	}.withOverrides(overrides)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var endpointID uuid.UUID
+	err = db.Pool.QueryRow(r.Context(), `SELECT endpoint_id FROM requests WHERE id = $1`, source.RequestID).Scan(&endpointID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := checkTransportRule(r.Context(), overrides.TransportRuleID, endpointID); err != nil {
+		if err == errInvalidTransportRule {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	prepared := &preparedReplay{
+		ReplayID:   uuid.New(),
+		EndpointID: endpointID,
+		TargetURL:  source.TargetURL,
+		Method:     source.Method,
+		Headers:    source.Headers,
+		Body:       derefString(source.Body),
+		Options:    opts,
+	}
+	if overrides.TargetURL != "" {
+		prepared.TargetURL = overrides.TargetURL
+	}
+	if err := outbound.CheckURL(prepared.TargetURL); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid target_url: %v", err), http.StatusBadRequest)
+		return
+	}
+	if overrides.Method != nil && *overrides.Method != "" {
+		prepared.Method = *overrides.Method
+	}
+	if len(overrides.Headers) > 0 {
+		prepared.Headers = overrides.Headers
 	}
+	if overrides.Body != nil {
+		prepared.Body = *overrides.Body
+	}
+
+	if err := insertReplay(r.Context(), source.RequestID, &source.ID, prepared); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create replay: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	go executeReplay(context.WithoutCancel(r.Context()), prepared)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(replays)
+	json.NewEncoder(w).Encode(models.CreateReplayResponse{
+		ReplayID: prepared.ReplayID,
+		Status:   "pending",
+	})
+}
+
+const replayColumns = `id, request_id, target_url, method, headers, body, attempts, status,
+	response_status, response_headers, response_body, transformed_response_body, error_message,
+	max_retries, backoff_config, timeout_ms, follow_redirects, source_replay_id, transport_rule_id, last_attempt_at, created_at,
+	encrypted_payload`
+
+// scanReplay scans a row selected with replayColumns, decrypting the headers
+// and body of an encrypted replay
+func scanReplay(scanner interface {
+	Scan(dest ...interface{}) error
+}) (models.Replay, error) {
+	var replay models.Replay
+	var headersJSON string
+	var encryptedPayload *string
+	var responseHeadersJSON, backoffJSON []byte
+	var maxRetries, timeoutMs *int
+	var followRedirects *bool
+
+	err := scanner.Scan(
+		&replay.ID,
+		&replay.RequestID,
+		&replay.TargetURL,
+		&replay.Method,
+		&headersJSON,
+		&replay.Body,
+		&replay.Attempts,
+		&replay.Status,
+		&replay.ResponseStatus,
+		&responseHeadersJSON,
+		&replay.ResponseBody,
+		&replay.TransformedResponseBody,
+		&replay.ErrorMessage,
+		&maxRetries,
+		&backoffJSON,
+		&timeoutMs,
+		&followRedirects,
+		&replay.SourceReplayID,
+		&replay.TransportRuleID,
+		&replay.LastAttemptAt,
+		&replay.CreatedAt,
+		&encryptedPayload,
+	)
+	if err != nil {
+		return replay, err
+	}
+	if encryptedPayload != nil {
+		headersJSON, replay.Body, err = openRequestPayload(*encryptedPayload)
+		if err != nil {
+			return replay, err
+		}
+	}
+
+	// Parse JSON fields
+	json.Unmarshal([]byte(headersJSON), &replay.Headers)
+	if len(responseHeadersJSON) > 0 {
+		json.Unmarshal(responseHeadersJSON, &replay.ResponseHeaders)
+	}
+	if len(backoffJSON) > 0 {
+		json.Unmarshal(backoffJSON, &replay.BackoffConfig)
+	}
+
+	// Columns added by migration 013 are NULL only if written outside the API
+	replay.MaxRetries = defaultReplayOptions.MaxRetries
+	if maxRetries != nil {
+		replay.MaxRetries = *maxRetries
+	}
+	replay.TimeoutMs = defaultReplayOptions.TimeoutMs
+	if timeoutMs != nil {
+		replay.TimeoutMs = *timeoutMs
+	}
+	replay.FollowRedirects = defaultReplayOptions.FollowRedirects
+	if followRedirects != nil {
+		replay.FollowRedirects = *followRedirects
+	}
+	return replay, nil
 }
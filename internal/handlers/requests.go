@@ -2,13 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"flowhook/internal/db"
 	"flowhook/internal/models"
+	"flowhook/internal/search"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -47,168 +50,240 @@ func GetRequests(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse query parameters
-	limit := 25
-	offset := 0
-	methodFilter := ""
-	searchQuery := ""
-	dateFrom := ""
-	dateTo := ""
-
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
-			limit = l
-		}
+	cursor, limit, err := parsePageParams(r, 25, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+	// Offset paging is kept for older clients; cursors take precedence
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" && cursor == nil {
 		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
 			offset = o
 		}
 	}
 
-	if methodStr := r.URL.Query().Get("method"); methodStr != "" {
-		methodFilter = methodStr
+	// total=approximate (planner estimate, default), exact or none. An exact
+	// COUNT scans every matching row, so it is only run when asked for.
+	totalMode := r.URL.Query().Get("total")
+	if totalMode == "" {
+		totalMode = "approximate"
 	}
-
-	if searchStr := r.URL.Query().Get("search"); searchStr != "" {
-		searchQuery = strings.ToLower(searchStr)
+	if totalMode != "exact" && totalMode != "approximate" && totalMode != "none" {
+		http.Error(w, "total must be exact, approximate or none", http.StatusBadRequest)
+		return
 	}
 
-	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
-		dateFrom = fromStr
+	encrypted, err := endpointEncryptsPayloads(r.Context(), endpointID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	filter := requestFilterFromQuery(r)
+	where, args, err := filter.whereClause(endpointID, encrypted)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter: %v", err), http.StatusBadRequest)
+		return
 	}
 
-	if toStr := r.URL.Query().Get("to"); toStr != "" {
-		dateTo = toStr
+	// Get total count
+	var total *int
+	switch totalMode {
+	case "exact":
+		var count int
+		err = db.Pool.QueryRow(r.Context(), `SELECT COUNT(*) FROM requests WHERE `+where, args...).Scan(&count)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to count requests: %v", err), http.StatusInternalServerError)
+			return
+		}
+		total = &count
+	case "approximate":
+		count, err := estimateRowCount(r.Context(), `SELECT 1 FROM requests WHERE `+where, args...)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to estimate requests: %v", err), http.StatusInternalServerError)
+			return
+		}
+		total = &count
 	}
 
-	// Build query
-	query := `SELECT id, endpoint_id, method, path, headers, query_params, ip, body, body_size, content_type, received_at
+	query := `SELECT ` + requestColumns + `
 			  FROM requests
-			  WHERE endpoint_id = $1`
-	args := []interface{}{endpointID}
-	argIndex := 2
-
-	if methodFilter != "" {
-		query += fmt.Sprintf(" AND method = $%d", argIndex)
-		args = append(args, methodFilter)
-		argIndex++
+			  WHERE ` + where
+	query, args = applyKeyset(query, args, "received_at", cursor, limit)
+	if offset > 0 {
+		args = append(args, offset)
+		query += " OFFSET $" + strconv.Itoa(len(args))
 	}
 
-	if dateFrom != "" {
-		query += fmt.Sprintf(" AND received_at >= $%d", argIndex)
-		args = append(args, dateFrom)
-		argIndex++
+	// Execute query
+	rows, err := db.Pool.Query(r.Context(), query, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to fetch requests: %v", err), http.StatusInternalServerError)
+		return
 	}
+	defer rows.Close()
 
-	if dateTo != "" {
-		query += fmt.Sprintf(" AND received_at <= $%d", argIndex)
-		args = append(args, dateTo)
-		argIndex++
+	var requests []models.Request
+	for rows.Next() {
+		req, err := scanRequest(rows)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to scan request: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		requests = append(requests, req)
 	}
 
-	if searchQuery != "" {
-		// Search in headers and path (case-insensitive) - using ILIKE for better performance
-		query += fmt.Sprintf(" AND (path ILIKE $%d OR headers::text ILIKE $%d)", argIndex, argIndex)
-		searchPattern := "%" + searchQuery + "%"
-		args = append(args, searchPattern)
-		argIndex++
+	requests, nextCursor, prevCursor := finishPage(requests, cursor, limit, func(req models.Request) (time.Time, uuid.UUID) {
+		return req.ReceivedAt, req.ID
+	})
+
+	response := models.RequestListResponse{
+		Requests:         requests,
+		Total:            total,
+		TotalApproximate: totalMode == "approximate",
+		Limit:            limit,
+		Offset:           offset,
+		NextCursor:       nextCursor,
+		PrevCursor:       prevCursor,
 	}
 
-	query += " ORDER BY received_at DESC LIMIT $" + strconv.Itoa(argIndex) + " OFFSET $" + strconv.Itoa(argIndex+1)
-	args = append(args, limit, offset)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	// Get total count
-	var total int
-	countQuery := `SELECT COUNT(*) FROM requests WHERE endpoint_id = $1`
-	countArgs := []interface{}{endpointID}
-	countArgIndex := 2
+// requestColumns is the column list read by scanRequest
+const requestColumns = `id, endpoint_id, method, path, subpath, headers, query_params, ip, body, body_size, content_type, received_at, encrypted_payload, correlation_id`
 
-	if methodFilter != "" {
-		countQuery += fmt.Sprintf(" AND method = $%d", countArgIndex)
-		countArgs = append(countArgs, methodFilter)
-		countArgIndex++
-	}
+func scanRequest(scanner interface {
+	Scan(dest ...interface{}) error
+}) (models.Request, error) {
+	var req models.Request
+	var headersJSON, queryParamsJSON string
+	var encryptedPayload *string
 
-	if dateFrom != "" {
-		countQuery += fmt.Sprintf(" AND received_at >= $%d", countArgIndex)
-		countArgs = append(countArgs, dateFrom)
-		countArgIndex++
+	err := scanner.Scan(
+		&req.ID,
+		&req.EndpointID,
+		&req.Method,
+		&req.Path,
+		&req.Subpath,
+		&headersJSON,
+		&queryParamsJSON,
+		&req.IP,
+		&req.Body,
+		&req.BodySize,
+		&req.ContentType,
+		&req.ReceivedAt,
+		&encryptedPayload,
+		&req.CorrelationID,
+	)
+	if err != nil {
+		return req, err
 	}
-
-	if dateTo != "" {
-		countQuery += fmt.Sprintf(" AND received_at <= $%d", countArgIndex)
-		countArgs = append(countArgs, dateTo)
-		countArgIndex++
+	if encryptedPayload != nil {
+		headersJSON, req.Body, err = openRequestPayload(*encryptedPayload)
+		if err != nil {
+			return req, err
+		}
 	}
 
-	if searchQuery != "" {
-		// Use ILIKE for case-insensitive search (better performance than LOWER)
-		countQuery += fmt.Sprintf(" AND (path ILIKE $%d OR headers::text ILIKE $%d)", countArgIndex, countArgIndex)
-		searchPattern := "%" + searchQuery + "%"
-		countArgs = append(countArgs, searchPattern)
-		countArgIndex++
-	}
+	// Parse JSON fields
+	json.Unmarshal([]byte(headersJSON), &req.Headers)
+	json.Unmarshal([]byte(queryParamsJSON), &req.QueryParams)
+	return req, nil
+}
 
-	err = db.Pool.QueryRow(r.Context(), countQuery, countArgs...).Scan(&total)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to count requests: %v", err), http.StatusInternalServerError)
-		return
+// errEncryptedSearch rejects filters that match headers or bodies of an
+// endpoint that encrypts payloads
+var errEncryptedSearch = errors.New("search, full-text and header or body terms are not supported on endpoints that encrypt payloads")
+
+// requestFilter selects captured requests for listing and export
+type requestFilter struct {
+	Method string `json:"method,omitempty"`
+	Search string `json:"search,omitempty"` // Legacy substring search over path and headers
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Query  string `json:"q,omitempty"` // Search expression, see search.Parse
+	// ForwardFailed selects requests with failed forward attempts and no successful one,
+	// optionally for a single rule
+	ForwardFailed bool   `json:"forward_failed,omitempty"`
+	ForwardRuleID string `json:"forward_rule_id,omitempty"`
+}
+
+func requestFilterFromQuery(r *http.Request) requestFilter {
+	return requestFilter{
+		Method: r.URL.Query().Get("method"),
+		Search: r.URL.Query().Get("search"),
+		From:   r.URL.Query().Get("from"),
+		To:     r.URL.Query().Get("to"),
+		Query:  r.URL.Query().Get("q"),
+
+		ForwardFailed: r.URL.Query().Get("forward_failed") == "true",
+		ForwardRuleID: r.URL.Query().Get("forward_rule_id"),
 	}
+}
 
-	// Execute query
-	rows, err := db.Pool.Query(r.Context(), query, args...)
+// whereClause builds the WHERE clause over the requests table for an endpoint.
+// Headers and bodies of an endpoint that encrypts payloads are only stored
+// encrypted, so searching them is refused rather than matching nothing. An
+// error means the search expression or rule ID is invalid.
+func (f requestFilter) whereClause(endpointID uuid.UUID, encrypted bool) (string, []interface{}, error) {
+	// Parse structured search (q=method:POST header.x-github-event:push ...)
+	searchExpr, err := search.Parse(f.Query)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to fetch requests: %v", err), http.StatusInternalServerError)
-		return
+		return "", nil, err
+	}
+	if encrypted && (f.Search != "" || searchExpr.SearchesPayload()) {
+		return "", nil, errEncryptedSearch
 	}
-	defer rows.Close()
 
-	var requests []models.Request
-	for rows.Next() {
-		var req models.Request
-		var headersJSON, queryParamsJSON string
-		var path, ip, bodyStr, contentType *string
-
-		err := rows.Scan(
-			&req.ID,
-			&req.EndpointID,
-			&req.Method,
-			&path,
-			&headersJSON,
-			&queryParamsJSON,
-			&ip,
-			&bodyStr,
-			&req.BodySize,
-			&contentType,
-			&req.ReceivedAt,
-		)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to scan request: %v", err), http.StatusInternalServerError)
-			return
-		}
+	where := "endpoint_id = $1"
+	args := []interface{}{endpointID}
+	argIndex := 2
 
-		req.Path = path
-		req.IP = ip
-		req.Body = bodyStr
-		req.ContentType = contentType
+	if f.Method != "" {
+		where += fmt.Sprintf(" AND method = $%d", argIndex)
+		args = append(args, f.Method)
+		argIndex++
+	}
 
-		// Parse JSON fields
-		json.Unmarshal([]byte(headersJSON), &req.Headers)
-		json.Unmarshal([]byte(queryParamsJSON), &req.QueryParams)
+	if f.From != "" {
+		where += fmt.Sprintf(" AND received_at >= $%d", argIndex)
+		args = append(args, f.From)
+		argIndex++
+	}
 
-		requests = append(requests, req)
+	if f.To != "" {
+		where += fmt.Sprintf(" AND received_at <= $%d", argIndex)
+		args = append(args, f.To)
+		argIndex++
 	}
 
-	response := models.RequestListResponse{
-		Requests: requests,
-		Total:    total,
-		Limit:    limit,
-		Offset:   offset,
+	if f.Search != "" {
+		// Search in headers and path (case-insensitive) - using ILIKE for better performance
+		where += fmt.Sprintf(" AND (path ILIKE $%d OR headers::text ILIKE $%d)", argIndex, argIndex)
+		args = append(args, "%"+strings.ToLower(f.Search)+"%")
+		argIndex++
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	if f.ForwardFailed {
+		ruleCond := ""
+		if f.ForwardRuleID != "" {
+			ruleID, err := uuid.Parse(f.ForwardRuleID)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid forward_rule_id")
+			}
+			ruleCond = fmt.Sprintf(" AND fa.forwarding_rule_id = $%d", argIndex)
+			args = append(args, ruleID)
+		}
+		where += ` AND EXISTS (SELECT 1 FROM forward_attempts fa WHERE fa.request_id = requests.id AND fa.status = 'failed'` + ruleCond + `)` +
+			` AND NOT EXISTS (SELECT 1 FROM forward_attempts fa WHERE fa.request_id = requests.id AND fa.status = 'success'` + ruleCond + `)`
+	}
+
+	where, args = searchExpr.AppendSQL(where, args)
+	return where, args, nil
 }
 
 // GetRequestDetail handles GET /api/v1/requests/:id
@@ -229,11 +304,11 @@ func GetRequestDetail(w http.ResponseWriter, r *http.Request) {
 	// Fetch request from database
 	var req models.Request
 	var headersJSON, queryParamsJSON string
-	var path, ip, bodyStr, contentType *string
+	var path, ip, bodyStr, contentType, encryptedPayload *string
 
 	err = db.Pool.QueryRow(
 		r.Context(),
-		`SELECT id, endpoint_id, method, path, headers, query_params, ip, body, body_size, content_type, received_at
+		`SELECT id, endpoint_id, method, path, subpath, headers, query_params, ip, body, body_size, content_type, received_at, encrypted_payload, correlation_id
 		 FROM requests WHERE id = $1`,
 		requestID,
 	).Scan(
@@ -241,6 +316,7 @@ func GetRequestDetail(w http.ResponseWriter, r *http.Request) {
 		&req.EndpointID,
 		&req.Method,
 		&path,
+		&req.Subpath,
 		&headersJSON,
 		&queryParamsJSON,
 		&ip,
@@ -248,6 +324,8 @@ func GetRequestDetail(w http.ResponseWriter, r *http.Request) {
 		&req.BodySize,
 		&contentType,
 		&req.ReceivedAt,
+		&encryptedPayload,
+		&req.CorrelationID,
 	)
 
 	if err == pgx.ErrNoRows {
@@ -258,6 +336,13 @@ func GetRequestDetail(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if encryptedPayload != nil {
+		headersJSON, bodyStr, err = openRequestPayload(*encryptedPayload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	req.Path = path
 	req.IP = ip
@@ -8,9 +8,11 @@ import (
 	"strings"
 	"time"
 
+	"flowhook/internal/config"
 	"flowhook/internal/db"
 	"flowhook/internal/logger"
 	"flowhook/internal/models"
+	"flowhook/internal/storage"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -42,33 +44,23 @@ func GetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var policy models.RetentionPolicy
+	policy, err := getRetentionPolicy(r.Context(), endpointID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var usage models.RetentionUsage
 	err = db.Pool.QueryRow(
 		r.Context(),
-		`SELECT id, endpoint_id, retention_days, auto_delete, archive_enabled, archive_path, created_at, updated_at
-		 FROM retention_policies WHERE endpoint_id = $1`,
+		`SELECT COUNT(*), COALESCE(SUM(body_size), 0), MIN(received_at) FROM requests WHERE endpoint_id = $1`,
 		endpointID,
-	).Scan(
-		&policy.ID,
-		&policy.EndpointID,
-		&policy.RetentionDays,
-		&policy.AutoDelete,
-		&policy.ArchiveEnabled,
-		&policy.ArchivePath,
-		&policy.CreatedAt,
-		&policy.UpdatedAt,
-	)
-
-	if err == pgx.ErrNoRows {
-		// Return default policy
-		policy.EndpointID = endpointID
-		policy.RetentionDays = 30
-		policy.AutoDelete = false
-		policy.ArchiveEnabled = false
-	} else if err != nil {
+	).Scan(&usage.RequestCount, &usage.TotalBytes, &usage.OldestReceivedAt)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
 	}
+	policy.Usage = &usage
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(policy)
@@ -122,22 +114,43 @@ func UpdateRetentionPolicy(w http.ResponseWriter, r *http.Request) {
 		archiveEnabled = *req.ArchiveEnabled
 	}
 
+	keepForever := false
+	if req.KeepForever != nil {
+		keepForever = *req.KeepForever
+	}
+
+	if retentionDays < 0 || (req.MaxRequests != nil && *req.MaxRequests < 0) || (req.MaxBytes != nil && *req.MaxBytes < 0) {
+		http.Error(w, "retention_days, max_requests and max_bytes must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if req.ArchivePath != nil && !storage.ValidArchiveDir(*req.ArchivePath) {
+		http.Error(w, "archive_path must be a relative path within the archives directory", http.StatusBadRequest)
+		return
+	}
+
 	_, err = db.Pool.Exec(
 		r.Context(),
-		`INSERT INTO retention_policies (endpoint_id, retention_days, auto_delete, archive_enabled, archive_path, updated_at)
-		 VALUES ($1, $2, $3, $4, $5, now())
+		`INSERT INTO retention_policies (endpoint_id, retention_days, auto_delete, archive_enabled, archive_path, max_requests, max_bytes, keep_forever, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NULLIF($7::bigint, 0), $8, now())
 		 ON CONFLICT (endpoint_id) 
 		 DO UPDATE SET 
 		   retention_days = $2,
 		   auto_delete = $3,
 		   archive_enabled = $4,
 		   archive_path = COALESCE($5, retention_policies.archive_path),
+		   max_requests = NULLIF($6, 0),
+		   max_bytes = NULLIF($7::bigint, 0),
+		   keep_forever = $8,
 		   updated_at = now()`,
 		endpointID,
 		retentionDays,
 		autoDelete,
 		archiveEnabled,
 		req.ArchivePath,
+		req.MaxRequests,
+		req.MaxBytes,
+		keepForever,
 	)
 
 	if err != nil {
@@ -148,51 +161,218 @@ func UpdateRetentionPolicy(w http.ResponseWriter, r *http.Request) {
 	GetRetentionPolicy(w, r)
 }
 
-// CleanupOldRequests runs cleanup based on retention policies
+// getRetentionPolicy returns the retention policy of an endpoint, or the
+// global default policy when it has none
+func getRetentionPolicy(ctx context.Context, endpointID uuid.UUID) (models.RetentionPolicy, error) {
+	var policy models.RetentionPolicy
+	err := db.Pool.QueryRow(
+		ctx,
+		`SELECT id, endpoint_id, COALESCE(retention_days, 0), auto_delete, archive_enabled, archive_path,
+		        max_requests, max_bytes, COALESCE(keep_forever, false), created_at, updated_at
+		 FROM retention_policies WHERE endpoint_id = $1`,
+		endpointID,
+	).Scan(
+		&policy.ID,
+		&policy.EndpointID,
+		&policy.RetentionDays,
+		&policy.AutoDelete,
+		&policy.ArchiveEnabled,
+		&policy.ArchivePath,
+		&policy.MaxRequests,
+		&policy.MaxBytes,
+		&policy.KeepForever,
+		&policy.CreatedAt,
+		&policy.UpdatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return defaultRetentionPolicy(endpointID), nil
+	}
+	return policy, err
+}
+
+// defaultRetentionPolicy is the policy of endpoints without their own, set by
+// the RETENTION_DEFAULT_* settings. Without any, requests are kept forever.
+func defaultRetentionPolicy(endpointID uuid.UUID) models.RetentionPolicy {
+	policy := models.RetentionPolicy{EndpointID: endpointID, IsDefault: true}
+	if config.AppConfig == nil {
+		return policy
+	}
+
+	policy.RetentionDays = config.AppConfig.RetentionDefaultDays
+	if n := config.AppConfig.RetentionDefaultMaxRequests; n > 0 {
+		policy.MaxRequests = &n
+	}
+	if n := config.AppConfig.RetentionDefaultMaxBytes; n > 0 {
+		policy.MaxBytes = &n
+	}
+	policy.AutoDelete = policy.RetentionDays > 0 || policy.MaxRequests != nil || policy.MaxBytes != nil
+	policy.ArchiveEnabled = config.AppConfig.RetentionDefaultArchive
+	return policy
+}
+
+// retentionCutoff returns the time before which requests of an endpoint are
+// expired under policy: the latest of the cutoffs of its age, count and size
+// limits. It returns false when no limit is exceeded.
+func retentionCutoff(ctx context.Context, policy models.RetentionPolicy) (time.Time, bool, error) {
+	var cutoff time.Time
+	found := false
+	later := func(t time.Time) {
+		if !found || t.After(cutoff) {
+			cutoff = t
+			found = true
+		}
+	}
+
+	if policy.RetentionDays > 0 {
+		later(time.Now().Add(-time.Duration(policy.RetentionDays) * 24 * time.Hour))
+	}
+
+	// Keep the newest max_requests requests
+	if policy.MaxRequests != nil && *policy.MaxRequests > 0 {
+		var receivedAt time.Time
+		err := db.Pool.QueryRow(
+			ctx,
+			`SELECT received_at FROM requests WHERE endpoint_id = $1 ORDER BY received_at DESC OFFSET $2 LIMIT 1`,
+			policy.EndpointID,
+			*policy.MaxRequests-1,
+		).Scan(&receivedAt)
+		if err == nil {
+			later(receivedAt)
+		} else if err != pgx.ErrNoRows {
+			return cutoff, false, err
+		}
+	}
+
+	// Keep the newest requests whose bodies fit in max_bytes
+	if policy.MaxBytes != nil && *policy.MaxBytes > 0 {
+		var oldestKept *time.Time
+		var exceeded *bool
+		err := db.Pool.QueryRow(
+			ctx,
+			`SELECT MIN(received_at) FILTER (WHERE total <= $2), bool_or(total > $2)
+			 FROM (
+			   SELECT received_at, SUM(COALESCE(body_size, 0)) OVER (ORDER BY received_at DESC, id DESC) AS total
+			   FROM requests WHERE endpoint_id = $1
+			 ) newest`,
+			policy.EndpointID,
+			*policy.MaxBytes,
+		).Scan(&oldestKept, &exceeded)
+		if err != nil {
+			return cutoff, false, err
+		}
+		if exceeded != nil && *exceeded {
+			if oldestKept != nil {
+				later(*oldestKept)
+			} else {
+				// Even the newest body is over the limit
+				later(time.Now())
+			}
+		}
+	}
+
+	return cutoff, found, nil
+}
+
+// CleanupOldRequests runs cleanup based on retention policies, applying the
+// default policy to endpoints without one. Partitions are created ahead first. Expired requests of endpoints with
+// archiving enabled are archived before deletion.
 func CleanupOldRequests(ctx context.Context) error {
-	// Get all retention policies
+	if err := ensurePartitions(ctx); err != nil {
+		return err
+	}
+
 	rows, err := db.Pool.Query(
 		ctx,
-		`SELECT endpoint_id, retention_days, auto_delete 
-		 FROM retention_policies WHERE auto_delete = true`,
+		`SELECT e.id, rp.id IS NULL, COALESCE(rp.retention_days, 0), COALESCE(rp.auto_delete, false),
+		        COALESCE(rp.keep_forever, false), rp.max_requests, rp.max_bytes,
+		        COALESCE(rp.archive_enabled, false), rp.archive_path
+		 FROM endpoints e
+		 LEFT JOIN retention_policies rp ON rp.endpoint_id = e.id`,
 	)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
 
+	var policies []models.RetentionPolicy
 	for rows.Next() {
-		var endpointID uuid.UUID
-		var retentionDays int
-		var autoDelete bool
+		var policy models.RetentionPolicy
+		err := rows.Scan(
+			&policy.EndpointID,
+			&policy.IsDefault,
+			&policy.RetentionDays,
+			&policy.AutoDelete,
+			&policy.KeepForever,
+			&policy.MaxRequests,
+			&policy.MaxBytes,
+			&policy.ArchiveEnabled,
+			&policy.ArchivePath,
+		)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if policy.IsDefault {
+			policy = defaultRetentionPolicy(policy.EndpointID)
+		}
+		if policy.AutoDelete && !policy.KeepForever {
+			policies = append(policies, policy)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-		if err := rows.Scan(&endpointID, &retentionDays, &autoDelete); err != nil {
+	policyByEndpoint := make(map[uuid.UUID]models.RetentionPolicy, len(policies))
+	cutoffs := make(map[uuid.UUID]time.Time, len(policies))
+	for _, policy := range policies {
+		cutoffDate, expired, err := retentionCutoff(ctx, policy)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to apply retention limits", "endpoint_id", policy.EndpointID, "error", err)
 			continue
 		}
+		policyByEndpoint[policy.EndpointID] = policy
+		if expired {
+			cutoffs[policy.EndpointID] = cutoffDate
+		}
+	}
+
+	// Whole months expired for every endpoint are dropped rather than deleted
+	// row by row
+	if err := dropExpiredPartitions(ctx, policyByEndpoint, cutoffs); err != nil {
+		logger.ErrorContext(ctx, "Failed to drop expired partitions", "error", err)
+	}
+
+	for _, policy := range policies {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-		if !autoDelete {
+		cutoffDate, expired := cutoffs[policy.EndpointID]
+		if !expired {
 			continue
 		}
 
-		cutoffDate := time.Now().Add(-time.Duration(retentionDays) * 24 * time.Hour)
+		var err error
 
-		// Delete old requests
-		result, err := db.Pool.Exec(
-			ctx,
-			`DELETE FROM requests WHERE endpoint_id = $1 AND received_at < $2`,
-			endpointID,
-			cutoffDate,
-		)
-		if err != nil {
-			logger.Error("Failed to cleanup requests for endpoint %s: %v", endpointID, err)
-		} else {
-			rowsAffected := result.RowsAffected()
-			if rowsAffected > 0 {
-				logger.Info("Cleaned up %d old requests for endpoint %s", rowsAffected, endpointID)
+		var deleted int64
+		if policy.ArchiveEnabled {
+			archiveDir := ""
+			if policy.ArchivePath != nil {
+				archiveDir = *policy.ArchivePath
 			}
+			deleted, err = archiveExpiredRequests(ctx, policy.EndpointID, archiveDir, cutoffDate)
+		} else {
+			deleted, err = deleteExpiredRequests(ctx, policy.EndpointID, cutoffDate)
+		}
+
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to clean up requests", "endpoint_id", policy.EndpointID, "error", err)
+		}
+		if deleted > 0 {
+			logger.InfoContext(ctx, "Cleaned up old requests", "endpoint_id", policy.EndpointID, "count", deleted)
 		}
 	}
 
 	return nil
 }
-
@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"flowhook/internal/config"
+	"flowhook/internal/db"
+	"flowhook/internal/logger"
+	"flowhook/internal/models"
+	"flowhook/internal/storage"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// retentionBatchSize is how many requests are archived or deleted per
+// statement, keeping each delete short
+const retentionBatchSize = 500
+
+// expiredRequests matches the requests of endpoint $1 older than cutoff $2.
+// Restored requests expire a full retention period after their restore.
+const expiredRequests = `endpoint_id = $1 AND received_at < $2 AND (restored_at IS NULL OR restored_at < $2)`
+
+// StartRetentionWorker runs CleanupOldRequests every CLEANUP_INTERVAL minutes
+// until ctx is done. Each run takes the retention advisory lock, so with
+// several replicas only one of them cleans up at a time.
+func StartRetentionWorker(ctx context.Context) {
+	interval := 60 * time.Minute
+	if config.AppConfig != nil && config.AppConfig.CleanupInterval > 0 {
+		interval = time.Duration(config.AppConfig.CleanupInterval) * time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			runCtx := logger.WithJob(ctx, "retention")
+			ran, err := db.WithAdvisoryLock(runCtx, db.LockRetention, CleanupOldRequests)
+			if err != nil {
+				logger.ErrorContext(runCtx, "Retention cleanup failed", "error", err)
+			} else if !ran {
+				logger.DebugContext(runCtx, "Retention cleanup skipped, another replica holds the lock")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// deleteExpiredRequests deletes the expired requests of an endpoint in
+// batches and returns how many were deleted
+func deleteExpiredRequests(ctx context.Context, endpointID uuid.UUID, cutoff time.Time) (int64, error) {
+	var deleted int64
+	for {
+		result, err := db.Pool.Exec(
+			ctx,
+			`DELETE FROM requests WHERE id IN (
+			   SELECT id FROM requests WHERE `+expiredRequests+` LIMIT $3
+			 )`,
+			endpointID,
+			cutoff,
+			retentionBatchSize,
+		)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.RowsAffected()
+		if result.RowsAffected() < retentionBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// archivedRequest is one line of a request archive. Columns are kept as
+// stored, so encrypted payloads stay encrypted in the archive.
+type archivedRequest struct {
+	ID               uuid.UUID       `json:"id"`
+	EndpointID       uuid.UUID       `json:"endpoint_id"`
+	Method           string          `json:"method"`
+	Path             *string         `json:"path,omitempty"`
+	Subpath          *string         `json:"subpath,omitempty"`
+	Headers          json.RawMessage `json:"headers,omitempty"`
+	QueryParams      json.RawMessage `json:"query_params,omitempty"`
+	IP               *string         `json:"ip,omitempty"`
+	Body             *string         `json:"body,omitempty"`
+	BodySize         int64           `json:"body_size"`
+	ContentType      *string         `json:"content_type,omitempty"`
+	ReceivedAt       time.Time       `json:"received_at"`
+	EncryptedPayload *string         `json:"encrypted_payload,omitempty"`
+	CorrelationID    *string         `json:"correlation_id,omitempty"`
+}
+
+// archiveExpiredRequests writes the expired requests of an endpoint to a
+// gzip-compressed NDJSON archive in dir, deleting each batch once it is
+// flushed to disk. It returns how many requests were archived and deleted.
+func archiveExpiredRequests(ctx context.Context, endpointID uuid.UUID, dir string, cutoff time.Time) (deleted int64, err error) {
+	var expired bool
+	err = db.Pool.QueryRow(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM requests WHERE `+expiredRequests+`)`,
+		endpointID,
+		cutoff,
+	).Scan(&expired)
+	if err != nil || !expired {
+		return 0, err
+	}
+
+	file, archivePath, err := storage.CreateArchiveFile(dir, endpointID)
+	if err != nil {
+		return 0, err
+	}
+	gz := gzip.NewWriter(file)
+	defer func() {
+		// Close even after a failure, leaving a valid archive of the batches
+		// already deleted
+		if closeErr := gz.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	var archiveID uuid.UUID
+	err = db.Pool.QueryRow(
+		ctx,
+		`INSERT INTO request_archives (endpoint_id, file_path) VALUES ($1, $2) RETURNING id`,
+		endpointID,
+		archivePath,
+	).Scan(&archiveID)
+	if err != nil {
+		return 0, err
+	}
+
+	encoder := json.NewEncoder(gz)
+	for {
+		batch, err := expiredRequestBatch(ctx, endpointID, cutoff)
+		if err != nil {
+			return deleted, err
+		}
+		if len(batch) == 0 {
+			return deleted, nil
+		}
+
+		ids := make([]uuid.UUID, len(batch))
+		for i := range batch {
+			if err := encoder.Encode(batch[i]); err != nil {
+				return deleted, err
+			}
+			ids[i] = batch[i].ID
+		}
+		// The batch must be on disk before its requests are deleted
+		if err := gz.Flush(); err != nil {
+			return deleted, err
+		}
+		if err := file.Sync(); err != nil {
+			return deleted, err
+		}
+
+		result, err := db.Pool.Exec(ctx, `DELETE FROM requests WHERE id = ANY($1)`, ids)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.RowsAffected()
+
+		_, err = db.Pool.Exec(
+			ctx,
+			`UPDATE request_archives
+			 SET request_count = request_count + $2,
+			     oldest_received_at = LEAST(oldest_received_at, $3),
+			     newest_received_at = GREATEST(newest_received_at, $4)
+			 WHERE id = $1`,
+			archiveID,
+			len(batch),
+			batch[0].ReceivedAt,
+			batch[len(batch)-1].ReceivedAt,
+		)
+		if err != nil {
+			return deleted, err
+		}
+
+		if len(batch) < retentionBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// expiredRequestBatch reads the oldest batch of expired requests of an endpoint
+func expiredRequestBatch(ctx context.Context, endpointID uuid.UUID, cutoff time.Time) ([]archivedRequest, error) {
+	rows, err := db.Pool.Query(
+		ctx,
+		`SELECT id, endpoint_id, method, path, subpath, headers, query_params, ip, body, body_size, content_type, received_at, encrypted_payload, correlation_id
+		 FROM requests
+		 WHERE `+expiredRequests+`
+		 ORDER BY received_at, id
+		 LIMIT $3`,
+		endpointID,
+		cutoff,
+		retentionBatchSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []archivedRequest
+	for rows.Next() {
+		var req archivedRequest
+		var headers, queryParams []byte
+		var bodySize *int64
+		err := rows.Scan(
+			&req.ID,
+			&req.EndpointID,
+			&req.Method,
+			&req.Path,
+			&req.Subpath,
+			&headers,
+			&queryParams,
+			&req.IP,
+			&req.Body,
+			&bodySize,
+			&req.ContentType,
+			&req.ReceivedAt,
+			&req.EncryptedPayload,
+			&req.CorrelationID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		req.Headers = headers
+		req.QueryParams = queryParams
+		if bodySize != nil {
+			req.BodySize = *bodySize
+		}
+		batch = append(batch, req)
+	}
+	return batch, rows.Err()
+}
+
+// requestArchiveColumns is the column list read by scanRequestArchive
+const requestArchiveColumns = `id, endpoint_id, file_path, request_count, oldest_received_at, newest_received_at, created_at, restored_at`
+
+func scanRequestArchive(scanner interface {
+	Scan(dest ...interface{}) error
+}) (models.RequestArchive, error) {
+	var archive models.RequestArchive
+	err := scanner.Scan(
+		&archive.ID,
+		&archive.EndpointID,
+		&archive.FilePath,
+		&archive.RequestCount,
+		&archive.OldestReceivedAt,
+		&archive.NewestReceivedAt,
+		&archive.CreatedAt,
+		&archive.RestoredAt,
+	)
+	return archive, err
+}
+
+// GetRequestArchives handles GET /api/v1/endpoints/:slug/archives
+func GetRequestArchives(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := strings.TrimPrefix(r.URL.Path, "/api/v1/endpoints/")
+	slug = strings.TrimSuffix(slug, "/archives")
+
+	rows, err := db.Pool.Query(
+		r.Context(),
+		`SELECT `+requestArchiveColumns+`
+		 FROM request_archives
+		 WHERE endpoint_id = (SELECT id FROM endpoints WHERE slug = $1)
+		 ORDER BY created_at DESC
+		 LIMIT 100`,
+		slug,
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	archives := []models.RequestArchive{}
+	for rows.Next() {
+		archive, err := scanRequestArchive(rows)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to scan request archive: %v", err), http.StatusInternalServerError)
+			return
+		}
+		archives = append(archives, archive)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archives)
+}
+
+// RestoreRequestArchive handles POST /api/v1/archives/:id/restore. Requests
+// still present are skipped, so an archive can be restored more than once.
+func RestoreRequestArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/v1/archives/")
+	idStr = strings.TrimSuffix(idStr, "/restore")
+	archiveID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "Invalid archive ID", http.StatusBadRequest)
+		return
+	}
+
+	archive, err := scanRequestArchive(db.Pool.QueryRow(
+		r.Context(),
+		`SELECT `+requestArchiveColumns+` FROM request_archives WHERE id = $1`,
+		archiveID,
+	))
+	if err == pgx.ErrNoRows {
+		http.Error(w, "Archive not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Archives are written to the data directory of the replica that ran the
+	// retention job, so replicas must share it for restores to work on any of them
+	file, err := storage.OpenArchiveFile(archive.FilePath)
+	if os.IsNotExist(err) {
+		http.Error(w, "Archive file not found on this server", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open archive: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	restored, skipped, err := restoreArchivedRequests(r.Context(), archive.EndpointID, file)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to restore archive after %d requests: %v", restored, err), http.StatusInternalServerError)
+		return
+	}
+
+	err = db.Pool.QueryRow(
+		r.Context(),
+		`UPDATE request_archives SET restored_at = now() WHERE id = $1 RETURNING restored_at`,
+		archiveID,
+	).Scan(&archive.RestoredAt)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"archive":  archive,
+		"restored": restored,
+		"skipped":  skipped,
+	})
+}
+
+// restoreArchivedRequests inserts the requests of an archive back into the
+// endpoint in batches, skipping those that already exist
+func restoreArchivedRequests(ctx context.Context, endpointID uuid.UUID, file io.Reader) (restored, skipped int, err error) {
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid archive: %w", err)
+	}
+	defer gz.Close()
+
+	decoder := json.NewDecoder(gz)
+	for {
+		batch := &pgx.Batch{}
+		for batch.Len() < retentionBatchSize {
+			var req archivedRequest
+			if err := decoder.Decode(&req); err == io.EOF {
+				break
+			} else if err != nil {
+				return restored, skipped, fmt.Errorf("invalid archive: %w", err)
+			}
+
+			var headers, queryParams *string
+			if len(req.Headers) > 0 {
+				s := string(req.Headers)
+				headers = &s
+			}
+			if len(req.QueryParams) > 0 {
+				s := string(req.QueryParams)
+				queryParams = &s
+			}
+			batch.Queue(
+				`INSERT INTO requests (id, endpoint_id, method, path, subpath, headers, query_params, ip, body, body_size, content_type, received_at, encrypted_payload, correlation_id, restored_at)
+				 VALUES ($1, $2, $3, $4, $5, COALESCE($6, '{}'::jsonb), COALESCE($7, '{}'::jsonb), $8, $9, $10, $11, $12, $13, $14, now())
+				 ON CONFLICT (id, received_at) DO NOTHING`,
+				req.ID,
+				endpointID,
+				req.Method,
+				req.Path,
+				req.Subpath,
+				headers,
+				queryParams,
+				req.IP,
+				req.Body,
+				req.BodySize,
+				req.ContentType,
+				req.ReceivedAt,
+				req.EncryptedPayload,
+				req.CorrelationID,
+			)
+		}
+		if batch.Len() == 0 {
+			return restored, skipped, nil
+		}
+
+		results := db.Pool.SendBatch(ctx, batch)
+		for i := 0; i < batch.Len(); i++ {
+			result, err := results.Exec()
+			if err != nil {
+				results.Close()
+				return restored, skipped, err
+			}
+			if result.RowsAffected() == 1 {
+				restored++
+			} else {
+				skipped++
+			}
+		}
+		if err := results.Close(); err != nil {
+			return restored, skipped, err
+		}
+
+		if batch.Len() < retentionBatchSize {
+			return restored, skipped, nil
+		}
+	}
+}
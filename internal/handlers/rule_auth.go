@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"flowhook/internal/models"
+	"flowhook/internal/outbound"
+	"flowhook/internal/secrets"
+)
+
+// tokenExpiryMargin renews OAuth2 tokens this long before they expire
+const tokenExpiryMargin = 30 * time.Second
+
+// tokenRequestTimeout bounds requests to an OAuth2 token endpoint
+const tokenRequestTimeout = 10 * time.Second
+
+// tokenSourceIdleTimeout drops cached OAuth2 tokens unused for this long, such
+// as those of rules changed or deleted through another replica
+const tokenSourceIdleTimeout = time.Hour
+
+// prepareAuthConfig validates an auth configuration from the API and returns
+// it for storage along with its encrypted secret. On update, existing is the
+// stored configuration: an omitted secret keeps the stored one if the type is
+// unchanged.
+func prepareAuthConfig(cfg *models.AuthConfig, existing *models.AuthConfig) ([]byte, *string, error) {
+	if cfg == nil || cfg.Type == "" {
+		return nil, nil, nil
+	}
+	stored := *cfg
+	stored.HasSecret = false
+
+	var secret, secretField string
+	switch stored.Type {
+	case models.AuthTypeBasic:
+		if stored.Username == "" {
+			return nil, nil, fmt.Errorf("username is required for basic auth")
+		}
+		secret, secretField = stored.Password, "password"
+	case models.AuthTypeBearer:
+		secret, secretField = stored.Token, "token"
+	case models.AuthTypeOAuth2ClientCredentials:
+		if stored.TokenURL == "" || stored.ClientID == "" {
+			return nil, nil, fmt.Errorf("token_url and client_id are required for oauth2_client_credentials auth")
+		}
+		if err := outbound.CheckURL(stored.TokenURL); err != nil {
+			return nil, nil, fmt.Errorf("invalid token_url: %v", err)
+		}
+		secret, secretField = stored.ClientSecret, "client_secret"
+	default:
+		return nil, nil, fmt.Errorf("type must be one of: basic, bearer, oauth2_client_credentials")
+	}
+
+	var encryptedSecret string
+	if secret != "" {
+		encrypted, err := secrets.Encrypt([]byte(secret))
+		if err != nil {
+			return nil, nil, err
+		}
+		encryptedSecret = encrypted
+	} else if existing != nil && existing.Type == stored.Type && existing.SecretEncrypted != "" {
+		encryptedSecret = existing.SecretEncrypted
+	} else {
+		return nil, nil, fmt.Errorf("%s is required", secretField)
+	}
+
+	stored.Password = ""
+	stored.Token = ""
+	stored.ClientSecret = ""
+	stored.SecretEncrypted = ""
+
+	configJSON, _ := json.Marshal(stored)
+	return configJSON, &encryptedSecret, nil
+}
+
+// authorizeRequest sets the Authorization header for a rule's auth
+// configuration. rejected is an OAuth2 token the target refused, which is
+// replaced if still cached; it is empty on a first attempt.
+func authorizeRequest(ctx context.Context, auth *models.AuthConfig, req *http.Request, rejected string) error {
+	if auth == nil || auth.Type == "" {
+		return nil
+	}
+	secret, err := secrets.Decrypt(auth.SecretEncrypted)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt auth secret: %v", err)
+	}
+
+	switch auth.Type {
+	case models.AuthTypeBasic:
+		req.SetBasicAuth(auth.Username, string(secret))
+	case models.AuthTypeBearer:
+		req.Header.Set("Authorization", "Bearer "+string(secret))
+	case models.AuthTypeOAuth2ClientCredentials:
+		token, err := oauth2Token(ctx, auth, string(secret), rejected)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	default:
+		return fmt.Errorf("unsupported auth type %q", auth.Type)
+	}
+	return nil
+}
+
+// refreshesOnUnauthorized reports whether a 401 should be retried with a new token
+func refreshesOnUnauthorized(auth *models.AuthConfig) bool {
+	return auth != nil && auth.Type == models.AuthTypeOAuth2ClientCredentials
+}
+
+// oauth2TokenSource caches the access token for one set of client credentials.
+// Its lock is held while fetching so concurrent forwards share one request.
+type oauth2TokenSource struct {
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time // Zero when the token endpoint gave no lifetime
+	lastUsed    time.Time // Guarded by tokenSourcesMu
+}
+
+var (
+	tokenSourcesMu      sync.Mutex
+	tokenSources        = make(map[string]*oauth2TokenSource)
+	tokenSourcesSweptAt time.Time
+)
+
+// oauth2Token returns a cached access token, fetching one when there is none,
+// it is about to expire or it is the rejected token. Forwards refused with the
+// same token wait for one fetch and share its result.
+func oauth2Token(ctx context.Context, auth *models.AuthConfig, clientSecret string, rejected string) (string, error) {
+	source := tokenSourceFor(auth)
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	valid := source.accessToken != "" &&
+		(source.expiresAt.IsZero() || time.Now().Add(tokenExpiryMargin).Before(source.expiresAt))
+	if valid && source.accessToken != rejected {
+		return source.accessToken, nil
+	}
+
+	token, expiresIn, err := fetchOAuth2Token(ctx, auth, clientSecret)
+	if err != nil {
+		source.accessToken = ""
+		return "", err
+	}
+	source.accessToken = token
+	source.expiresAt = time.Time{}
+	if expiresIn > 0 {
+		source.expiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// tokenSourceFor returns the cache entry for auth's credentials, dropping
+// entries left idle
+func tokenSourceFor(auth *models.AuthConfig) *oauth2TokenSource {
+	key := tokenSourceKey(auth)
+	now := time.Now()
+
+	tokenSourcesMu.Lock()
+	defer tokenSourcesMu.Unlock()
+	if now.Sub(tokenSourcesSweptAt) > tokenSourceIdleTimeout {
+		for k, source := range tokenSources {
+			if now.Sub(source.lastUsed) > tokenSourceIdleTimeout {
+				delete(tokenSources, k)
+			}
+		}
+		tokenSourcesSweptAt = now
+	}
+
+	source, ok := tokenSources[key]
+	if !ok {
+		source = &oauth2TokenSource{}
+		tokenSources[key] = source
+	}
+	source.lastUsed = now
+	return source
+}
+
+// forgetOAuth2Token drops the cached token of a rule's auth configuration once
+// the rule is updated or deleted
+func forgetOAuth2Token(auth *models.AuthConfig) {
+	if auth == nil || auth.Type != models.AuthTypeOAuth2ClientCredentials {
+		return
+	}
+	key := tokenSourceKey(auth)
+
+	tokenSourcesMu.Lock()
+	defer tokenSourcesMu.Unlock()
+	delete(tokenSources, key)
+}
+
+// tokenSourceKey identifies auth's credentials. Changing a rule's credentials
+// re-encrypts its secret, so the key changes with them.
+func tokenSourceKey(auth *models.AuthConfig) string {
+	h := sha256.New()
+	for _, part := range []string{auth.TokenURL, auth.ClientID, auth.SecretEncrypted, auth.Audience, strings.Join(auth.Scopes, " ")} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fetchOAuth2Token performs a client-credentials grant, authenticating the
+// client with HTTP Basic as RFC 6749 recommends
+func fetchOAuth2Token(ctx context.Context, auth *models.AuthConfig, clientSecret string) (string, int, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(auth.Scopes, " "))
+	}
+	if auth.Audience != "" {
+		form.Set("audience", auth.Audience)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, auth.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid token_url: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(auth.ClientID), url.QueryEscape(clientSecret))
+
+	resp, err := outbound.Client(tokenRequestTimeout, false).Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token request failed with status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return "", 0, fmt.Errorf("token response has no access_token")
+	}
+	return token.AccessToken, token.ExpiresIn, nil
+}
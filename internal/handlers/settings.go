@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"flowhook/internal/db"
+	"flowhook/internal/secrets"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -39,16 +40,20 @@ func GetEndpointSettings(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var settings struct {
-		HMACSecret       *string `json:"hmac_secret,omitempty"`
-		HMACAlgorithm    string  `json:"hmac_algorithm"`
-		RateLimitPerMin  *int    `json:"rate_limit_per_minute,omitempty"`
-		RateLimitPerHour *int    `json:"rate_limit_per_hour,omitempty"`
-		RateLimitPerDay  *int    `json:"rate_limit_per_day,omitempty"`
+		HMACSecret       *string    `json:"hmac_secret,omitempty"`
+		HMACAlgorithm    string     `json:"hmac_algorithm"`
+		RateLimitPerMin  *int       `json:"rate_limit_per_minute,omitempty"`
+		RateLimitPerHour *int       `json:"rate_limit_per_hour,omitempty"`
+		RateLimitPerDay  *int       `json:"rate_limit_per_day,omitempty"`
+		Mode             string     `json:"mode"`
+		PrimaryRuleID    *uuid.UUID `json:"primary_rule_id,omitempty"`
+		EncryptPayloads  bool       `json:"encrypt_payloads"`
 	}
 
 	err = db.Pool.QueryRow(
 		r.Context(),
-		`SELECT hmac_secret, hmac_algorithm, rate_limit_per_minute, rate_limit_per_hour, rate_limit_per_day
+		`SELECT hmac_secret, hmac_algorithm, rate_limit_per_minute, rate_limit_per_hour, rate_limit_per_day,
+		        COALESCE(mode, 'capture'), primary_rule_id, encrypt_payloads
 		 FROM endpoint_settings WHERE endpoint_id = $1`,
 		endpointID,
 	).Scan(
@@ -57,11 +62,15 @@ func GetEndpointSettings(w http.ResponseWriter, r *http.Request) {
 		&settings.RateLimitPerMin,
 		&settings.RateLimitPerHour,
 		&settings.RateLimitPerDay,
+		&settings.Mode,
+		&settings.PrimaryRuleID,
+		&settings.EncryptPayloads,
 	)
 
 	if err == pgx.ErrNoRows {
 		// Return defaults
 		settings.HMACAlgorithm = "sha256"
+		settings.Mode = "capture"
 	} else if err != nil {
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
@@ -69,7 +78,7 @@ func GetEndpointSettings(w http.ResponseWriter, r *http.Request) {
 
 	// Don't return secret value, just indicate if it's set
 	if settings.HMACSecret != nil && *settings.HMACSecret != "" {
-		secretSet := "***"
+		secretSet := maskedValue
 		settings.HMACSecret = &secretSet
 	}
 
@@ -109,6 +118,9 @@ func UpdateEndpointSettings(w http.ResponseWriter, r *http.Request) {
 		RateLimitPerMin  *int    `json:"rate_limit_per_minute,omitempty"`
 		RateLimitPerHour *int    `json:"rate_limit_per_hour,omitempty"`
 		RateLimitPerDay  *int    `json:"rate_limit_per_day,omitempty"`
+		Mode             *string `json:"mode,omitempty"`
+		PrimaryRuleID    *string `json:"primary_rule_id,omitempty"` // "" clears it
+		EncryptPayloads  *bool   `json:"encrypt_payloads,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -116,11 +128,63 @@ func UpdateEndpointSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The masked secret returned by GET keeps the stored one
+	if req.HMACSecret != nil && *req.HMACSecret == maskedValue {
+		req.HMACSecret = nil
+	}
+	if req.HMACSecret != nil {
+		encrypted, err := secrets.EncryptString(*req.HMACSecret)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to encrypt hmac_secret: %v", err), http.StatusInternalServerError)
+			return
+		}
+		req.HMACSecret = &encrypted
+	}
+	if req.EncryptPayloads != nil && *req.EncryptPayloads && !secrets.Configured() {
+		http.Error(w, "encrypt_payloads requires ENCRYPTION_KEY to be set", http.StatusBadRequest)
+		return
+	}
+
+	if req.Mode != nil && *req.Mode != "capture" && *req.Mode != "proxy" {
+		http.Error(w, "mode must be one of: capture, proxy", http.StatusBadRequest)
+		return
+	}
+
+	// The primary rule must be an enabled rule of this endpoint
+	var primaryRuleID *uuid.UUID
+	if req.PrimaryRuleID != nil && *req.PrimaryRuleID != "" {
+		id, err := uuid.Parse(*req.PrimaryRuleID)
+		if err != nil {
+			http.Error(w, "Invalid primary_rule_id", http.StatusBadRequest)
+			return
+		}
+		var ruleEndpointID uuid.UUID
+		var enabled bool
+		err = db.Pool.QueryRow(
+			r.Context(),
+			`SELECT endpoint_id, enabled FROM forwarding_rules WHERE id = $1`,
+			id,
+		).Scan(&ruleEndpointID, &enabled)
+		if err == pgx.ErrNoRows || (err == nil && ruleEndpointID != endpointID) {
+			http.Error(w, "primary_rule_id must reference a forwarding rule of this endpoint", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !enabled {
+			http.Error(w, "primary_rule_id must reference an enabled forwarding rule", http.StatusBadRequest)
+			return
+		}
+		primaryRuleID = &id
+	}
+
 	// Upsert settings
 	_, err = db.Pool.Exec(
 		r.Context(),
-		`INSERT INTO endpoint_settings (endpoint_id, hmac_secret, hmac_algorithm, rate_limit_per_minute, rate_limit_per_hour, rate_limit_per_day, updated_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, now())
+		`INSERT INTO endpoint_settings (endpoint_id, hmac_secret, hmac_algorithm, rate_limit_per_minute, rate_limit_per_hour, rate_limit_per_day, mode, primary_rule_id, encrypt_payloads, updated_at)
+		 VALUES ($1, $2, COALESCE($3, 'sha256'), $4, $5, $6, COALESCE($7, 'capture'), $8, COALESCE($9, false), now())
 		 ON CONFLICT (endpoint_id) 
 		 DO UPDATE SET 
 		   hmac_secret = COALESCE($2, endpoint_settings.hmac_secret),
@@ -128,6 +192,9 @@ func UpdateEndpointSettings(w http.ResponseWriter, r *http.Request) {
 		   rate_limit_per_minute = COALESCE($4, endpoint_settings.rate_limit_per_minute),
 		   rate_limit_per_hour = COALESCE($5, endpoint_settings.rate_limit_per_hour),
 		   rate_limit_per_day = COALESCE($6, endpoint_settings.rate_limit_per_day),
+		   mode = COALESCE($7, endpoint_settings.mode),
+		   primary_rule_id = CASE WHEN $10 THEN $8 ELSE endpoint_settings.primary_rule_id END,
+		   encrypt_payloads = COALESCE($9, endpoint_settings.encrypt_payloads),
 		   updated_at = now()`,
 		endpointID,
 		req.HMACSecret,
@@ -135,6 +202,10 @@ func UpdateEndpointSettings(w http.ResponseWriter, r *http.Request) {
 		req.RateLimitPerMin,
 		req.RateLimitPerHour,
 		req.RateLimitPerDay,
+		req.Mode,
+		primaryRuleID,
+		req.EncryptPayloads,
+		req.PrimaryRuleID != nil,
 	)
 
 	if err != nil {
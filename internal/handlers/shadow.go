@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"flowhook/internal/db"
+	"flowhook/internal/diff"
+	"flowhook/internal/logger"
+	"flowhook/internal/models"
+	"flowhook/internal/outbound"
+	"flowhook/internal/tracing"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// shadowIgnoredHeaders differ between any two responses and are never compared
+var shadowIgnoredHeaders = []string{
+	"headers.Date",
+	"headers.Content-Length",
+	"headers.Connection",
+	"headers.Keep-Alive",
+	"headers.Transfer-Encoding",
+}
+
+// maxStoredShadowChanges bounds the header and body changes kept per shadow attempt
+const maxStoredShadowChanges = 20
+
+// startShadow mirrors a forward to the rule's shadow target, if it has one.
+// headers are the captured request's: the rule's headers and auth are meant
+// for the primary target and are not sent to the shadow.
+// The returned function waits for the shadow response and records it compared
+// with the primary's final attempt; it is nil when the rule has no shadow.
+func startShadow(ctx context.Context, rule models.ForwardingRule, captured capturedRequest, method string, headers map[string]interface{}, body []byte) func(primary *forwardResult) {
+	if rule.ShadowTargetURL == nil || *rule.ShadowTargetURL == "" {
+		return nil
+	}
+
+	shadowRule := rule
+	shadowRule.TargetURL = *rule.ShadowTargetURL
+	targetURL := resolveTargetURL(shadowRule, captured)
+
+	// The shadow outlives the capture or proxy request that started it
+	ctx, span := tracing.Start(context.WithoutCancel(ctx), "shadow forward",
+		attribute.String("flowhook.rule.id", rule.ID.String()),
+		attribute.String("url.full", targetURL),
+	)
+	done := make(chan *forwardResult, 1)
+	go func() {
+		// Transport settings belong to the primary target, not the shadow
+		result := sendForward(ctx, outbound.Client(defaultForwardTimeout, true), nil, targetURL, method, headers, body)
+		tracing.End(span, result.Err)
+		done <- result
+	}()
+
+	return func(primary *forwardResult) {
+		recordShadowAttempt(ctx, captured.ID, rule, targetURL, primary, <-done)
+	}
+}
+
+// recordShadowAttempt compares a shadow response with the primary's and stores both outcomes
+func recordShadowAttempt(ctx context.Context, requestID uuid.UUID, rule models.ForwardingRule, targetURL string, primary, shadow *forwardResult) {
+	var primaryAttemptID *uuid.UUID
+	if primary.AttemptID != uuid.Nil {
+		primaryAttemptID = &primary.AttemptID
+	}
+	var primaryDuration *int
+	if primary.DurationMs > 0 {
+		primaryDuration = &primary.DurationMs
+	}
+
+	var errMsg *string
+	var respHeadersJSON []byte
+	var respBody *string
+	var responseStatus *int
+	if shadow.Err != nil {
+		msg := shadow.Err.Error()
+		errMsg = &msg
+	} else {
+		respHeadersJSON, _ = json.Marshal(headersToMap(shadow.Headers))
+		respBody = encodeResponseBody(shadow.Body)
+		responseStatus = &shadow.StatusCode
+	}
+	var duration *int
+	if shadow.DurationMs > 0 {
+		duration = &shadow.DurationMs
+	}
+
+	result := diff.Compare(
+		shadowDiffResponse(primary),
+		shadowDiffResponse(shadow),
+		append(append([]string{}, shadowIgnoredHeaders...), rule.ShadowIgnorePaths...),
+	)
+	statusMatch := result.Status.Base == result.Status.Compare
+	if len(result.Headers) > maxStoredShadowChanges {
+		result.Headers, result.Truncated = result.Headers[:maxStoredShadowChanges], true
+	}
+	if len(result.Body) > maxStoredShadowChanges {
+		result.Body, result.Truncated = result.Body[:maxStoredShadowChanges], true
+	}
+	diffJSON, _ := json.Marshal(result)
+
+	_, err := db.Pool.Exec(
+		ctx,
+		`INSERT INTO shadow_attempts (request_id, forwarding_rule_id, primary_attempt_id, target_url, status,
+		                              response_status, response_headers, response_body, error_message, duration_ms,
+		                              primary_status, primary_duration_ms, status_match, match, diff)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		requestID,
+		rule.ID,
+		primaryAttemptID,
+		targetURL,
+		shadow.Status,
+		responseStatus,
+		respHeadersJSON,
+		respBody,
+		errMsg,
+		duration,
+		primary.StatusCode,
+		primaryDuration,
+		statusMatch,
+		result.Equal,
+		diffJSON,
+	)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to record shadow attempt", "rule_id", rule.ID, "request_id", requestID, "error", err)
+	}
+}
+
+// shadowDiffResponse converts a raw forward result for comparison. Responses
+// that never arrived compare as status 0.
+func shadowDiffResponse(result *forwardResult) diff.Response {
+	if result.Err != nil {
+		return diff.Response{}
+	}
+	body := string(result.Body)
+	return diff.Response{
+		Status:  result.StatusCode,
+		Headers: headersToMap(result.Headers),
+		Body:    &body,
+	}
+}
+
+// shadowMismatch summarizes a shadow attempt that did not match its primary
+type shadowMismatch struct {
+	ID                uuid.UUID       `json:"id"`
+	RequestID         uuid.UUID       `json:"request_id"`
+	PrimaryAttemptID  *uuid.UUID      `json:"primary_attempt_id,omitempty"`
+	PrimaryStatus     *int            `json:"primary_status,omitempty"`
+	ShadowStatus      *int            `json:"shadow_status,omitempty"`
+	PrimaryDurationMs *int            `json:"primary_duration_ms,omitempty"`
+	ShadowDurationMs  *int            `json:"shadow_duration_ms,omitempty"`
+	StatusMatch       bool            `json:"status_match"`
+	ErrorMessage      *string         `json:"error_message,omitempty"`
+	Diff              json.RawMessage `json:"diff,omitempty"`
+	AttemptedAt       time.Time       `json:"attempted_at"`
+}
+
+// GetShadowReport handles GET /api/v1/forwarding-rules/:id/shadow-report?hours=24
+func GetShadowReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ruleIDStr := strings.TrimPrefix(r.URL.Path, "/api/v1/forwarding-rules/")
+	ruleIDStr = strings.TrimSuffix(ruleIDStr, "/shadow-report")
+	ruleID, err := uuid.Parse(ruleIDStr)
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	hours := 24
+	if hoursStr := r.URL.Query().Get("hours"); hoursStr != "" {
+		if h, err := time.ParseDuration(hoursStr + "h"); err == nil && h > 0 {
+			hours = int(h.Hours())
+		}
+	}
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+
+	ctx := r.Context()
+
+	// Totals and latency deltas (shadow minus primary) over attempts where both responded
+	var total, matched, statusMismatches, shadowErrors int
+	var avgPrimary, avgShadow, avgDelta, p50Delta, p95Delta *float64
+	err = db.Pool.QueryRow(
+		ctx,
+		`SELECT COUNT(*),
+		        COUNT(*) FILTER (WHERE match),
+		        COUNT(*) FILTER (WHERE NOT status_match),
+		        COUNT(*) FILTER (WHERE status = 'failed'),
+		        AVG(primary_duration_ms) FILTER (WHERE status = 'success'),
+		        AVG(duration_ms) FILTER (WHERE status = 'success'),
+		        AVG(duration_ms - primary_duration_ms) FILTER (WHERE status = 'success'),
+		        PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY duration_ms - primary_duration_ms) FILTER (WHERE status = 'success'),
+		        PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms - primary_duration_ms) FILTER (WHERE status = 'success')
+		 FROM shadow_attempts
+		 WHERE forwarding_rule_id = $1 AND attempted_at >= $2`,
+		ruleID, since,
+	).Scan(&total, &matched, &statusMismatches, &shadowErrors, &avgPrimary, &avgShadow, &avgDelta, &p50Delta, &p95Delta)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	matchRate := 0.0
+	if total > 0 {
+		matchRate = float64(matched) / float64(total)
+	}
+
+	// Status pairs that differed
+	type statusPair struct {
+		PrimaryStatus int `json:"primary_status"`
+		ShadowStatus  int `json:"shadow_status"`
+		Count         int `json:"count"`
+	}
+	statusPairs := []statusPair{}
+	rows, err := db.Pool.Query(
+		ctx,
+		`SELECT COALESCE(primary_status, 0), COALESCE(response_status, 0), COUNT(*)
+		 FROM shadow_attempts
+		 WHERE forwarding_rule_id = $1 AND attempted_at >= $2 AND NOT status_match
+		 GROUP BY 1, 2`,
+		ruleID, since,
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var pair statusPair
+		if err := rows.Scan(&pair.PrimaryStatus, &pair.ShadowStatus, &pair.Count); err != nil {
+			rows.Close()
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		statusPairs = append(statusPairs, pair)
+	}
+	rows.Close()
+	sort.Slice(statusPairs, func(i, j int) bool { return statusPairs[i].Count > statusPairs[j].Count })
+
+	// Hourly breakdown, as in the delivery stats
+	type hourlyStat struct {
+		Hour             time.Time `json:"hour"`
+		Total            int       `json:"total"`
+		Matched          int       `json:"matched"`
+		StatusMismatches int       `json:"status_mismatches"`
+		AvgDeltaMs       *float64  `json:"avg_latency_delta_ms,omitempty"`
+	}
+	hourly := []hourlyStat{}
+	rows, err = db.Pool.Query(
+		ctx,
+		`SELECT DATE_TRUNC('hour', attempted_at), COUNT(*),
+		        COUNT(*) FILTER (WHERE match),
+		        COUNT(*) FILTER (WHERE NOT status_match),
+		        AVG(duration_ms - primary_duration_ms) FILTER (WHERE status = 'success')
+		 FROM shadow_attempts
+		 WHERE forwarding_rule_id = $1 AND attempted_at >= $2
+		 GROUP BY 1 ORDER BY 1`,
+		ruleID, since,
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var stat hourlyStat
+		if err := rows.Scan(&stat.Hour, &stat.Total, &stat.Matched, &stat.StatusMismatches, &stat.AvgDeltaMs); err != nil {
+			rows.Close()
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		hourly = append(hourly, stat)
+	}
+	rows.Close()
+
+	// Most recent mismatches with their stored diff summaries
+	mismatches := []shadowMismatch{}
+	rows, err = db.Pool.Query(
+		ctx,
+		`SELECT id, request_id, primary_attempt_id, primary_status, response_status, primary_duration_ms,
+		        duration_ms, status_match, error_message, diff, attempted_at
+		 FROM shadow_attempts
+		 WHERE forwarding_rule_id = $1 AND attempted_at >= $2 AND NOT match
+		 ORDER BY attempted_at DESC, id DESC
+		 LIMIT 20`,
+		ruleID, since,
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m shadowMismatch
+		var diffJSON []byte
+		err := rows.Scan(&m.ID, &m.RequestID, &m.PrimaryAttemptID, &m.PrimaryStatus, &m.ShadowStatus,
+			&m.PrimaryDurationMs, &m.ShadowDurationMs, &m.StatusMatch, &m.ErrorMessage, &diffJSON, &m.AttemptedAt)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		m.Diff = diffJSON
+		mismatches = append(mismatches, m)
+	}
+
+	response := map[string]interface{}{
+		"rule_id":           ruleID,
+		"time_range_hours":  hours,
+		"total":             total,
+		"matched":           matched,
+		"match_rate":        matchRate,
+		"status_mismatches": statusMismatches,
+		"shadow_errors":     shadowErrors,
+		"latency": map[string]interface{}{
+			"avg_primary_ms": avgPrimary,
+			"avg_shadow_ms":  avgShadow,
+			"avg_delta_ms":   avgDelta,
+			"p50_delta_ms":   p50Delta,
+			"p95_delta_ms":   p95Delta,
+		},
+		"status_pairs":      statusPairs,
+		"hourly_breakdown":  hourly,
+		"recent_mismatches": mismatches,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"flowhook/internal/db"
+	"flowhook/internal/secrets"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -39,6 +40,11 @@ func VerifySignature(endpointID uuid.UUID, r *http.Request, body []byte) (bool,
 		// No secret configured
 		return true, nil
 	}
+	plaintext, err := secrets.DecryptString(*secret)
+	if err != nil {
+		return false, fmt.Errorf("failed to decrypt hmac secret: %w", err)
+	}
+	secret = &plaintext
 
 	// Get signature from header (common patterns)
 	signature := r.Header.Get("X-Signature")
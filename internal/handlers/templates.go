@@ -3,14 +3,17 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
-	"time"
 
 	"flowhook/internal/db"
+	"flowhook/internal/logger"
 	"flowhook/internal/models"
+	"flowhook/internal/outbound"
+	"flowhook/internal/transform"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -52,14 +55,26 @@ func CreateRequestTemplate(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "name, method, and url are required", http.StatusBadRequest)
 		return
 	}
+	if err := outbound.CheckURL(req.URL); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid url: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := checkTransportRule(r.Context(), req.TransportRuleID, endpointID); err != nil {
+		if err == errInvalidTransportRule {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	headersJSON, _ := json.Marshal(req.Headers)
 
 	var templateID uuid.UUID
 	err = db.Pool.QueryRow(
 		r.Context(),
-		`INSERT INTO request_templates (endpoint_id, name, method, url, headers, body, description)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`INSERT INTO request_templates (endpoint_id, name, method, url, headers, body, description, transport_rule_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		 RETURNING id`,
 		endpointID,
 		req.Name,
@@ -68,6 +83,7 @@ func CreateRequestTemplate(w http.ResponseWriter, r *http.Request) {
 		headersJSON,
 		req.Body,
 		req.Description,
+		req.TransportRuleID,
 	).Scan(&templateID)
 
 	if err != nil {
@@ -113,7 +129,7 @@ func GetRequestTemplates(w http.ResponseWriter, r *http.Request) {
 
 	rows, err := db.Pool.Query(
 		r.Context(),
-		`SELECT id, endpoint_id, name, method, url, headers, body, description, created_at, updated_at
+		`SELECT id, endpoint_id, name, method, url, headers, body, description, transport_rule_id, created_at, updated_at
 		 FROM request_templates WHERE endpoint_id = $1 ORDER BY created_at DESC`,
 		endpointID,
 	)
@@ -182,8 +198,18 @@ func SendTemplateRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Templates created before destination checks existed are checked here too
+	if err := outbound.CheckURL(template.URL); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid template url: %v", err), http.StatusBadRequest)
+		return
+	}
+
 	// Send HTTP request using template
-	client := &http.Client{Timeout: 30 * time.Second}
+	client, err := transportRuleClient(r.Context(), template.TransportRuleID, 0, true)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to configure transport: %v", err), http.StatusInternalServerError)
+		return
+	}
 	req, err := http.NewRequest(template.Method, template.URL, nil)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create request: %v", err), http.StatusInternalServerError)
@@ -208,6 +234,11 @@ func SendTemplateRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp, err := client.Do(req)
+	var blocked *outbound.BlockedError
+	if errors.As(err, &blocked) {
+		http.Error(w, blocked.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to send request: %v", err), http.StatusInternalServerError)
 		return
@@ -224,6 +255,15 @@ func SendTemplateRequest(w http.ResponseWriter, r *http.Request) {
 		"template_id": templateID,
 	}
 
+	// Apply response transformations, returning the raw body alongside
+	transformedBody, applied, err := transform.TransformResponseBody(r.Context(), template.EndpointID, body)
+	if err != nil {
+		logger.WarnContext(r.Context(), "Failed to apply response transformations to template send", "template_id", templateID, "error", err)
+	}
+	if applied {
+		result["transformed_body"] = string(transformedBody)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
@@ -231,7 +271,7 @@ func SendTemplateRequest(w http.ResponseWriter, r *http.Request) {
 func getRequestTemplateByID(ctx context.Context, templateID uuid.UUID) (models.RequestTemplate, error) {
 	row := db.Pool.QueryRow(
 		ctx,
-		`SELECT id, endpoint_id, name, method, url, headers, body, description, created_at, updated_at
+		`SELECT id, endpoint_id, name, method, url, headers, body, description, transport_rule_id, created_at, updated_at
 		 FROM request_templates WHERE id = $1`,
 		templateID,
 	)
@@ -253,6 +293,7 @@ func scanRequestTemplate(row interface{ Scan(...interface{}) error }) (models.Re
 		&headersJSON,
 		&body,
 		&description,
+		&template.TransportRuleID,
 		&template.CreatedAt,
 		&template.UpdatedAt,
 	)
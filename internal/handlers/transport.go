@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"flowhook/internal/db"
+	"flowhook/internal/models"
+	"flowhook/internal/outbound"
+	"flowhook/internal/secrets"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// errInvalidTransportRule is returned when a replay or template names a rule
+// of another endpoint
+var errInvalidTransportRule = errors.New("transport_rule_id must be a forwarding rule of the same endpoint")
+
+// defaultForwardTimeout applies to rules without a transport timeout
+const defaultForwardTimeout = 30 * time.Second
+
+// maxTransportTimeoutMs is the longest per-attempt timeout a rule's transport
+// or a replay may set
+const maxTransportTimeoutMs = 120000
+
+// ruleTimeout returns the per-attempt timeout for a rule's downstream
+func ruleTimeout(rule models.ForwardingRule) time.Duration {
+	if rule.Transport != nil && rule.Transport.TimeoutMs > 0 {
+		return time.Duration(rule.Transport.TimeoutMs) * time.Millisecond
+	}
+	return defaultForwardTimeout
+}
+
+// ruleClient returns an HTTP client using a rule's transport configuration.
+// A nil rule, or one without configuration, gets the shared client.
+func ruleClient(rule *models.ForwardingRule, timeout time.Duration, followRedirects bool) (*http.Client, error) {
+	if rule == nil || rule.Transport == nil {
+		return outbound.Client(timeout, followRedirects), nil
+	}
+
+	opts, err := transportOptions(rule.Transport)
+	if err != nil {
+		return nil, err
+	}
+	return outbound.ClientWith(rule.ID.String(), rule.UpdatedAt.String(), opts, timeout, followRedirects)
+}
+
+// forgetRuleTransport drops the cached transport of a rule once its transport
+// configuration changes or it is deleted
+func forgetRuleTransport(ruleID uuid.UUID) {
+	outbound.ForgetTransport(ruleID.String())
+}
+
+// transportOptions converts a stored configuration, decrypting its client key
+func transportOptions(cfg *models.TransportConfig) (*outbound.TransportOptions, error) {
+	opts := &outbound.TransportOptions{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		CACertPEM:          cfg.CACertPEM,
+		ClientCertPEM:      cfg.ClientCertPEM,
+		ClientKeyPEM:       cfg.ClientKeyPEM,
+		ProxyURL:           cfg.ProxyURL,
+		DisableHTTP2:       cfg.DisableHTTP2,
+	}
+	if opts.ClientKeyPEM == "" && cfg.ClientKeyEncrypted != "" {
+		key, err := secrets.Decrypt(cfg.ClientKeyEncrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt client key: %v", err)
+		}
+		opts.ClientKeyPEM = string(key)
+	}
+	return opts, nil
+}
+
+// prepareTransportConfig validates a transport configuration from the API and
+// returns it for storage along with its encrypted client key. On update,
+// existing is the stored configuration: an omitted client key is kept while the
+// certificate is, and a redacted proxy URL keeps the stored one.
+func prepareTransportConfig(cfg *models.TransportConfig, existing *models.TransportConfig) ([]byte, *string, error) {
+	if cfg == nil || *cfg == (models.TransportConfig{}) {
+		return nil, nil, nil
+	}
+	stored := *cfg
+	stored.HasClientKey = false
+
+	if stored.TimeoutMs < 0 || stored.TimeoutMs > maxTransportTimeoutMs {
+		return nil, nil, fmt.Errorf("timeout_ms must be between 0 and %d, 0 for the default", maxTransportTimeoutMs)
+	}
+	if existing != nil {
+		if stored.ClientKeyPEM == "" && stored.ClientCertPEM != "" {
+			stored.ClientKeyEncrypted = existing.ClientKeyEncrypted
+		}
+		if stored.ProxyURL != "" && stored.ProxyURL == redactProxyURL(existing.ProxyURL) {
+			stored.ProxyURL = existing.ProxyURL
+		}
+	}
+
+	opts, err := transportOptions(&stored)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	var encryptedKey *string
+	if stored.ClientKeyPEM != "" {
+		encrypted, err := secrets.Encrypt([]byte(stored.ClientKeyPEM))
+		if err != nil {
+			return nil, nil, err
+		}
+		encryptedKey = &encrypted
+	} else if stored.ClientKeyEncrypted != "" {
+		encryptedKey = &stored.ClientKeyEncrypted
+	}
+	stored.ClientKeyPEM = ""
+
+	configJSON, _ := json.Marshal(stored)
+	return configJSON, encryptedKey, nil
+}
+
+// publicForwardingRule hides a rule's credentials before it is returned by the API
+func publicForwardingRule(rule models.ForwardingRule) models.ForwardingRule {
+	rule.Headers = maskRuleHeaders(rule.Headers)
+	if rule.Transport != nil {
+		transport := *rule.Transport
+		transport.ClientKeyPEM = ""
+		transport.ProxyURL = redactProxyURL(transport.ProxyURL)
+		rule.Transport = &transport
+	}
+	if rule.Auth != nil {
+		auth := *rule.Auth
+		auth.Password = ""
+		auth.Token = ""
+		auth.ClientSecret = ""
+		rule.Auth = &auth
+	}
+	return rule
+}
+
+// redactProxyURL masks the password in a proxy URL
+func redactProxyURL(proxyURL string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.User == nil {
+		return proxyURL
+	}
+	return u.Redacted()
+}
+
+// checkTransportRule verifies that a rule lent to a replay or template belongs
+// to the same endpoint. A nil ruleID is always valid.
+func checkTransportRule(ctx context.Context, ruleID *uuid.UUID, endpointID uuid.UUID) error {
+	if ruleID == nil {
+		return nil
+	}
+	var exists bool
+	err := db.Pool.QueryRow(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM forwarding_rules WHERE id = $1 AND endpoint_id = $2)`,
+		*ruleID, endpointID,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errInvalidTransportRule
+	}
+	return nil
+}
+
+// transportRuleClient returns a client using the transport of the rule with
+// ruleID, or the shared client when ruleID is nil. A deleted rule falls back
+// to the shared client, as its replays and templates keep working without it.
+// A zero timeout uses the rule's own.
+func transportRuleClient(ctx context.Context, ruleID *uuid.UUID, timeout time.Duration, followRedirects bool) (*http.Client, error) {
+	var rule *models.ForwardingRule
+	if ruleID != nil {
+		found, err := getForwardingRuleByID(ctx, *ruleID)
+		if err != nil && err != pgx.ErrNoRows {
+			return nil, err
+		}
+		if err == nil {
+			rule = &found
+		}
+	}
+	if timeout <= 0 {
+		timeout = defaultForwardTimeout
+		if rule != nil {
+			timeout = ruleTimeout(*rule)
+		}
+	}
+	return ruleClient(rule, timeout, followRedirects)
+}
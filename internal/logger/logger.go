@@ -1,94 +1,234 @@
 package logger
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"flowhook/internal/config"
-)
-
-type LogLevel int
+	"flowhook/internal/recorder"
 
-const (
-	DEBUG LogLevel = iota
-	INFO
-	WARN
-	ERROR
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var currentLevel LogLevel
+// RequestIDHeader carries the correlation ID of an HTTP request, in both
+// directions
+const RequestIDHeader = "X-Request-ID"
+
+// level is the minimum level logged, set from LOG_LEVEL by Init
+var level = new(slog.LevelVar)
 
 func init() {
+	SetOutput(os.Stderr)
+}
+
+// Init applies LOG_LEVEL: debug, info, warn or error (default info)
+func Init() {
 	levelStr := "info"
 	if config.AppConfig != nil {
 		levelStr = config.AppConfig.LogLevel
 	}
 
-	switch levelStr {
+	switch strings.ToLower(levelStr) {
 	case "debug":
-		currentLevel = DEBUG
-	case "info":
-		currentLevel = INFO
-	case "warn":
-		currentLevel = WARN
+		level.Set(slog.LevelDebug)
+	case "warn", "warning":
+		level.Set(slog.LevelWarn)
 	case "error":
-		currentLevel = ERROR
+		level.Set(slog.LevelError)
 	default:
-		currentLevel = INFO
+		level.Set(slog.LevelInfo)
 	}
 }
 
-func Debug(format string, v ...interface{}) {
-	if currentLevel <= DEBUG {
-		log.Printf("[DEBUG] %s", fmt.Sprintf(format, v...))
-	}
+// SetOutput sets the log output destination. Log lines are JSON objects,
+// and the standard log package writes through the same handler.
+func SetOutput(w io.Writer) {
+	handler := contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})}
+	slog.SetDefault(slog.New(handler))
 }
 
-func Info(format string, v ...interface{}) {
-	if currentLevel <= INFO {
-		log.Printf("[INFO] %s", fmt.Sprintf(format, v...))
-	}
+// contextHandler adds the correlation ID, job and trace of the context a
+// line is logged with
+type contextHandler struct {
+	slog.Handler
 }
 
-func Warn(format string, v ...interface{}) {
-	if currentLevel <= WARN {
-		log.Printf("[WARN] %s", fmt.Sprintf(format, v...))
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if c, ok := ctx.Value(correlationKey{}).(correlation); ok {
+		r.AddAttrs(slog.String("correlation_id", c.id))
+		if c.job != "" {
+			r.AddAttrs(slog.String("job", c.job))
+		}
 	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
 }
 
-func Error(format string, v ...interface{}) {
-	if currentLevel <= ERROR {
-		log.Printf("[ERROR] %s", fmt.Sprintf(format, v...))
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// correlationKey is the context key of a correlation
+type correlationKey struct{}
+
+// correlation ties together the log lines and records of one HTTP request
+// or background job run
+type correlation struct {
+	id  string
+	job string // Empty for HTTP requests
+}
+
+// NewCorrelationID returns a new random correlation ID
+func NewCorrelationID() string {
+	return uuid.NewString()
+}
+
+// WithCorrelationID returns ctx carrying correlation ID id
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, correlation{id: id})
+}
+
+// WithJob returns ctx carrying a new correlation ID for one run of the
+// background job name
+func WithJob(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, correlation{id: NewCorrelationID(), job: name})
+}
+
+// CorrelationID returns the correlation ID carried by ctx, or ""
+func CorrelationID(ctx context.Context) string {
+	c, _ := ctx.Value(correlationKey{}).(correlation)
+	return c.id
+}
+
+// validCorrelationID reports whether an incoming X-Request-ID can be reused
+func validCorrelationID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
 	}
+	return true
 }
 
-func Fatal(format string, v ...interface{}) {
-	log.Fatalf("[FATAL] %s", fmt.Sprintf(format, v...))
+// quietPaths are polled often enough that their requests are logged at debug level
+var quietPaths = map[string]bool{
+	"/health":  true,
+	"/ready":   true,
+	"/metrics": true,
+}
+
+// Middleware gives each request a correlation ID, reusing a well-formed
+// incoming X-Request-ID, returns it in X-Request-ID and logs the request
+// once handled
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validCorrelationID(id) {
+			id = NewCorrelationID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := WithCorrelationID(r.Context(), id)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("flowhook.correlation_id", id))
+
+		start := time.Now()
+		rec := recorder.New(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if quietPaths[r.URL.Path] {
+			logRequest(ctx, slog.LevelDebug, r.Method, r.URL.Path, rec.Status, time.Since(start))
+			return
+		}
+		LogRequest(ctx, r.Method, r.URL.Path, rec.Status, time.Since(start))
+	})
+}
+
+// Debug logs at debug level. As with slog, messages are constant and details
+// go in key/value pairs, e.g. Debug("Rule skipped", "rule_id", ruleID).
+func Debug(msg string, args ...any) {
+	slog.Default().Log(context.Background(), slog.LevelDebug, msg, args...)
+}
+
+func Info(msg string, args ...any) {
+	slog.Default().Log(context.Background(), slog.LevelInfo, msg, args...)
+}
+
+func Warn(msg string, args ...any) {
+	slog.Default().Log(context.Background(), slog.LevelWarn, msg, args...)
+}
+
+func Error(msg string, args ...any) {
+	slog.Default().Log(context.Background(), slog.LevelError, msg, args...)
+}
+
+func Fatal(msg string, args ...any) {
+	slog.Default().Log(context.Background(), slog.LevelError, msg, args...)
+	os.Exit(1)
+}
+
+// DebugContext logs at debug level with the correlation ID and trace of ctx
+func DebugContext(ctx context.Context, msg string, args ...any) {
+	slog.Default().Log(ctx, slog.LevelDebug, msg, args...)
+}
+
+// InfoContext logs at info level with the correlation ID and trace of ctx
+func InfoContext(ctx context.Context, msg string, args ...any) {
+	slog.Default().Log(ctx, slog.LevelInfo, msg, args...)
+}
+
+// WarnContext logs at warn level with the correlation ID and trace of ctx
+func WarnContext(ctx context.Context, msg string, args ...any) {
+	slog.Default().Log(ctx, slog.LevelWarn, msg, args...)
+}
+
+// ErrorContext logs at error level with the correlation ID and trace of ctx
+func ErrorContext(ctx context.Context, msg string, args ...any) {
+	slog.Default().Log(ctx, slog.LevelError, msg, args...)
 }
 
 // LogRequest logs HTTP request details
-func LogRequest(method, path string, statusCode int, duration time.Duration) {
-	Info("%s %s - %d - %v", method, path, statusCode, duration)
+func LogRequest(ctx context.Context, method, path string, statusCode int, duration time.Duration) {
+	logRequest(ctx, slog.LevelInfo, method, path, statusCode, duration)
+}
+
+func logRequest(ctx context.Context, lvl slog.Level, method, path string, statusCode int, duration time.Duration) {
+	slog.Default().LogAttrs(ctx, lvl, "request",
+		slog.String("method", method),
+		slog.String("path", path),
+		slog.Int("status", statusCode),
+		slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+	)
 }
 
 // LogError logs errors with context
 func LogError(err error, context string) {
-	Error("%s: %v", context, err)
+	Error(context, "error", err)
 }
 
 // LogDatabase logs database operations
 func LogDatabase(operation string, err error) {
 	if err != nil {
-		Error("Database %s failed: %v", operation, err)
+		Error("Database operation failed", "operation", operation, "error", err)
 	} else {
-		Debug("Database %s succeeded", operation)
+		Debug("Database operation succeeded", "operation", operation)
 	}
 }
-
-// SetOutput sets the log output destination
-func SetOutput(file *os.File) {
-	log.SetOutput(file)
-}
-
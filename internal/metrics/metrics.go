@@ -0,0 +1,279 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"flowhook/internal/config"
+	"flowhook/internal/db"
+	"flowhook/internal/recorder"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds the FlowHook metrics served on /metrics, with Go runtime
+// and process metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	captures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flowhook_captures_total",
+		Help: "Webhooks received on capture URLs, by endpoint and response status.",
+	}, []string{"endpoint", "status"})
+
+	captureDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "flowhook_capture_duration_seconds",
+		Help:    "Time to handle a capture, including the primary rule's response in proxy mode.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	captureBodyBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "flowhook_capture_body_bytes",
+		Help:    "Body size of captured webhooks.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10), // 64B to 16MB
+	})
+
+	forwardAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flowhook_forward_attempts_total",
+		Help: "Forward attempts by rule and outcome: success, failed (error response) or error (no response).",
+	}, []string{"rule", "outcome"})
+
+	forwardDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "flowhook_forward_duration_seconds",
+		Help:    "Delivery latency of forward attempts, by outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	forwardRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flowhook_forward_retries_total",
+		Help: "Forward attempts made after a failed first attempt, by rule.",
+	}, []string{"rule"})
+
+	transformDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "flowhook_transformation_duration_seconds",
+		Help:    "Execution time of transformation scripts, by language.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"language"})
+
+	transformErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flowhook_transformation_errors_total",
+		Help: "Transformation scripts that failed, by language.",
+	}, []string{"language"})
+
+	rateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flowhook_rate_limit_rejections_total",
+		Help: "Captures rejected by an endpoint's rate limits.",
+	}, []string{"endpoint"})
+
+	sseConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "flowhook_sse_connections",
+		Help: "Open realtime (SSE) connections.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		captures,
+		captureDuration,
+		captureBodyBytes,
+		forwardAttempts,
+		forwardDuration,
+		forwardRetries,
+		transformDuration,
+		transformErrors,
+		rateLimitRejections,
+		sseConnections,
+		poolCollector{},
+	)
+}
+
+// Handler serves the registry in the Prometheus text format, or OpenMetrics
+// when the scraper asks for it
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// labelValues caps the distinct values reported for a label. Values seen
+// once the cap is reached are reported as "other".
+type labelValues struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+var (
+	endpointLabels = &labelValues{seen: make(map[string]struct{})}
+	ruleLabels     = &labelValues{seen: make(map[string]struct{})}
+)
+
+func (l *labelValues) get(value string) string {
+	max := 100
+	if config.AppConfig != nil {
+		max = config.AppConfig.MetricsMaxLabelValues
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.seen[value]; ok {
+		return value
+	}
+	if max > 0 && len(l.seen) >= max {
+		return "other"
+	}
+	l.seen[value] = struct{}{}
+	return value
+}
+
+// labelMode returns METRICS_ENDPOINT_LABEL: slug, id or none
+func labelMode() string {
+	if config.AppConfig == nil {
+		return "slug"
+	}
+	return config.AppConfig.MetricsEndpointLabel
+}
+
+// EndpointLabel returns the endpoint label value of an endpoint: its slug or
+// ID, or empty when endpoint labels are disabled
+func EndpointLabel(slug string, id uuid.UUID) string {
+	switch labelMode() {
+	case "none":
+		return ""
+	case "id":
+		return endpointLabels.get(id.String())
+	default:
+		return endpointLabels.get(slug)
+	}
+}
+
+// RuleLabel returns the rule label value of a forwarding rule, empty when
+// endpoint labels are disabled
+func RuleLabel(id uuid.UUID) string {
+	if labelMode() == "none" {
+		return ""
+	}
+	return ruleLabels.get(id.String())
+}
+
+// captureKey is the context key of the capture being instrumented
+type captureKey struct{}
+
+// captureInfo is what the capture handler reports about a capture
+type captureInfo struct {
+	endpoint string
+}
+
+// InstrumentCapture counts the captures handled by next, by endpoint and
+// status, and times them. Captures for unknown endpoints are labelled
+// "unknown".
+func InstrumentCapture(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &captureInfo{endpoint: "unknown"}
+		rec := recorder.New(w)
+
+		next(rec, r.WithContext(context.WithValue(r.Context(), captureKey{}, info)))
+
+		captures.WithLabelValues(info.endpoint, strconv.Itoa(rec.Status)).Inc()
+		captureDuration.Observe(time.Since(start).Seconds())
+	}
+}
+
+// SetCaptureEndpoint labels the capture handled on ctx with its endpoint
+func SetCaptureEndpoint(ctx context.Context, endpoint string) {
+	if info, ok := ctx.Value(captureKey{}).(*captureInfo); ok {
+		info.endpoint = endpoint
+	}
+}
+
+// ObserveCaptureBody records the body size of a captured webhook
+func ObserveCaptureBody(size int) {
+	captureBodyBytes.Observe(float64(size))
+}
+
+// ObserveForwardAttempt records a forward attempt of a rule. Attempts after
+// the first are also counted as retries.
+func ObserveForwardAttempt(rule string, attemptNumber int, outcome string, duration time.Duration) {
+	forwardAttempts.WithLabelValues(rule, outcome).Inc()
+	forwardDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+	if attemptNumber > 1 {
+		forwardRetries.WithLabelValues(rule).Inc()
+	}
+}
+
+// ObserveTransformation records a transformation script run
+func ObserveTransformation(language string, duration time.Duration, err error) {
+	switch language = strings.ToLower(language); language {
+	case "javascript", "js":
+		language = "javascript"
+	case "jq", "jsonata":
+	default:
+		language = "other"
+	}
+
+	transformDuration.WithLabelValues(language).Observe(duration.Seconds())
+	if err != nil {
+		transformErrors.WithLabelValues(language).Inc()
+	}
+}
+
+// RateLimitRejected counts a capture rejected by an endpoint's rate limits
+func RateLimitRejected(endpoint string) {
+	rateLimitRejections.WithLabelValues(endpoint).Inc()
+}
+
+// SSEConnected counts an opened realtime connection
+func SSEConnected() {
+	sseConnections.Inc()
+}
+
+// SSEDisconnected counts a closed realtime connection
+func SSEDisconnected() {
+	sseConnections.Dec()
+}
+
+// poolCollector reports the database connection pool statistics at scrape time
+type poolCollector struct{}
+
+var (
+	poolAcquiredDesc = prometheus.NewDesc("flowhook_db_pool_acquired_connections", "Connections currently in use.", nil, nil)
+	poolIdleDesc     = prometheus.NewDesc("flowhook_db_pool_idle_connections", "Idle connections.", nil, nil)
+	poolTotalDesc    = prometheus.NewDesc("flowhook_db_pool_total_connections", "Open connections, including ones being established.", nil, nil)
+	poolMaxDesc      = prometheus.NewDesc("flowhook_db_pool_max_connections", "Maximum size of the pool.", nil, nil)
+	poolAcquiresDesc = prometheus.NewDesc("flowhook_db_pool_acquires_total", "Connections acquired from the pool.", nil, nil)
+	poolEmptyDesc    = prometheus.NewDesc("flowhook_db_pool_empty_acquires_total", "Acquires that had to wait for a connection.", nil, nil)
+	poolWaitDesc     = prometheus.NewDesc("flowhook_db_pool_acquire_wait_seconds_total", "Time spent acquiring connections.", nil, nil)
+)
+
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredDesc
+	ch <- poolIdleDesc
+	ch <- poolTotalDesc
+	ch <- poolMaxDesc
+	ch <- poolAcquiresDesc
+	ch <- poolEmptyDesc
+	ch <- poolWaitDesc
+}
+
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	if db.Pool == nil {
+		return
+	}
+	stat := db.Pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric(poolAcquiredDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleDesc, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalDesc, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaitDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
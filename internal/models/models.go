@@ -18,6 +18,7 @@ type Request struct {
 	EndpointID  uuid.UUID              `json:"endpoint_id"`
 	Method      string                 `json:"method"`
 	Path        *string                `json:"path,omitempty"`
+	Subpath     *string                `json:"subpath,omitempty"` // Path after /e/:slug
 	Headers     map[string]interface{} `json:"headers"`
 	QueryParams map[string]interface{} `json:"query_params"`
 	IP          *string                 `json:"ip,omitempty"`
@@ -26,6 +27,7 @@ type Request struct {
 	BodySize    int64                  `json:"body_size"`
 	ContentType *string                 `json:"content_type,omitempty"`
 	ReceivedAt  time.Time              `json:"received_at"`
+	CorrelationID *string              `json:"correlation_id,omitempty"` // X-Request-ID of the capture
 }
 
 type CreateEndpointRequest struct {
@@ -39,10 +41,13 @@ type CreateEndpointResponse struct {
 }
 
 type RequestListResponse struct {
-	Requests []Request `json:"requests"`
-	Total    int       `json:"total"`
-	Limit    int       `json:"limit"`
-	Offset   int       `json:"offset"`
+	Requests         []Request `json:"requests"`
+	Total            *int      `json:"total,omitempty"`
+	TotalApproximate bool      `json:"total_approximate,omitempty"`
+	Limit            int       `json:"limit"`
+	Offset           int       `json:"offset"`
+	NextCursor       *string   `json:"next_cursor"`
+	PrevCursor       *string   `json:"prev_cursor"`
 }
 
 type Replay struct {
@@ -57,7 +62,14 @@ type Replay struct {
 	ResponseStatus *int                    `json:"response_status,omitempty"`
 	ResponseHeaders map[string]interface{} `json:"response_headers,omitempty"`
 	ResponseBody   *string                 `json:"response_body,omitempty"`
+	TransformedResponseBody *string        `json:"transformed_response_body,omitempty"`
 	ErrorMessage   *string                 `json:"error_message,omitempty"`
+	MaxRetries     int                    `json:"max_retries"`
+	BackoffConfig  map[string]interface{} `json:"backoff_config,omitempty"`
+	TimeoutMs      int                    `json:"timeout_ms"`
+	FollowRedirects bool                  `json:"follow_redirects"`
+	SourceReplayID *uuid.UUID             `json:"source_replay_id,omitempty"` // Set when re-run from another replay
+	TransportRuleID *uuid.UUID            `json:"transport_rule_id,omitempty"` // Forwarding rule whose transport is used
 	LastAttemptAt  *time.Time              `json:"last_attempt_at,omitempty"`
 	CreatedAt      time.Time               `json:"created_at"`
 }
@@ -67,6 +79,25 @@ type CreateReplayRequest struct {
 	Method    *string                `json:"method,omitempty"` // Optional, defaults to original method
 	Headers   map[string]interface{} `json:"headers,omitempty"` // Optional, defaults to original headers
 	Body      *string                `json:"body,omitempty"` // Optional, defaults to original body
+	MaxRetries      *int                   `json:"max_retries,omitempty"` // Total attempts, defaults to 1
+	BackoffConfig   map[string]interface{} `json:"backoff_config,omitempty"` // Same format as forwarding rules
+	TimeoutMs       *int                   `json:"timeout_ms,omitempty"` // Per attempt, defaults to 30000
+	FollowRedirects *bool                  `json:"follow_redirects,omitempty"` // Defaults to true
+	TransportRuleID *uuid.UUID             `json:"transport_rule_id,omitempty"` // Use this forwarding rule's transport configuration
+}
+
+type ReplayAttempt struct {
+	ID              uuid.UUID              `json:"id"`
+	ReplayID        uuid.UUID              `json:"replay_id"`
+	AttemptNumber   int                    `json:"attempt_number"`
+	Status          string                 `json:"status"`
+	ResponseStatus  *int                    `json:"response_status,omitempty"`
+	ResponseHeaders map[string]interface{} `json:"response_headers,omitempty"`
+	ResponseBody    *string                 `json:"response_body,omitempty"`
+	TransformedResponseBody *string         `json:"transformed_response_body,omitempty"`
+	ErrorMessage    *string                 `json:"error_message,omitempty"`
+	DurationMs      *int                    `json:"duration_ms,omitempty"`
+	AttemptedAt     time.Time               `json:"attempted_at"`
 }
 
 type CreateReplayResponse struct {
@@ -85,6 +116,11 @@ type ForwardingRule struct {
 	BackoffConfig  map[string]interface{} `json:"backoff_config"`
 	ConditionType  *string                 `json:"condition_type,omitempty"`
 	ConditionConfig map[string]interface{} `json:"condition_config,omitempty"`
+	AppendSubpath  bool                   `json:"append_subpath"` // Append captured subpath and query to target_url
+	ShadowTargetURL *string               `json:"shadow_target_url,omitempty"` // Mirror target; its responses are only compared
+	ShadowIgnorePaths []string            `json:"shadow_ignore_paths,omitempty"` // Diff paths ignored when comparing
+	Transport      *TransportConfig       `json:"transport,omitempty"`
+	Auth           *AuthConfig            `json:"auth,omitempty"`
 	CreatedAt      time.Time               `json:"created_at"`
 	UpdatedAt      time.Time               `json:"updated_at"`
 }
@@ -97,6 +133,47 @@ type CreateForwardingRuleRequest struct {
 	BackoffConfig  map[string]interface{} `json:"backoff_config,omitempty"`
 	ConditionType  *string                 `json:"condition_type,omitempty"`
 	ConditionConfig map[string]interface{} `json:"condition_config,omitempty"`
+	AppendSubpath  bool                   `json:"append_subpath,omitempty"`
+	ShadowTargetURL *string               `json:"shadow_target_url,omitempty"`
+	ShadowIgnorePaths []string            `json:"shadow_ignore_paths,omitempty"`
+	Transport      *TransportConfig       `json:"transport,omitempty"`
+	Auth           *AuthConfig            `json:"auth,omitempty"`
+}
+
+// TransportConfig customizes the connection to a rule's downstream
+type TransportConfig struct {
+	TimeoutMs          int    `json:"timeout_ms,omitempty"` // Defaults to 30000
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	CACertPEM          string `json:"ca_cert_pem,omitempty"` // Trusted in addition to the system roots
+	ClientCertPEM      string `json:"client_cert_pem,omitempty"`
+	ClientKeyPEM       string `json:"client_key_pem,omitempty"` // Write-only; stored encrypted
+	HasClientKey       bool   `json:"has_client_key,omitempty"`
+	ProxyURL           string `json:"proxy_url,omitempty"` // Password is redacted in responses
+	DisableHTTP2       bool   `json:"disable_http2,omitempty"`
+	ClientKeyEncrypted string `json:"-"`
+}
+
+// Rule authentication types
+const (
+	AuthTypeBasic                   = "basic"
+	AuthTypeBearer                  = "bearer"
+	AuthTypeOAuth2ClientCredentials = "oauth2_client_credentials"
+)
+
+// AuthConfig authenticates forwarded requests to a rule's downstream. Each
+// type has one secret (password, token or client_secret), which is write-only.
+type AuthConfig struct {
+	Type            string   `json:"type"` // basic|bearer|oauth2_client_credentials
+	Username        string   `json:"username,omitempty"`
+	Password        string   `json:"password,omitempty"`
+	Token           string   `json:"token,omitempty"`
+	TokenURL        string   `json:"token_url,omitempty"`
+	ClientID        string   `json:"client_id,omitempty"`
+	ClientSecret    string   `json:"client_secret,omitempty"`
+	Scopes          []string `json:"scopes,omitempty"`
+	Audience        string   `json:"audience,omitempty"`
+	HasSecret       bool     `json:"has_secret,omitempty"`
+	SecretEncrypted string   `json:"-"`
 }
 
 type ForwardAttempt struct {
@@ -108,9 +185,11 @@ type ForwardAttempt struct {
 	ResponseStatus  *int                    `json:"response_status,omitempty"`
 	ResponseHeaders map[string]interface{} `json:"response_headers,omitempty"`
 	ResponseBody    *string                 `json:"response_body,omitempty"`
+	TransformedResponseBody *string         `json:"transformed_response_body,omitempty"`
 	ErrorMessage    *string                 `json:"error_message,omitempty"`
 	DurationMs      *int                    `json:"duration_ms,omitempty"`
 	AttemptedAt     time.Time               `json:"attempted_at"`
+	CorrelationID   *string                 `json:"correlation_id,omitempty"` // Request or job run that made the attempt
 }
 
 type Transformation struct {
@@ -140,15 +219,65 @@ type RetentionPolicy struct {
 	AutoDelete    bool      `json:"auto_delete"`
 	ArchiveEnabled bool      `json:"archive_enabled"`
 	ArchivePath   *string   `json:"archive_path,omitempty"`
+	MaxRequests   *int      `json:"max_requests,omitempty"` // Keep at most this many of the newest requests
+	MaxBytes      *int64    `json:"max_bytes,omitempty"`    // Keep at most this many bytes of the newest bodies
+	KeepForever   bool      `json:"keep_forever"`           // Never delete, regardless of the other limits
+	IsDefault     bool      `json:"is_default"`             // No policy is set; the global default applies
+	Usage         *RetentionUsage `json:"usage,omitempty"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
 
+// RetentionUsage is an endpoint's current usage against its retention limits
+type RetentionUsage struct {
+	RequestCount     int64      `json:"request_count"`
+	TotalBytes       int64      `json:"total_bytes"`
+	OldestReceivedAt *time.Time `json:"oldest_received_at,omitempty"`
+}
+
 type CreateRetentionPolicyRequest struct {
 	RetentionDays *int    `json:"retention_days,omitempty"`
 	AutoDelete    *bool   `json:"auto_delete,omitempty"`
 	ArchiveEnabled *bool   `json:"archive_enabled,omitempty"`
 	ArchivePath   *string `json:"archive_path,omitempty"`
+	MaxRequests   *int    `json:"max_requests,omitempty"` // 0 removes the limit
+	MaxBytes      *int64  `json:"max_bytes,omitempty"`    // 0 removes the limit
+	KeepForever   *bool   `json:"keep_forever,omitempty"`
+}
+
+// RequestArchive is a file of expired requests written by the retention worker
+type RequestArchive struct {
+	ID               uuid.UUID  `json:"id"`
+	EndpointID       uuid.UUID  `json:"endpoint_id"`
+	FilePath         string     `json:"file_path"`
+	RequestCount     int        `json:"request_count"`
+	OldestReceivedAt *time.Time `json:"oldest_received_at,omitempty"`
+	NewestReceivedAt *time.Time `json:"newest_received_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	RestoredAt       *time.Time `json:"restored_at,omitempty"`
+}
+
+// RedactionPolicy removes sensitive data from requests before they are stored
+type RedactionPolicy struct {
+	ID                uuid.UUID `json:"id"`
+	EndpointID        uuid.UUID `json:"endpoint_id"`
+	MaskHeaders       []string  `json:"mask_headers"`       // Header names whose values are masked
+	HashPaths         []string  `json:"hash_paths"`         // JSON body paths replaced by a SHA-256 hash
+	DropPaths         []string  `json:"drop_paths"`         // JSON body paths removed
+	Detectors         []string  `json:"detectors"`          // email|card_number|token
+	Patterns          []string  `json:"patterns"`           // Additional regular expressions to mask
+	ForwardUnredacted bool      `json:"forward_unredacted"` // Forward the original request; only storage is redacted
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+type UpdateRedactionPolicyRequest struct {
+	MaskHeaders       []string `json:"mask_headers"`
+	HashPaths         []string `json:"hash_paths"`
+	DropPaths         []string `json:"drop_paths"`
+	Detectors         []string `json:"detectors"`
+	Patterns          []string `json:"patterns"`
+	ForwardUnredacted bool     `json:"forward_unredacted"`
 }
 
 type RequestTemplate struct {
@@ -160,6 +289,7 @@ type RequestTemplate struct {
 	Headers     map[string]interface{} `json:"headers"`
 	Body        *string                `json:"body,omitempty"`
 	Description *string                `json:"description,omitempty"`
+	TransportRuleID *uuid.UUID         `json:"transport_rule_id,omitempty"` // Forwarding rule whose transport is used
 	CreatedAt   time.Time              `json:"created_at"`
 	UpdatedAt   time.Time              `json:"updated_at"`
 }
@@ -171,6 +301,7 @@ type CreateRequestTemplateRequest struct {
 	Headers     map[string]interface{} `json:"headers,omitempty"`
 	Body        *string                 `json:"body,omitempty"`
 	Description *string                `json:"description,omitempty"`
+	TransportRuleID *uuid.UUID         `json:"transport_rule_id,omitempty"`
 }
 
 type User struct {
@@ -219,3 +350,68 @@ type CreateAPIKeyResponse struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+
+type ExportJob struct {
+	ID           uuid.UUID              `json:"id"`
+	EndpointID   uuid.UUID              `json:"endpoint_id"`
+	Format       string                 `json:"format"` // ndjson|csv|har|postman
+	Filter       map[string]interface{} `json:"filter"`
+	Status       string                 `json:"status"` // pending|running|completed|failed
+	RequestCount int                    `json:"request_count"`
+	FileSize     *int64                 `json:"file_size,omitempty"`
+	ErrorMessage *string                `json:"error_message,omitempty"`
+	DownloadURL  *string                `json:"download_url,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+	StartedAt    *time.Time             `json:"started_at,omitempty"`
+	CompletedAt  *time.Time             `json:"completed_at,omitempty"`
+	ExpiresAt    *time.Time             `json:"expires_at,omitempty"`
+}
+
+type CreateExportJobRequest struct {
+	Format string `json:"format"`
+	Method string `json:"method,omitempty"`
+	Search string `json:"search,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Query  string `json:"q,omitempty"`
+}
+
+type BulkReplay struct {
+	ID               uuid.UUID              `json:"id"`
+	EndpointID       uuid.UUID              `json:"endpoint_id"`
+	Filter           map[string]interface{} `json:"filter"`
+	TargetURL        *string                `json:"target_url,omitempty"`
+	ForwardingRuleID *uuid.UUID             `json:"forwarding_rule_id,omitempty"`
+	RatePerSecond    float64                `json:"rate_per_second"` // 0 = unlimited
+	Concurrency      int                    `json:"concurrency"`
+	Order            string                 `json:"order"` // oldest_first|newest_first
+	SnapshotAt       time.Time              `json:"snapshot_at"`
+	Status           string                 `json:"status"` // pending|running|paused|completed|cancelled|failed
+	Total            int                    `json:"total"`
+	Processed        int                    `json:"processed"`
+	Succeeded        int                    `json:"succeeded"`
+	Failed           int                    `json:"failed"`
+	ErrorMessage     *string                `json:"error_message,omitempty"`
+	CreatedAt        time.Time              `json:"created_at"`
+	StartedAt        *time.Time             `json:"started_at,omitempty"`
+	CompletedAt      *time.Time             `json:"completed_at,omitempty"`
+}
+
+type CreateBulkReplayRequest struct {
+	// Filter, as for listing requests
+	Method        string `json:"method,omitempty"`
+	Search        string `json:"search,omitempty"`
+	From          string `json:"from,omitempty"`
+	To            string `json:"to,omitempty"`
+	Query         string `json:"q,omitempty"`
+	ForwardFailed bool   `json:"forward_failed,omitempty"`
+	ForwardRuleID string `json:"forward_rule_id,omitempty"`
+
+	// Target: exactly one of target_url or forwarding_rule_id
+	TargetURL        string     `json:"target_url,omitempty"`
+	ForwardingRuleID *uuid.UUID `json:"forwarding_rule_id,omitempty"`
+
+	RatePerSecond float64 `json:"rate_per_second,omitempty"`
+	Concurrency   int     `json:"concurrency,omitempty"`
+	Order         string  `json:"order,omitempty"`
+}
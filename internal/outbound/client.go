@@ -0,0 +1,83 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxRedirects matches the net/http default
+const maxRedirects = 10
+
+var (
+	transportOnce   sync.Once
+	sharedTransport *http.Transport
+)
+
+// Client returns an HTTP client for user-supplied URLs. Every connection,
+// including each redirect, is checked against DefaultPolicy after DNS
+// resolution, so names that resolve or rebind to internal addresses are refused.
+func Client(timeout time.Duration, followRedirects bool) *http.Client {
+	policy := DefaultPolicy()
+	transportOnce.Do(func() {
+		sharedTransport = NewTransport(policy)
+	})
+
+	return &http.Client{
+		Transport:     sharedTransport,
+		Timeout:       timeout,
+		CheckRedirect: policy.checkRedirect(followRedirects),
+	}
+}
+
+// NewTransport returns a transport whose dialer enforces policy
+func NewTransport(policy *Policy) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Proxies would hide the real destination from the dialer checks
+	transport.Proxy = nil
+	transport.DialContext = policy.DialContext
+	return transport
+}
+
+// DialContext dials like net.Dialer, refusing blocked addresses. The check runs
+// on the resolved address of each connection attempt, not on the host name.
+func (p *Policy) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if !p.AllowPrivate && !p.hostAllowed(host) {
+		dialer.Control = func(network, resolved string, _ syscall.RawConn) error {
+			return p.checkDialAddr(host, resolved)
+		}
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// checkRedirect re-checks the scheme of every redirect target; addresses are
+// checked again when the redirect is dialed
+func (p *Policy) checkRedirect(followRedirects bool) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if !followRedirects {
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= maxRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+		return p.CheckURL(req.URL.String())
+	}
+}
+
+// CheckURL validates rawURL against DefaultPolicy
+func CheckURL(rawURL string) error {
+	return DefaultPolicy().CheckURL(rawURL)
+}
@@ -0,0 +1,206 @@
+package outbound
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"flowhook/internal/config"
+)
+
+// Policy decides which destinations outbound requests may reach
+type Policy struct {
+	AllowedSchemes []string
+	AllowPrivate   bool           // Skip the address checks entirely
+	AllowedHosts   []string       // Host names or path.Match patterns, e.g. "*.corp.internal"
+	AllowedRanges  []netip.Prefix // Addresses reachable even if private
+}
+
+// BlockedError is returned when a destination is not allowed
+type BlockedError struct {
+	Target string
+	Reason string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("outbound request to %s blocked: %s", e.Target, e.Reason)
+}
+
+// blockedRanges are non-public ranges not covered by the netip predicates
+var blockedRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "This" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // Reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),  // Documentation
+}
+
+var (
+	policyOnce    sync.Once
+	currentPolicy *Policy
+)
+
+// DefaultPolicy returns the policy built from the OUTBOUND_* settings
+func DefaultPolicy() *Policy {
+	policyOnce.Do(func() {
+		currentPolicy = &Policy{AllowedSchemes: []string{"http", "https"}}
+		if config.AppConfig == nil {
+			return
+		}
+		if len(config.AppConfig.OutboundAllowedSchemes) > 0 {
+			currentPolicy.AllowedSchemes = config.AppConfig.OutboundAllowedSchemes
+		}
+		currentPolicy.AllowPrivate = config.AppConfig.OutboundAllowPrivate
+		for _, entry := range config.AppConfig.OutboundAllowlist {
+			currentPolicy.allow(entry)
+		}
+	})
+	return currentPolicy
+}
+
+// allow adds an allow-list entry: a CIDR, an IP or a host name pattern
+func (p *Policy) allow(entry string) {
+	if prefix, err := netip.ParsePrefix(entry); err == nil {
+		p.AllowedRanges = append(p.AllowedRanges, prefix.Masked())
+		return
+	}
+	if addr, err := netip.ParseAddr(entry); err == nil {
+		p.AllowedRanges = append(p.AllowedRanges, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		return
+	}
+	p.AllowedHosts = append(p.AllowedHosts, strings.ToLower(entry))
+}
+
+// CheckURL validates a target URL's scheme and, for IP literals and localhost,
+// its address. Host names are checked again after resolution when dialing.
+func (p *Policy) CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	if err := p.checkScheme(u); err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("URL must have a host")
+	}
+	if p.AllowPrivate || p.hostAllowed(host) {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.checkAddr(host, addr)
+	}
+	if host = strings.ToLower(strings.TrimSuffix(host, ".")); host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return &BlockedError{Target: host, Reason: "loopback address"}
+	}
+	return nil
+}
+
+func (p *Policy) checkScheme(u *url.URL) error {
+	for _, scheme := range p.AllowedSchemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return nil
+		}
+	}
+	return &BlockedError{Target: u.Redacted(), Reason: fmt.Sprintf("scheme %q is not allowed", u.Scheme)}
+}
+
+// hostAllowed reports whether host matches an allow-listed name
+func (p *Policy) hostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.AllowedHosts {
+		if matched, _ := path.Match(pattern, host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAddr blocks loopback, private, link-local and other non-public addresses
+// unless they are allow-listed
+func (p *Policy) checkAddr(target string, addr netip.Addr) error {
+	if p.AllowPrivate {
+		return nil
+	}
+	addr = addr.Unmap()
+	reason := p.blockedReason(addr)
+	if reason == "" {
+		return nil
+	}
+	if target != addr.String() {
+		target = fmt.Sprintf("%s (%s)", target, addr)
+	}
+	return &BlockedError{Target: target, Reason: reason}
+}
+
+// blockedReason says why addr is blocked, or returns "" when it is allowed.
+// NAT64 and 6to4 addresses are judged by the IPv4 address they embed.
+func (p *Policy) blockedReason(addr netip.Addr) string {
+	for _, prefix := range p.AllowedRanges {
+		if prefix.Contains(addr) {
+			return ""
+		}
+	}
+
+	switch {
+	case addr.IsLoopback():
+		return "loopback address"
+	case addr.IsPrivate():
+		return "private address"
+	case addr.IsLinkLocalUnicast(), addr.IsLinkLocalMulticast():
+		return "link-local address"
+	case addr.IsUnspecified():
+		return "unspecified address"
+	case addr.IsMulticast(), addr.IsInterfaceLocalMulticast():
+		return "multicast address"
+	}
+	for _, prefix := range blockedRanges {
+		if prefix.Contains(addr) {
+			return "reserved address"
+		}
+	}
+	if embedded, kind, ok := embeddedIPv4(addr); ok {
+		if reason := p.blockedReason(embedded); reason != "" {
+			return fmt.Sprintf("%s %s embedded in %s address", reason, embedded, kind)
+		}
+	}
+	return ""
+}
+
+// Prefixes of IPv6 addresses that embed an IPv4 address
+var (
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96") // Well-known NAT64, RFC 6052
+	sixToFour   = netip.MustParsePrefix("2002::/16")    // 6to4, RFC 3056
+)
+
+// embeddedIPv4 returns the IPv4 address a NAT64 or 6to4 address reaches
+func embeddedIPv4(addr netip.Addr) (netip.Addr, string, bool) {
+	b := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]}), "NAT64", true
+	case sixToFour.Contains(addr):
+		return netip.AddrFrom4([4]byte{b[2], b[3], b[4], b[5]}), "6to4", true
+	}
+	return netip.Addr{}, "", false
+}
+
+// checkDialAddr checks the resolved "ip:port" a connection is about to use
+func (p *Policy) checkDialAddr(host, address string) error {
+	ipStr, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	return p.checkAddr(host, addr)
+}
@@ -0,0 +1,205 @@
+package outbound
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// TransportOptions customize connections to a downstream
+type TransportOptions struct {
+	InsecureSkipVerify bool
+	CACertPEM          string // Trusted in addition to the system roots
+	ClientCertPEM      string
+	ClientKeyPEM       string
+	ProxyURL           string // http, https or socks5
+	DisableHTTP2       bool
+}
+
+// Validate checks that the certificates, key and proxy URL can be used
+func (o *TransportOptions) Validate() error {
+	_, err := o.tlsConfig()
+	if err != nil {
+		return err
+	}
+	_, err = o.proxy()
+	return err
+}
+
+func (o *TransportOptions) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+
+	if o.CACertPEM != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(o.CACertPEM)) {
+			return nil, errors.New("ca_cert_pem contains no valid certificates")
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.ClientCertPEM != "" || o.ClientKeyPEM != "" {
+		cert, err := tls.X509KeyPair([]byte(o.ClientCertPEM), []byte(o.ClientKeyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate or key: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func (o *TransportOptions) proxy() (*url.URL, error) {
+	if o.ProxyURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(o.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.New("proxy_url must use http, https or socks5")
+	}
+	if u.Host == "" {
+		return nil, errors.New("proxy_url must have a host")
+	}
+	return u, nil
+}
+
+// newTransport builds a policy-enforcing transport with opts applied
+func (p *Policy) newTransport(opts *TransportOptions) (*http.Transport, error) {
+	transport := NewTransport(p)
+
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if opts.DisableHTTP2 {
+		// A non-nil empty map turns off the built-in HTTP/2 support
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	} else {
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	proxyURL, err := opts.proxy()
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		// The dialer only sees the proxy's address, so the target is resolved
+		// and checked here instead
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if err := p.checkResolved(req.Context(), req.URL.Hostname()); err != nil {
+				return nil, err
+			}
+			return proxyURL, nil
+		}
+	}
+	return transport, nil
+}
+
+// checkResolved resolves host and checks every address it resolves to
+func (p *Policy) checkResolved(ctx context.Context, host string) error {
+	if p.AllowPrivate || p.hostAllowed(host) {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.checkAddr(host, addr)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := p.checkAddr(host, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transportIdleTimeout drops cached transports unused for this long, such as
+// those of rules deleted through another replica
+const transportIdleTimeout = time.Hour
+
+// cachedTransport is a configured transport and the configuration version it was built from
+type cachedTransport struct {
+	version   string
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+var (
+	transportsMu      sync.Mutex
+	transports        = map[string]*cachedTransport{}
+	transportsSweptAt time.Time
+)
+
+// ClientWith returns a client using opts. Transports are cached under key, so
+// connections are reused, and rebuilt when version changes.
+func ClientWith(key, version string, opts *TransportOptions, timeout time.Duration, followRedirects bool) (*http.Client, error) {
+	if opts == nil {
+		return Client(timeout, followRedirects), nil
+	}
+
+	policy := DefaultPolicy()
+
+	now := time.Now()
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	if now.Sub(transportsSweptAt) > transportIdleTimeout {
+		for k, cached := range transports {
+			if now.Sub(cached.lastUsed) > transportIdleTimeout {
+				cached.transport.CloseIdleConnections()
+				delete(transports, k)
+			}
+		}
+		transportsSweptAt = now
+	}
+
+	cached, ok := transports[key]
+	if !ok || cached.version != version {
+		transport, err := policy.newTransport(opts)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			cached.transport.CloseIdleConnections()
+		}
+		cached = &cachedTransport{version: version, transport: transport}
+		transports[key] = cached
+	}
+	cached.lastUsed = now
+
+	return &http.Client{
+		Transport:     cached.transport,
+		Timeout:       timeout,
+		CheckRedirect: policy.checkRedirect(followRedirects),
+	}, nil
+}
+
+// ForgetTransport drops the transport cached under key, closing its idle connections
+func ForgetTransport(key string) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	if cached, ok := transports[key]; ok {
+		cached.transport.CloseIdleConnections()
+		delete(transports, key)
+	}
+}
@@ -0,0 +1,33 @@
+package recorder
+
+import "net/http"
+
+// StatusRecorder remembers the status code written through it
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+// New returns a recorder writing to w. Middlewares stacked on one request
+// share a single recorder: when w already is one, it is returned.
+func New(w http.ResponseWriter) *StatusRecorder {
+	if rec, ok := w.(*StatusRecorder); ok {
+		return rec
+	}
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(status int) {
+	r.Status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *StatusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,279 @@
+package redact
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"flowhook/internal/diff"
+	"flowhook/internal/models"
+)
+
+// Mask replaces masked header values
+const Mask = "[REDACTED]"
+
+// detector finds one kind of sensitive value in text
+type detector struct {
+	pattern *regexp.Regexp
+	label   string
+	valid   func(match string) bool // Optional check on each match
+}
+
+// detectors are the built-in kinds a policy can enable
+var detectors = map[string][]detector{
+	"email": {{
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+		label:   "email",
+	}},
+	"card_number": {{
+		pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		label:   "card_number",
+		valid:   luhnValid,
+	}},
+	"token": {
+		{pattern: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]+=*`), label: "token"},
+		{pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), label: "token"},        // JWT
+		{pattern: regexp.MustCompile(`\b(?:sk|pk|rk)_(?:live|test)_[A-Za-z0-9]{10,}\b`), label: "token"},            // Stripe
+		{pattern: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{30,}\b`), label: "token"},                             // GitHub
+		{pattern: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`), label: "token"},                           // Slack
+		{pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), label: "token"},                              // AWS access key
+		{pattern: regexp.MustCompile(`(?i)\b(?:api[_-]?key|access[_-]?token|secret)=[^\s&"']{8,}`), label: "token"}, // key=value pairs
+	},
+}
+
+// Detectors lists the detector names a policy accepts
+func Detectors() []string {
+	return []string{"card_number", "email", "token"}
+}
+
+// Redactor applies a compiled redaction policy
+type Redactor struct {
+	maskHeaders map[string]bool
+	hashPaths   [][]string
+	dropPaths   [][]string
+	detectors   []detector
+	hashKey     []byte
+}
+
+// New compiles policy, validating its detectors and patterns. Hash paths are
+// keyed with hashKey, which is required when the policy has any. It returns
+// nil when the policy redacts nothing.
+func New(policy models.RedactionPolicy, hashKey []byte) (*Redactor, error) {
+	r := &Redactor{maskHeaders: make(map[string]bool), hashKey: hashKey}
+	for _, name := range policy.MaskHeaders {
+		r.maskHeaders[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+	}
+	for _, p := range policy.HashPaths {
+		if !strings.HasPrefix(p, "$") {
+			return nil, fmt.Errorf("hash path %q must start with $", p)
+		}
+		r.hashPaths = append(r.hashPaths, diff.ParsePath(p))
+	}
+	if len(r.hashPaths) > 0 && len(hashKey) == 0 {
+		return nil, errors.New("hash paths require a hash key")
+	}
+	for _, p := range policy.DropPaths {
+		if !strings.HasPrefix(p, "$") || p == "$" {
+			return nil, fmt.Errorf("drop path %q must start with $ and name a field", p)
+		}
+		r.dropPaths = append(r.dropPaths, diff.ParsePath(p))
+	}
+	for _, name := range policy.Detectors {
+		found, ok := detectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown detector %q, must be one of: %s", name, strings.Join(Detectors(), ", "))
+		}
+		r.detectors = append(r.detectors, found...)
+	}
+	for _, p := range policy.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", p, err)
+		}
+		r.detectors = append(r.detectors, detector{pattern: re, label: "pattern"})
+	}
+
+	if len(r.maskHeaders) == 0 && len(r.hashPaths) == 0 && len(r.dropPaths) == 0 && len(r.detectors) == 0 {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// Headers returns a copy of header with masked headers replaced and
+// detected values redacted
+func (r *Redactor) Headers(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for name, values := range header {
+		copied := make([]string, len(values))
+		for i, v := range values {
+			if r.maskHeaders[http.CanonicalHeaderKey(name)] {
+				copied[i] = Mask
+			} else {
+				copied[i], _ = r.Text(v)
+			}
+		}
+		redacted[name] = copied
+	}
+	return redacted
+}
+
+// Query returns a copy of query with detected values redacted
+func (r *Redactor) Query(query url.Values) url.Values {
+	redacted := make(url.Values, len(query))
+	for name, values := range query {
+		copied := make([]string, len(values))
+		for i, v := range values {
+			copied[i], _ = r.Text(v)
+		}
+		redacted[name] = copied
+	}
+	return redacted
+}
+
+// Body redacts a request body. JSON bodies have paths hashed or dropped and
+// their string values scanned; other text bodies are scanned as a whole.
+// Binary bodies, and bodies with nothing to redact, are returned unchanged.
+func (r *Redactor) Body(body []byte) []byte {
+	if len(body) == 0 || !utf8.Valid(body) {
+		return body
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err == nil && !decoder.More() {
+		redacted, changed := r.value(data, []string{"$"})
+		if !changed {
+			return body
+		}
+		encoded, err := json.Marshal(redacted)
+		if err != nil {
+			return body
+		}
+		return encoded
+	}
+
+	text, changed := r.Text(string(body))
+	if !changed {
+		return body
+	}
+	return []byte(text)
+}
+
+// value redacts a decoded JSON value found at path
+func (r *Redactor) value(v interface{}, path []string) (interface{}, bool) {
+	for _, pattern := range r.hashPaths {
+		if diff.MatchPath(pattern, path) {
+			return r.hashValue(v), true
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		changed := false
+		for key, child := range val {
+			childPath := append(path[:len(path):len(path)], key)
+			if r.dropped(childPath) {
+				delete(val, key)
+				changed = true
+				continue
+			}
+			if redacted, ok := r.value(child, childPath); ok {
+				val[key] = redacted
+				changed = true
+			}
+		}
+		return val, changed
+	case []interface{}:
+		changed := false
+		kept := val[:0]
+		for i, child := range val {
+			childPath := append(path[:len(path):len(path)], fmt.Sprint(i))
+			if r.dropped(childPath) {
+				changed = true
+				continue
+			}
+			if redacted, ok := r.value(child, childPath); ok {
+				child = redacted
+				changed = true
+			}
+			kept = append(kept, child)
+		}
+		return kept, changed
+	case string:
+		return r.Text(val)
+	default:
+		return v, false
+	}
+}
+
+func (r *Redactor) dropped(path []string) bool {
+	for _, pattern := range r.dropPaths {
+		if diff.MatchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Text replaces detected values in s with "[REDACTED:<kind>]"
+func (r *Redactor) Text(s string) (string, bool) {
+	changed := false
+	for _, d := range r.detectors {
+		s = d.pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if d.valid != nil && !d.valid(match) {
+				return match
+			}
+			changed = true
+			return "[REDACTED:" + d.label + "]"
+		})
+	}
+	return s, changed
+}
+
+// hashValue replaces a value with the HMAC-SHA256 of its text, or of its JSON
+// encoding if it is not a string, so equal values stay comparable. Keying the
+// digest stops low-entropy values such as emails being recovered by hashing
+// guesses.
+func (r *Redactor) hashValue(v interface{}) string {
+	text, ok := v.(string)
+	if !ok {
+		encoded, _ := json.Marshal(v)
+		text = string(encoded)
+	}
+	mac := hmac.New(sha256.New, r.hashKey)
+	mac.Write([]byte(text))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum
+func luhnValid(s string) bool {
+	sum, digits := 0, 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+		double = !double
+	}
+	return digits >= 13 && sum%10 == 0
+}
@@ -0,0 +1,213 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"flowhook/internal/models"
+)
+
+// Match evaluates the query against a request in memory. It mirrors AppendSQL for
+// live streams, with free text approximated by case-insensitive substring matching.
+func (q *Query) Match(req models.Request) bool {
+	if q.IsEmpty() {
+		return true
+	}
+
+	for _, t := range q.Terms {
+		if t.matches(req) == t.Negate {
+			return false
+		}
+	}
+
+	if len(q.FreeText) > 0 {
+		headersJSON, _ := json.Marshal(req.Headers)
+		haystack := strings.ToLower(deref(req.Path) + " " + string(headersJSON) + " " + deref(req.Body))
+		for _, word := range q.FreeText {
+			negate := strings.HasPrefix(word, "-")
+			word = strings.ToLower(strings.Trim(strings.TrimPrefix(word, "-"), "\""))
+			if word == "" || strings.EqualFold(word, "or") {
+				continue
+			}
+			if strings.Contains(haystack, word) == negate {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (t Term) matches(req models.Request) bool {
+	switch t.Field {
+	case "method":
+		return strings.EqualFold(req.Method, t.Value)
+	case "path":
+		return matchLike(deref(req.Path), t.Value)
+	case "subpath":
+		return matchLike(deref(req.Subpath), t.Value)
+	case "content_type":
+		return matchLike(deref(req.ContentType), t.Value)
+	case "ip":
+		ip := net.ParseIP(deref(req.IP))
+		if ip == nil {
+			return false
+		}
+		if _, network, err := net.ParseCIDR(t.Value); err == nil {
+			return network.Contains(ip)
+		}
+		return ip.Equal(net.ParseIP(t.Value))
+	case "size":
+		size, _ := strconv.ParseInt(t.Value, 10, 64)
+		return compareFloat(float64(req.BodySize), t.Op, float64(size))
+	case "received":
+		ts, dateOnly, _ := parseTime(t.Value)
+		if dateOnly && t.Op == "=" {
+			return !req.ReceivedAt.Before(ts) && req.ReceivedAt.Before(ts.Add(24*time.Hour))
+		}
+		return compareFloat(float64(req.ReceivedAt.UnixNano()), t.Op, float64(ts.UnixNano()))
+	case "header":
+		return matchValues(req.Headers[t.Key], t.Value)
+	case "query":
+		return matchValues(req.QueryParams[t.Key], t.Value)
+	case "body":
+		var data interface{}
+		if req.Body == nil || json.Unmarshal([]byte(*req.Body), &data) != nil {
+			return false
+		}
+		for _, v := range lookup(data, strings.Split(t.Key, ".")) {
+			if compareJSON(v, t.Op, t.Value) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// lookup walks a dotted path, descending into every element of arrays (lax mode)
+func lookup(data interface{}, keys []string) []interface{} {
+	if arr, ok := data.([]interface{}); ok {
+		var out []interface{}
+		for _, item := range arr {
+			out = append(out, lookup(item, keys)...)
+		}
+		return out
+	}
+	if len(keys) == 0 {
+		return []interface{}{data}
+	}
+	obj, ok := data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	child, exists := obj[keys[0]]
+	if !exists {
+		return nil
+	}
+	return lookup(child, keys[1:])
+}
+
+func compareJSON(v interface{}, op, value string) bool {
+	if num, ok := v.(float64); ok {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return compareFloat(num, op, f)
+		}
+	}
+	str := fmt.Sprintf("%v", v)
+	if s, ok := v.(string); ok {
+		str = s
+	}
+	if v == nil {
+		str = "null"
+	}
+	switch op {
+	case "=":
+		return str == value
+	case ">":
+		return str > value
+	case ">=":
+		return str >= value
+	case "<":
+		return str < value
+	case "<=":
+		return str <= value
+	}
+	return false
+}
+
+func compareFloat(a float64, op string, b float64) bool {
+	switch op {
+	case "=":
+		return a == b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return false
+}
+
+// matchValues matches a header or query value stored as a string or list of strings.
+// Like the SQL containment check, values without wildcards compare case-sensitively.
+func matchValues(stored interface{}, value string) bool {
+	match := func(s string) bool {
+		if strings.Contains(value, "*") {
+			return matchLike(s, value)
+		}
+		return s == value
+	}
+
+	switch v := stored.(type) {
+	case string:
+		return match(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && match(s) {
+				return true
+			}
+		}
+	case []string:
+		for _, s := range v {
+			if match(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchLike matches a value with optional * wildcards
+func matchLike(s, pattern string) bool {
+	if !strings.Contains(pattern, "*") {
+		return strings.EqualFold(s, pattern)
+	}
+	// path.Match treats "/" specially, so match across it by replacing with a sentinel
+	const sep = "\x00"
+	matched, err := path.Match(
+		strings.ToLower(strings.ReplaceAll(escapeGlob(pattern), "/", sep)),
+		strings.ToLower(strings.ReplaceAll(s, "/", sep)),
+	)
+	return err == nil && matched
+}
+
+// escapeGlob escapes glob metacharacters other than *
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `?`, `\?`, `[`, `\[`).Replace(s)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,248 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a parsed request search expression such as
+//
+//	method:POST header.x-github-event:push body.data.object.status:failed received:>2026-01-01 timeout
+//
+// Field terms narrow on a single column, bare words are matched with full-text search.
+// Any term may be negated with a leading "-".
+type Query struct {
+	Terms    []Term
+	FreeText []string
+}
+
+// Term is a single field comparison
+type Term struct {
+	Field  string // method|path|subpath|ip|content_type|size|received|header|query|body
+	Key    string // Header name, query parameter or dotted body path
+	Op     string // = | > | >= | < | <=
+	Value  string
+	Negate bool
+}
+
+var simpleFields = map[string]bool{
+	"method":       true,
+	"path":         true,
+	"subpath":      true,
+	"ip":           true,
+	"content_type": true,
+	"size":         true,
+	"received":     true,
+}
+
+// Parse parses a search expression. An empty string yields an empty query.
+func Parse(input string) (*Query, error) {
+	q := &Query{}
+
+	for _, token := range tokenize(input) {
+		negate := false
+		raw := token
+		if strings.HasPrefix(token, "-") && len(token) > 1 {
+			negate = true
+			token = token[1:]
+		}
+
+		field, value, ok := splitField(token)
+		if !ok {
+			// Keep the raw token (including "-" and quotes) for websearch_to_tsquery
+			q.FreeText = append(q.FreeText, raw)
+			continue
+		}
+
+		term := Term{Negate: negate}
+		lower := strings.ToLower(field)
+		switch {
+		case simpleFields[lower]:
+			term.Field = lower
+		case strings.HasPrefix(lower, "header."):
+			term.Field = "header"
+			term.Key = textproto.CanonicalMIMEHeaderKey(field[len("header."):])
+		case strings.HasPrefix(lower, "query."):
+			term.Field = "query"
+			term.Key = field[len("query."):]
+		case strings.HasPrefix(lower, "body."):
+			term.Field = "body"
+			term.Key = field[len("body."):]
+		default:
+			return nil, fmt.Errorf("unknown search field %q", field)
+		}
+
+		term.Op, term.Value = splitOperator(value)
+		if term.Value == "" {
+			return nil, fmt.Errorf("missing value for %q", field)
+		}
+		if term.Key == "" && (term.Field == "header" || term.Field == "query" || term.Field == "body") {
+			return nil, fmt.Errorf("missing name for %q", field)
+		}
+		if term.Op != "=" && term.Field != "size" && term.Field != "received" && term.Field != "body" {
+			return nil, fmt.Errorf("operator %s is not supported for %s", term.Op, term.Field)
+		}
+		if err := term.validate(); err != nil {
+			return nil, err
+		}
+
+		q.Terms = append(q.Terms, term)
+	}
+
+	return q, nil
+}
+
+// IsEmpty reports whether the query has no terms
+func (q *Query) IsEmpty() bool {
+	return q == nil || (len(q.Terms) == 0 && len(q.FreeText) == 0)
+}
+
+// SearchesPayload reports whether the query matches request headers or bodies,
+// through header or body terms or full-text search
+func (q *Query) SearchesPayload() bool {
+	if q == nil {
+		return false
+	}
+	for _, t := range q.Terms {
+		if t.Field == "header" || t.Field == "body" {
+			return true
+		}
+	}
+	return len(q.FreeText) > 0
+}
+
+func (t Term) validate() error {
+	switch t.Field {
+	case "size":
+		if _, err := strconv.ParseInt(t.Value, 10, 64); err != nil {
+			return fmt.Errorf("size must be an integer")
+		}
+	case "received":
+		if _, _, err := parseTime(t.Value); err != nil {
+			return err
+		}
+	case "ip":
+		if net.ParseIP(t.Value) == nil {
+			if _, _, err := net.ParseCIDR(t.Value); err != nil {
+				return fmt.Errorf("ip must be an address or CIDR range")
+			}
+		}
+	}
+	return nil
+}
+
+// tokenize splits on whitespace while keeping double-quoted sections together
+func tokenize(input string) []string {
+	var tokens []string
+	var current strings.Builder
+	inQuotes := false
+
+	for _, r := range input {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuotes:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+// splitField splits "field:value", ignoring colons inside quotes
+func splitField(token string) (string, string, bool) {
+	if strings.HasPrefix(token, "\"") {
+		return "", "", false
+	}
+	i := strings.Index(token, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+	return token[:i], token[i+1:], true
+}
+
+// splitOperator extracts a leading comparison operator and unquotes the value
+func splitOperator(value string) (string, string) {
+	op := "="
+	for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, candidate) {
+			op = candidate
+			value = value[len(candidate):]
+			break
+		}
+	}
+	if len(value) >= 2 && strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
+		value = value[1 : len(value)-1]
+	}
+	return op, value
+}
+
+// parseTime accepts RFC 3339 timestamps or plain dates. dateOnly is true for
+// plain dates so equality can match the whole day.
+func parseTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid received value %q (use YYYY-MM-DD or RFC 3339)", value)
+}
+
+// likePattern converts a value with * wildcards into an escaped ILIKE pattern
+func likePattern(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+	return strings.ReplaceAll(escaped, "*", "%")
+}
+
+// jsonPath builds a quoted jsonpath accessor chain from a dotted body path
+func jsonPath(dotted string) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, key := range strings.Split(dotted, ".") {
+		quoted, _ := json.Marshal(key)
+		b.WriteString(".")
+		b.Write(quoted)
+	}
+	return b.String()
+}
+
+// jsonPathLiteral renders a value as a jsonpath literal; numbers and booleans
+// also match their string form so "amount:100" finds both 100 and "100"
+func jsonPathLiteral(op, value string) string {
+	quoted, _ := json.Marshal(value)
+	jsonOp := op
+	if op == "=" {
+		jsonOp = "=="
+	}
+	if isJSONNumber(value) {
+		if op == "=" {
+			return fmt.Sprintf("@ == %s || @ == %s", value, quoted)
+		}
+		return fmt.Sprintf("@ %s %s", jsonOp, value)
+	}
+	if op == "=" && (value == "true" || value == "false" || value == "null") {
+		return fmt.Sprintf("@ == %s || @ == %s", value, quoted)
+	}
+	return fmt.Sprintf("@ %s %s", jsonOp, quoted)
+}
+
+// isJSONNumber reports whether value is a number in JSON syntax
+func isJSONNumber(value string) bool {
+	var f float64
+	return json.Unmarshal([]byte(value), &f) == nil
+}
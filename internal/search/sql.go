@@ -0,0 +1,72 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AppendSQL appends the query's conditions to a WHERE clause over the requests
+// table, numbering placeholders after the existing args
+func (q *Query) AppendSQL(where string, args []interface{}) (string, []interface{}) {
+	if q.IsEmpty() {
+		return where, args
+	}
+
+	next := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	for _, t := range q.Terms {
+		var cond string
+
+		switch t.Field {
+		case "method":
+			cond = "method = " + next(strings.ToUpper(t.Value))
+		case "path", "subpath", "content_type":
+			cond = fmt.Sprintf("%s ILIKE %s", t.Field, next(likePattern(t.Value)))
+		case "ip":
+			cond = "ip <<= " + next(t.Value) + "::inet"
+		case "size":
+			size, _ := strconv.ParseInt(t.Value, 10, 64)
+			cond = fmt.Sprintf("body_size %s %s", t.Op, next(size))
+		case "received":
+			ts, dateOnly, _ := parseTime(t.Value)
+			if dateOnly && t.Op == "=" {
+				cond = fmt.Sprintf("(received_at >= %s AND received_at < %s)", next(ts), next(ts.Add(24*time.Hour)))
+			} else {
+				cond = fmt.Sprintf("received_at %s %s", t.Op, next(ts))
+			}
+		case "header", "query":
+			column := "headers"
+			if t.Field == "query" {
+				column = "query_params"
+			}
+			if strings.Contains(t.Value, "*") {
+				cond = fmt.Sprintf("EXISTS (SELECT 1 FROM jsonb_array_elements_text(%s -> %s) v WHERE v ILIKE %s)",
+					column, next(t.Key), next(likePattern(t.Value)))
+			} else {
+				// Containment keeps the GIN index on the column usable
+				contains, _ := json.Marshal(map[string][]string{t.Key: {t.Value}})
+				cond = fmt.Sprintf("%s @> %s::jsonb", column, next(string(contains)))
+			}
+		case "body":
+			path := fmt.Sprintf("%s ? (%s)", jsonPath(t.Key), jsonPathLiteral(t.Op, t.Value))
+			cond = "try_parse_jsonb(body) @? " + next(path) + "::jsonpath"
+		}
+
+		if t.Negate {
+			cond = fmt.Sprintf("NOT COALESCE(%s, FALSE)", cond)
+		}
+		where += " AND " + cond
+	}
+
+	if len(q.FreeText) > 0 {
+		where += " AND search_vector @@ websearch_to_tsquery('simple', " + next(strings.Join(q.FreeText, " ")) + ")"
+	}
+
+	return where, args
+}
@@ -0,0 +1,182 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"flowhook/internal/config"
+)
+
+// KeyManager wraps data keys under master keys it never reveals. The local
+// implementation reads master keys from config; a cloud KMS can be plugged
+// in with SetKeyManager.
+type KeyManager interface {
+	// CurrentKeyID names the master key new data keys are wrapped with
+	CurrentKeyID() string
+	WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error)
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+var (
+	managerOnce sync.Once
+	manager     KeyManager
+	managerErr  error
+)
+
+// SetKeyManager replaces the key manager built from config
+func SetKeyManager(km KeyManager) {
+	managerOnce.Do(func() {})
+	manager, managerErr = km, nil
+}
+
+// keyManager returns the configured key manager
+func keyManager() (KeyManager, error) {
+	managerOnce.Do(func() {
+		manager, managerErr = newLocalKeyManager()
+	})
+	return manager, managerErr
+}
+
+// Configured reports whether a master key is available
+func Configured() bool {
+	km, err := keyManager()
+	return err == nil && km != nil
+}
+
+// CurrentKeyID returns the ID of the master key used for new data, or "" if
+// encryption is not configured
+func CurrentKeyID() string {
+	km, err := keyManager()
+	if err != nil || km == nil {
+		return ""
+	}
+	return km.CurrentKeyID()
+}
+
+// localKeyManager is a KMS stand-in holding master keys in memory
+type localKeyManager struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// keyringFile is the format of ENCRYPTION_KEYRING_FILE
+type keyringFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"` // Key ID to base64 AES-256 key
+}
+
+// newLocalKeyManager loads master keys from ENCRYPTION_KEYRING_FILE, or from
+// ENCRYPTION_KEY and ENCRYPTION_PREVIOUS_KEYS. Keys from the environment are
+// identified by a hash prefix, so the IDs stay stable across restarts.
+func newLocalKeyManager() (KeyManager, error) {
+	if config.AppConfig == nil {
+		return nil, ErrNotConfigured
+	}
+
+	km := &localKeyManager{keys: make(map[string]cipher.AEAD)}
+	if path := config.AppConfig.EncryptionKeyringFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ENCRYPTION_KEYRING_FILE: %v", err)
+		}
+		var ring keyringFile
+		if err := json.Unmarshal(data, &ring); err != nil {
+			return nil, fmt.Errorf("invalid ENCRYPTION_KEYRING_FILE: %v", err)
+		}
+		for id, encoded := range ring.Keys {
+			if err := km.add(id, encoded); err != nil {
+				return nil, fmt.Errorf("keyring key %q: %v", id, err)
+			}
+		}
+		if _, ok := km.keys[ring.Current]; !ok {
+			return nil, fmt.Errorf("keyring current key %q is not in keys", ring.Current)
+		}
+		km.current = ring.Current
+		return km, nil
+	}
+
+	if config.AppConfig.EncryptionKey == "" {
+		return nil, ErrNotConfigured
+	}
+	km.current = keyID(config.AppConfig.EncryptionKey)
+	if err := km.add(km.current, config.AppConfig.EncryptionKey); err != nil {
+		return nil, fmt.Errorf("ENCRYPTION_KEY %v", err)
+	}
+	for _, encoded := range config.AppConfig.EncryptionPreviousKeys {
+		if err := km.add(keyID(encoded), encoded); err != nil {
+			return nil, fmt.Errorf("ENCRYPTION_PREVIOUS_KEYS %v", err)
+		}
+	}
+	return km, nil
+}
+
+// keyID derives a key's ID from its encoding
+func keyID(encoded string) string {
+	sum := sha256.Sum256([]byte(encoded))
+	return hex.EncodeToString(sum[:4])
+}
+
+func (km *localKeyManager) add(id, encoded string) error {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return errors.New("must be 32 bytes, base64 encoded")
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	km.keys[id] = aead
+	return nil
+}
+
+func (km *localKeyManager) CurrentKeyID() string {
+	return km.current
+}
+
+func (km *localKeyManager) WrapKey(dataKey []byte) (string, []byte, error) {
+	sealed, err := seal(km.keys[km.current], dataKey)
+	return km.current, sealed, err
+}
+
+func (km *localKeyManager) UnwrapKey(id string, wrapped []byte) ([]byte, error) {
+	aead, ok := km.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", id)
+	}
+	return open(aead, wrapped)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext under aead, prefixing a random nonce
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open reverses seal
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("invalid ciphertext: too short")
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
@@ -0,0 +1,157 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotConfigured is returned when no master key is configured
+var ErrNotConfigured = errors.New("ENCRYPTION_KEY must be set to store credentials")
+
+// Ciphertext format. Values are sealed with a random data key, stored wrapped
+// by a master key:
+//
+//	v2:<key id>:<base64 wrapped data key>:<base64 nonce|ciphertext>
+const prefixV2 = "v2:"
+
+// Encrypt seals plaintext with AES-256-GCM under a new data key wrapped by
+// the current master key
+func Encrypt(plaintext []byte) (string, error) {
+	km, err := keyManager()
+	if err != nil {
+		return "", err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, plaintext)
+	if err != nil {
+		return "", err
+	}
+	keyID, wrapped, err := km.WrapKey(dataKey)
+	if err != nil {
+		return "", err
+	}
+	return formatV2(keyID, wrapped, sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt under any known master key
+func Decrypt(ciphertext string) ([]byte, error) {
+	km, err := keyManager()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(ciphertext, prefixV2) {
+		return nil, errors.New("unrecognized ciphertext format")
+	}
+	keyID, wrapped, sealed, err := parseV2(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := km.UnwrapKey(keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, sealed)
+}
+
+// IsEncrypted reports whether value looks like a ciphertext from Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefixV2)
+}
+
+// EncryptString encrypts value if a master key is configured, and otherwise
+// returns it unchanged, for settings that predate encryption at rest
+func EncryptString(value string) (string, error) {
+	if value == "" || !Configured() {
+		return value, nil
+	}
+	return Encrypt([]byte(value))
+}
+
+// DecryptString reverses EncryptString, passing plaintext values through
+func DecryptString(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	plaintext, err := Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether value is plaintext or not under the current
+// master key
+func NeedsRotation(value string) bool {
+	if !strings.HasPrefix(value, prefixV2) {
+		return true
+	}
+	keyID, _, _, err := parseV2(value)
+	return err != nil || keyID != CurrentKeyID()
+}
+
+// Rotate re-encrypts value under the current master key. v2 values only have
+// their data key rewrapped; plaintext values are encrypted afresh.
+func Rotate(value string) (string, error) {
+	if !NeedsRotation(value) {
+		return value, nil
+	}
+	km, err := keyManager()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(value, prefixV2) {
+		plaintext, err := DecryptString(value)
+		if err != nil {
+			return "", err
+		}
+		return Encrypt([]byte(plaintext))
+	}
+
+	keyID, wrapped, sealed, err := parseV2(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := km.UnwrapKey(keyID, wrapped)
+	if err != nil {
+		return "", err
+	}
+	newKeyID, rewrapped, err := km.WrapKey(dataKey)
+	if err != nil {
+		return "", err
+	}
+	return formatV2(newKeyID, rewrapped, sealed), nil
+}
+
+func formatV2(keyID string, wrapped, sealed []byte) string {
+	return prefixV2 + keyID + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(sealed)
+}
+
+func parseV2(ciphertext string) (keyID string, wrapped, sealed []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(ciphertext, prefixV2), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("invalid ciphertext: malformed")
+	}
+	if wrapped, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, fmt.Errorf("invalid ciphertext: %v", err)
+	}
+	if sealed, err = base64.StdEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, fmt.Errorf("invalid ciphertext: %v", err)
+	}
+	return parts[0], wrapped, sealed, nil
+}
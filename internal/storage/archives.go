@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const ArchivesDir = "archives"
+
+// ValidArchiveDir reports whether dir can hold archives: empty, or a relative
+// path that stays within the archives directory
+func ValidArchiveDir(dir string) bool {
+	return dir == "" || filepath.IsLocal(dir)
+}
+
+// CreateArchiveFile creates a request archive for an endpoint in dir, a
+// subdirectory of the archives directory of the data directory, or in the
+// archives directory itself when dir is empty.
+// Returns the open file and the path to pass to OpenArchiveFile.
+func CreateArchiveFile(dir string, endpointID uuid.UUID) (*os.File, string, error) {
+	if !ValidArchiveDir(dir) {
+		return nil, "", fmt.Errorf("archive directory %q is not within %s", dir, ArchivesDir)
+	}
+	dir = filepath.Join(ArchivesDir, dir, endpointID.String())
+
+	absDir := filepath.Join(DataDir, dir)
+	if err := EnsureDir(absDir); err != nil {
+		return nil, "", fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	filename := fmt.Sprintf("%s-%s.ndjson.gz", time.Now().UTC().Format("20060102T150405Z"), uuid.New().String()[:8])
+	file, err := os.Create(filepath.Join(absDir, filename))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create archive file: %w", err)
+	}
+
+	return file, filepath.Join(dir, filename), nil
+}
+
+// OpenArchiveFile opens a request archive for reading
+func OpenArchiveFile(archivePath string) (*os.File, error) {
+	if !filepath.IsLocal(archivePath) {
+		return nil, fmt.Errorf("archive path %q is not within the data directory", archivePath)
+	}
+	return os.Open(filepath.Join(DataDir, archivePath))
+}
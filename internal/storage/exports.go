@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const ExportsDir = "exports"
+
+// CreateExportFile creates the result file for an export job.
+// Returns the open file and its path relative to the data directory.
+func CreateExportFile(jobID uuid.UUID, extension string) (*os.File, string, error) {
+	exportDir := filepath.Join(DataDir, ExportsDir)
+	if err := EnsureDir(exportDir); err != nil {
+		return nil, "", fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	filename := fmt.Sprintf("%s.%s", jobID.String(), extension)
+	file, err := os.Create(filepath.Join(exportDir, filename))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create export file: %w", err)
+	}
+
+	return file, filepath.Join(ExportsDir, filename), nil
+}
+
+// OpenExportFile opens an export result for reading
+func OpenExportFile(exportPath string) (*os.File, error) {
+	return os.Open(filepath.Join(DataDir, exportPath))
+}
+
+// DeleteExportFile deletes an export result
+func DeleteExportFile(exportPath string) error {
+	return os.Remove(filepath.Join(DataDir, exportPath))
+}
+
+// ExportFiles lists the export results in the data directory by job ID, with
+// their paths relative to the data directory. Files not named after a job are
+// skipped.
+func ExportFiles() (map[uuid.UUID]string, error) {
+	entries, err := os.ReadDir(filepath.Join(DataDir, ExportsDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list export files: %w", err)
+	}
+
+	files := make(map[uuid.UUID]string)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		name, _, _ := strings.Cut(entry.Name(), ".")
+		jobID, err := uuid.Parse(name)
+		if err != nil {
+			continue
+		}
+		files[jobID] = filepath.Join(ExportsDir, entry.Name())
+	}
+	return files, nil
+}
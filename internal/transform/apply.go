@@ -4,11 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
+	"unicode/utf8"
 
 	"flowhook/internal/db"
+	"flowhook/internal/logger"
+	"flowhook/internal/metrics"
 	"flowhook/internal/models"
+	"flowhook/internal/tracing"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // ApplyTransformations applies all enabled transformations for an endpoint
@@ -46,10 +52,19 @@ func ApplyTransformations(ctx context.Context, endpointID uuid.UUID, applyTo str
 		}
 
 		// Apply transformation
+		_, span := tracing.Start(ctx, "transformation",
+			attribute.String("flowhook.transformation.id", t.ID.String()),
+			attribute.String("flowhook.transformation.name", t.Name),
+			attribute.String("flowhook.transformation.language", t.Language),
+			attribute.String("flowhook.transformation.apply_to", applyTo),
+		)
+		start := time.Now()
 		transformed, err := ExecuteTransformation(t.Language, t.Script, result)
+		metrics.ObserveTransformation(t.Language, time.Since(start), err)
+		tracing.End(span, err)
 		if err != nil {
 			// Log error but continue with other transformations
-			fmt.Printf("Transformation %s (%s) failed: %v\n", t.Name, t.ID, err)
+			logger.WarnContext(ctx, "Transformation failed", "transformation_id", t.ID, "transformation", t.Name, "error", err)
 			continue
 		}
 
@@ -88,3 +103,54 @@ func ApplyResponseTransformations(ctx context.Context, endpointID uuid.UUID, res
 	return ApplyTransformations(ctx, endpointID, "response", responseBody)
 }
 
+
+// HasTransformations reports whether an endpoint has any enabled transformations for applyTo
+func HasTransformations(ctx context.Context, endpointID uuid.UUID, applyTo string) (bool, error) {
+	var exists bool
+	err := db.Pool.QueryRow(
+		ctx,
+		`SELECT EXISTS (
+			SELECT 1 FROM transformations
+			WHERE endpoint_id = $1 AND enabled = TRUE AND apply_to IN ($2, 'both')
+		)`,
+		endpointID,
+		applyTo,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check transformations: %w", err)
+	}
+	return exists, nil
+}
+
+// TransformResponseBody applies response transformations to a raw downstream body.
+// The boolean result is false when no transformation applies, in which case the
+// original body is returned untouched.
+func TransformResponseBody(ctx context.Context, endpointID uuid.UUID, body []byte) ([]byte, bool, error) {
+	if len(body) == 0 || !utf8.Valid(body) {
+		return body, false, nil
+	}
+
+	has, err := HasTransformations(ctx, endpointID, "response")
+	if err != nil || !has {
+		return body, false, err
+	}
+
+	var bodyData interface{}
+	if err := json.Unmarshal(body, &bodyData); err != nil {
+		bodyData = string(body)
+	}
+
+	transformed, err := ApplyResponseTransformations(ctx, endpointID, bodyData)
+	if err != nil {
+		return body, false, err
+	}
+
+	if str, ok := transformed.(string); ok {
+		return []byte(str), true, nil
+	}
+	out, err := json.Marshal(transformed)
+	if err != nil {
+		return body, false, fmt.Errorf("failed to encode transformed response: %w", err)
+	}
+	return out, true, nil
+}
@@ -0,0 +1,13 @@
+-- Reverts 001_initial_schema: drops every table of the initial schema
+DROP MATERIALIZED VIEW IF EXISTS delivery_stats;
+DROP TABLE IF EXISTS user_sessions;
+DROP TABLE IF EXISTS request_templates;
+DROP TABLE IF EXISTS retention_policies;
+DROP TABLE IF EXISTS endpoint_settings;
+DROP TABLE IF EXISTS transformations;
+DROP TABLE IF EXISTS forward_attempts;
+DROP TABLE IF EXISTS forwarding_rules;
+DROP TABLE IF EXISTS replays;
+DROP TABLE IF EXISTS requests;
+DROP TABLE IF EXISTS endpoints;
+DROP TABLE IF EXISTS users;
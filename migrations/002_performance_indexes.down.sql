@@ -0,0 +1,6 @@
+-- Reverts 002_performance_indexes. The other indexes it ensures belong to 001.
+DROP INDEX IF EXISTS idx_forward_attempts_rule_status;
+DROP INDEX IF EXISTS idx_forwarding_rules_endpoint_enabled;
+DROP INDEX IF EXISTS idx_requests_headers_gin;
+DROP INDEX IF EXISTS idx_requests_endpoint_date;
+DROP INDEX IF EXISTS idx_requests_analytics;
@@ -0,0 +1,2 @@
+-- Reverts 003_api_keys
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,3 @@
+-- Reverts 004_add_key_prefix_to_api_keys. key_prefix is also part of the
+-- api_keys table created by 003, so it is kept.
+SELECT 1;
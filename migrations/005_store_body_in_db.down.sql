@@ -0,0 +1,3 @@
+-- Reverts 005_store_body_in_db. Request bodies stored in the database are lost.
+DROP INDEX IF EXISTS idx_requests_body_size;
+ALTER TABLE requests DROP COLUMN IF EXISTS body;
@@ -0,0 +1,3 @@
+-- Reverts 006_proxy_mode
+ALTER TABLE endpoint_settings DROP COLUMN IF EXISTS primary_rule_id;
+ALTER TABLE endpoint_settings DROP COLUMN IF EXISTS mode;
@@ -0,0 +1,6 @@
+-- Migration: Synchronous proxy mode
+-- Endpoints in proxy mode forward each captured request to a primary
+-- forwarding rule and relay the downstream response to the sender
+
+ALTER TABLE endpoint_settings ADD COLUMN IF NOT EXISTS mode VARCHAR(16) DEFAULT 'capture'; -- capture|proxy
+ALTER TABLE endpoint_settings ADD COLUMN IF NOT EXISTS primary_rule_id UUID REFERENCES forwarding_rules(id) ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+-- Reverts 007_response_transformations
+ALTER TABLE replays DROP COLUMN IF EXISTS transformed_response_body;
+ALTER TABLE forward_attempts DROP COLUMN IF EXISTS transformed_response_body;
@@ -0,0 +1,6 @@
+-- Migration: Store transformed downstream responses
+-- Response-side transformations run over forward attempt and replay responses;
+-- the raw response stays in response_body and the transformed one is kept alongside
+
+ALTER TABLE forward_attempts ADD COLUMN IF NOT EXISTS transformed_response_body TEXT;
+ALTER TABLE replays ADD COLUMN IF NOT EXISTS transformed_response_body TEXT;
@@ -0,0 +1,3 @@
+-- Reverts 008_subpath_routing
+ALTER TABLE forwarding_rules DROP COLUMN IF EXISTS append_subpath;
+ALTER TABLE requests DROP COLUMN IF EXISTS subpath;
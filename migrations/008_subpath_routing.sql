@@ -0,0 +1,11 @@
+-- Migration: Subpath-aware capture and routing
+-- Requests to /e/:slug/orders/123 keep "/orders/123" as a first-class subpath,
+-- and forwarding rules can append it (or template it) into their target URL
+
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS subpath TEXT;
+
+-- Backfill subpath for requests captured before this migration
+UPDATE requests SET subpath = regexp_replace(COALESCE(path, ''), '^/e/[^/]+', '')
+WHERE subpath IS NULL;
+
+ALTER TABLE forwarding_rules ADD COLUMN IF NOT EXISTS append_subpath BOOLEAN DEFAULT FALSE;
@@ -0,0 +1,6 @@
+-- Reverts 009_request_search
+DROP INDEX IF EXISTS idx_requests_query_params_gin;
+DROP INDEX IF EXISTS idx_requests_body_json;
+DROP INDEX IF EXISTS idx_requests_search_vector;
+ALTER TABLE requests DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS try_parse_jsonb(TEXT);
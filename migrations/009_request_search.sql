@@ -0,0 +1,33 @@
+-- Migration: Full-text and structured request search
+-- Backs the request search language (method:POST header.x-github-event:push
+-- body.data.object.status:failed received:>2026-01-01 free text)
+
+-- Parses a request body as JSON, returning NULL for non-JSON bodies.
+-- Declared IMMUTABLE so it can back an expression index.
+CREATE OR REPLACE FUNCTION try_parse_jsonb(input TEXT) RETURNS JSONB AS $$
+BEGIN
+    RETURN input::jsonb;
+EXCEPTION WHEN others THEN
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+-- Full-text search over path, headers and (a bounded prefix of) the body
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS search_vector TSVECTOR
+    GENERATED ALWAYS AS (
+        to_tsvector('simple',
+            COALESCE(path, '') || ' ' ||
+            COALESCE(headers::text, '') || ' ' ||
+            LEFT(COALESCE(body, ''), 262144))
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_requests_search_vector
+ON requests USING GIN (search_vector);
+
+-- JSONB path index for body.* terms (jsonpath @? and containment)
+CREATE INDEX IF NOT EXISTS idx_requests_body_json
+ON requests USING GIN (try_parse_jsonb(body) jsonb_path_ops);
+
+-- Containment index for query.* terms (headers already have idx_requests_headers_gin)
+CREATE INDEX IF NOT EXISTS idx_requests_query_params_gin
+ON requests USING GIN (query_params);
@@ -0,0 +1,5 @@
+-- Reverts 010_keyset_pagination
+DROP INDEX IF EXISTS idx_forward_attempts_rule_attempted_id;
+DROP INDEX IF EXISTS idx_forward_attempts_request_attempted_id;
+DROP INDEX IF EXISTS idx_replays_request_created_id;
+DROP INDEX IF EXISTS idx_requests_endpoint_received_id;
@@ -0,0 +1,15 @@
+-- Migration: Keyset pagination indexes
+-- Lists are ordered newest-first on (timestamp, id) and paged with row
+-- comparisons against an opaque cursor, so each listing gets a matching index.
+
+CREATE INDEX IF NOT EXISTS idx_requests_endpoint_received_id
+    ON requests(endpoint_id, received_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_replays_request_created_id
+    ON replays(request_id, created_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_request_attempted_id
+    ON forward_attempts(request_id, attempted_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_rule_attempted_id
+    ON forward_attempts(forwarding_rule_id, attempted_at DESC, id DESC);
@@ -0,0 +1,2 @@
+-- Reverts 011_export_jobs. Export files in the data directory are left behind.
+DROP TABLE IF EXISTS export_jobs;
@@ -0,0 +1,22 @@
+-- Migration: Bulk export jobs
+-- Large endpoint exports run in the background and write their result to the
+-- data directory, from where it can be downloaded until the job is deleted.
+
+CREATE TABLE IF NOT EXISTS export_jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    endpoint_id UUID NOT NULL REFERENCES endpoints(id) ON DELETE CASCADE,
+    format VARCHAR(16) NOT NULL, -- ndjson|csv|har|postman
+    filter JSONB DEFAULT '{}'::jsonb, -- method, search, from, to, q
+    base_url TEXT NOT NULL,
+    status VARCHAR(32) DEFAULT 'pending', -- pending|running|completed|failed
+    request_count INTEGER DEFAULT 0,
+    file_path TEXT,
+    file_size BIGINT,
+    error_message TEXT,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    started_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_export_jobs_endpoint_created ON export_jobs(endpoint_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_export_jobs_status ON export_jobs(status);
@@ -0,0 +1,3 @@
+-- Reverts 012_bulk_replays
+DROP TABLE IF EXISTS bulk_replay_items;
+DROP TABLE IF EXISTS bulk_replays;
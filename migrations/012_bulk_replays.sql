@@ -0,0 +1,39 @@
+-- Migration: Bulk replay jobs
+-- Replays every request matching a filter to a URL or forwarding rule with
+-- rate and concurrency limits. Items record each request's outcome, which also
+-- lets a paused or interrupted job resume where it left off.
+
+CREATE TABLE IF NOT EXISTS bulk_replays (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    endpoint_id UUID NOT NULL REFERENCES endpoints(id) ON DELETE CASCADE,
+    filter JSONB DEFAULT '{}'::jsonb,
+    target_url TEXT,
+    forwarding_rule_id UUID REFERENCES forwarding_rules(id) ON DELETE SET NULL,
+    rate_per_second DOUBLE PRECISION DEFAULT 0, -- 0 = unlimited
+    concurrency INTEGER DEFAULT 1,
+    sort_order VARCHAR(16) DEFAULT 'oldest_first', -- oldest_first|newest_first
+    snapshot_at TIMESTAMPTZ DEFAULT now(), -- Requests received later are not replayed
+    status VARCHAR(32) DEFAULT 'pending', -- pending|running|paused|completed|cancelled|failed
+    total INTEGER DEFAULT 0,
+    processed INTEGER DEFAULT 0,
+    succeeded INTEGER DEFAULT 0,
+    failed INTEGER DEFAULT 0,
+    error_message TEXT,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    started_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_bulk_replays_endpoint_created ON bulk_replays(endpoint_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_bulk_replays_status ON bulk_replays(status);
+
+CREATE TABLE IF NOT EXISTS bulk_replay_items (
+    bulk_replay_id UUID NOT NULL REFERENCES bulk_replays(id) ON DELETE CASCADE,
+    request_id UUID NOT NULL REFERENCES requests(id) ON DELETE CASCADE,
+    replay_id UUID REFERENCES replays(id) ON DELETE SET NULL,
+    status VARCHAR(32) NOT NULL, -- success|failed
+    response_status INTEGER,
+    error_message TEXT,
+    completed_at TIMESTAMPTZ DEFAULT now(),
+    PRIMARY KEY (bulk_replay_id, request_id)
+);
@@ -0,0 +1,8 @@
+-- Reverts 013_replay_attempts
+DROP TABLE IF EXISTS replay_attempts;
+DROP INDEX IF EXISTS idx_replays_source;
+ALTER TABLE replays DROP COLUMN IF EXISTS source_replay_id;
+ALTER TABLE replays DROP COLUMN IF EXISTS follow_redirects;
+ALTER TABLE replays DROP COLUMN IF EXISTS timeout_ms;
+ALTER TABLE replays DROP COLUMN IF EXISTS backoff_config;
+ALTER TABLE replays DROP COLUMN IF EXISTS max_retries;
@@ -0,0 +1,27 @@
+-- Migration: Replay retries and attempt history
+-- Replays take the same retry options as forwarding rules plus a timeout and
+-- redirect policy. Each attempt is recorded, like forward_attempts, and a
+-- replay can be re-run from a previous one.
+
+ALTER TABLE replays ADD COLUMN IF NOT EXISTS max_retries INTEGER DEFAULT 1;
+ALTER TABLE replays ADD COLUMN IF NOT EXISTS backoff_config JSONB DEFAULT '{}'::jsonb;
+ALTER TABLE replays ADD COLUMN IF NOT EXISTS timeout_ms INTEGER DEFAULT 30000;
+ALTER TABLE replays ADD COLUMN IF NOT EXISTS follow_redirects BOOLEAN DEFAULT true;
+ALTER TABLE replays ADD COLUMN IF NOT EXISTS source_replay_id UUID REFERENCES replays(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS replay_attempts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    replay_id UUID NOT NULL REFERENCES replays(id) ON DELETE CASCADE,
+    attempt_number INTEGER DEFAULT 1,
+    status VARCHAR(32) DEFAULT 'pending', -- success|failed
+    response_status INTEGER,
+    response_headers JSONB,
+    response_body TEXT,
+    transformed_response_body TEXT,
+    error_message TEXT,
+    duration_ms INTEGER,
+    attempted_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_replay_attempts_replay ON replay_attempts(replay_id, attempt_number);
+CREATE INDEX IF NOT EXISTS idx_replays_source ON replays(source_replay_id) WHERE source_replay_id IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Reverts 014_shadow_forwarding
+DROP TABLE IF EXISTS shadow_attempts;
+ALTER TABLE forwarding_rules DROP COLUMN IF EXISTS shadow_ignore_paths;
+ALTER TABLE forwarding_rules DROP COLUMN IF EXISTS shadow_target_url;
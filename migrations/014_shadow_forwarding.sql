@@ -0,0 +1,32 @@
+-- Migration: Shadow forwarding
+-- A rule with a shadow target mirrors every forwarded request to it. The
+-- primary target alone decides success and retries; the shadow's response is
+-- recorded and compared with the primary's final attempt.
+
+ALTER TABLE forwarding_rules ADD COLUMN IF NOT EXISTS shadow_target_url TEXT;
+ALTER TABLE forwarding_rules ADD COLUMN IF NOT EXISTS shadow_ignore_paths JSONB DEFAULT '[]'::jsonb;
+
+CREATE TABLE IF NOT EXISTS shadow_attempts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    request_id UUID NOT NULL REFERENCES requests(id) ON DELETE CASCADE,
+    forwarding_rule_id UUID NOT NULL REFERENCES forwarding_rules(id) ON DELETE CASCADE,
+    primary_attempt_id UUID REFERENCES forward_attempts(id) ON DELETE SET NULL,
+    target_url TEXT NOT NULL,
+    status VARCHAR(32) NOT NULL, -- success|failed (whether the shadow responded)
+    response_status INTEGER,
+    response_headers JSONB,
+    response_body TEXT,
+    error_message TEXT,
+    duration_ms INTEGER,
+    primary_status INTEGER,
+    primary_duration_ms INTEGER,
+    status_match BOOLEAN NOT NULL DEFAULT false,
+    match BOOLEAN NOT NULL DEFAULT false, -- Status, headers and body equal after ignore paths
+    diff JSONB, -- Summary of the differences
+    attempted_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_shadow_attempts_rule_attempted_id
+    ON shadow_attempts(forwarding_rule_id, attempted_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_shadow_attempts_primary ON shadow_attempts(primary_attempt_id);
+CREATE INDEX IF NOT EXISTS idx_shadow_attempts_request ON shadow_attempts(request_id);
@@ -0,0 +1,5 @@
+-- Reverts 015_transport_config
+ALTER TABLE request_templates DROP COLUMN IF EXISTS transport_rule_id;
+ALTER TABLE replays DROP COLUMN IF EXISTS transport_rule_id;
+ALTER TABLE forwarding_rules DROP COLUMN IF EXISTS transport_client_key;
+ALTER TABLE forwarding_rules DROP COLUMN IF EXISTS transport_config;
@@ -0,0 +1,11 @@
+-- Migration: Per-rule transport configuration
+-- Timeout, TLS verification, custom CA, client certificate, proxy and HTTP/2
+-- settings for a forwarding rule's downstream. The client key is stored
+-- encrypted, apart from the rest of the configuration. Replays and templates
+-- can use a rule's transport by referencing it.
+
+ALTER TABLE forwarding_rules ADD COLUMN IF NOT EXISTS transport_config JSONB;
+ALTER TABLE forwarding_rules ADD COLUMN IF NOT EXISTS transport_client_key TEXT;
+
+ALTER TABLE replays ADD COLUMN IF NOT EXISTS transport_rule_id UUID REFERENCES forwarding_rules(id) ON DELETE SET NULL;
+ALTER TABLE request_templates ADD COLUMN IF NOT EXISTS transport_rule_id UUID REFERENCES forwarding_rules(id) ON DELETE SET NULL;
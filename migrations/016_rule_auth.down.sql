@@ -0,0 +1,3 @@
+-- Reverts 016_rule_auth
+ALTER TABLE forwarding_rules DROP COLUMN IF EXISTS auth_secret;
+ALTER TABLE forwarding_rules DROP COLUMN IF EXISTS auth_config;
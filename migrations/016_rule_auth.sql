@@ -0,0 +1,7 @@
+-- Migration: Forwarding rule authentication
+-- Basic, static bearer or OAuth2 client-credentials authentication for a
+-- rule's downstream. The password, token or client secret is stored
+-- encrypted, apart from the rest of the configuration.
+
+ALTER TABLE forwarding_rules ADD COLUMN IF NOT EXISTS auth_config JSONB;
+ALTER TABLE forwarding_rules ADD COLUMN IF NOT EXISTS auth_secret TEXT;
@@ -0,0 +1,4 @@
+-- Reverts 017_encryption_at_rest. Payloads of requests stored encrypted are
+-- lost; those requests keep their empty headers and NULL body.
+ALTER TABLE requests DROP COLUMN IF EXISTS encrypted_payload;
+ALTER TABLE endpoint_settings DROP COLUMN IF EXISTS encrypt_payloads;
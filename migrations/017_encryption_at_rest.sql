@@ -0,0 +1,7 @@
+-- Migration: Encryption at rest
+-- Endpoints can store captured request headers and bodies encrypted. Such
+-- requests keep empty headers and a NULL body, with both held in
+-- encrypted_payload instead.
+
+ALTER TABLE endpoint_settings ADD COLUMN IF NOT EXISTS encrypt_payloads BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS encrypted_payload TEXT;
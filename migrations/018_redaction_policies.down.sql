@@ -0,0 +1,2 @@
+-- Reverts 018_redaction_policies
+DROP TABLE IF EXISTS redaction_policies;
@@ -0,0 +1,16 @@
+-- Migration: Redaction policies
+-- Per-endpoint rules applied to captured requests before they are stored:
+-- masked headers, hashed or dropped JSON paths and pattern detectors.
+
+CREATE TABLE IF NOT EXISTS redaction_policies (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    endpoint_id UUID NOT NULL UNIQUE REFERENCES endpoints(id) ON DELETE CASCADE,
+    mask_headers JSONB NOT NULL DEFAULT '[]'::jsonb,
+    hash_paths JSONB NOT NULL DEFAULT '[]'::jsonb,
+    drop_paths JSONB NOT NULL DEFAULT '[]'::jsonb,
+    detectors JSONB NOT NULL DEFAULT '[]'::jsonb,
+    patterns JSONB NOT NULL DEFAULT '[]'::jsonb,
+    forward_unredacted BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    updated_at TIMESTAMPTZ DEFAULT now()
+);
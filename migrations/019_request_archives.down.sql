@@ -0,0 +1,3 @@
+-- Reverts 019_request_archives. Archive files are left in place.
+ALTER TABLE requests DROP COLUMN IF EXISTS restored_at;
+DROP TABLE IF EXISTS request_archives;
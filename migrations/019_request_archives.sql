@@ -0,0 +1,20 @@
+-- Migration: Request archives
+-- The retention worker writes expired requests of endpoints with archiving
+-- enabled to gzip-compressed NDJSON files before deleting them. Each file is
+-- recorded here so its requests can be restored.
+
+CREATE TABLE IF NOT EXISTS request_archives (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    endpoint_id UUID NOT NULL REFERENCES endpoints(id) ON DELETE CASCADE,
+    file_path TEXT NOT NULL,
+    request_count INTEGER DEFAULT 0,
+    oldest_received_at TIMESTAMPTZ,
+    newest_received_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    restored_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_request_archives_endpoint_created ON request_archives(endpoint_id, created_at DESC);
+
+-- Restored requests are kept for a full retention period from their restore
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS restored_at TIMESTAMPTZ;
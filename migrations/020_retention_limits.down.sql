@@ -0,0 +1,4 @@
+-- Reverts 020_retention_limits
+ALTER TABLE retention_policies DROP COLUMN IF EXISTS keep_forever;
+ALTER TABLE retention_policies DROP COLUMN IF EXISTS max_bytes;
+ALTER TABLE retention_policies DROP COLUMN IF EXISTS max_requests;
@@ -0,0 +1,9 @@
+-- Migration: Count- and size-based retention limits
+-- Besides retention_days, a policy can keep only the newest max_requests
+-- requests or max_bytes of request bodies. keep_forever exempts an endpoint
+-- from cleanup, including the global default policy. retention_days = 0 sets
+-- no age limit.
+
+ALTER TABLE retention_policies ADD COLUMN IF NOT EXISTS max_requests INTEGER;
+ALTER TABLE retention_policies ADD COLUMN IF NOT EXISTS max_bytes BIGINT;
+ALTER TABLE retention_policies ADD COLUMN IF NOT EXISTS keep_forever BOOLEAN DEFAULT FALSE;
@@ -0,0 +1,112 @@
+-- Reverts 021_time_partitioning: requests and forward_attempts become plain
+-- tables again, keyed on id, and the tables referencing them get back their
+-- foreign keys. Rows of dependents whose request is already gone are deleted
+-- first, as the foreign keys would have.
+
+-- Replaces the partitioned tbl with a plain copy. Returns false when tbl is
+-- not partitioned.
+CREATE OR REPLACE FUNCTION convert_from_monthly_partitions(tbl TEXT)
+RETURNS BOOLEAN AS $$
+DECLARE
+    partitioned TEXT := tbl || '_partitioned';
+    key_column TEXT;
+    columns TEXT;
+    foreign_keys TEXT[] := '{}';
+    stmt TEXT;
+    r RECORD;
+BEGIN
+    IF (SELECT relkind FROM pg_class WHERE oid = tbl::regclass) <> 'p' THEN
+        RETURN false;
+    END IF;
+
+    SELECT a.attname INTO key_column
+    FROM pg_partitioned_table pt
+    JOIN pg_attribute a ON a.attrelid = pt.partrelid AND a.attnum = pt.partattrs[0]
+    WHERE pt.partrelid = tbl::regclass;
+
+    EXECUTE format('ALTER TABLE %I RENAME TO %I', tbl, partitioned);
+    EXECUTE format('CREATE TABLE %I (LIKE %I INCLUDING DEFAULTS INCLUDING GENERATED INCLUDING CONSTRAINTS)',
+        tbl, partitioned);
+    EXECUTE format('ALTER TABLE %I ALTER COLUMN %I DROP NOT NULL', tbl, key_column);
+
+    SELECT string_agg(quote_ident(attname), ', ' ORDER BY attnum) INTO columns
+    FROM pg_attribute
+    WHERE attrelid = partitioned::regclass AND attnum > 0 AND NOT attisdropped AND attgenerated = '';
+    EXECUTE format('INSERT INTO %I (%s) SELECT %s FROM %I', tbl, columns, columns, partitioned);
+
+    FOR r IN SELECT conname, pg_get_constraintdef(oid) AS def FROM pg_constraint
+             WHERE contype = 'f' AND conrelid = partitioned::regclass LOOP
+        foreign_keys := array_append(foreign_keys, format('ALTER TABLE %I ADD CONSTRAINT %I %s', tbl, r.conname, r.def));
+    END LOOP;
+
+    EXECUTE format('DROP TABLE %I', partitioned);
+    FOREACH stmt IN ARRAY foreign_keys LOOP
+        EXECUTE stmt;
+    END LOOP;
+    EXECUTE format('ALTER TABLE %I ADD PRIMARY KEY (id)', tbl);
+
+    RETURN true;
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$
+BEGIN
+    IF (SELECT relkind FROM pg_class WHERE oid = 'requests'::regclass) = 'p'
+       OR (SELECT relkind FROM pg_class WHERE oid = 'forward_attempts'::regclass) = 'p' THEN
+        -- Recreated below on the plain table
+        DROP MATERIALIZED VIEW IF EXISTS delivery_stats;
+
+        PERFORM convert_from_monthly_partitions('requests');
+        PERFORM convert_from_monthly_partitions('forward_attempts');
+
+        DELETE FROM forward_attempts fa WHERE NOT EXISTS (SELECT 1 FROM requests r WHERE r.id = fa.request_id);
+        DELETE FROM shadow_attempts sa WHERE NOT EXISTS (SELECT 1 FROM requests r WHERE r.id = sa.request_id);
+        DELETE FROM bulk_replay_items bi WHERE NOT EXISTS (SELECT 1 FROM requests r WHERE r.id = bi.request_id);
+        DELETE FROM replays rp WHERE NOT EXISTS (SELECT 1 FROM requests r WHERE r.id = rp.request_id);
+        UPDATE shadow_attempts sa SET primary_attempt_id = NULL
+        WHERE primary_attempt_id IS NOT NULL
+          AND NOT EXISTS (SELECT 1 FROM forward_attempts fa WHERE fa.id = sa.primary_attempt_id);
+
+        ALTER TABLE forward_attempts ADD FOREIGN KEY (request_id) REFERENCES requests(id) ON DELETE CASCADE;
+        ALTER TABLE replays ADD FOREIGN KEY (request_id) REFERENCES requests(id) ON DELETE CASCADE;
+        ALTER TABLE bulk_replay_items ADD FOREIGN KEY (request_id) REFERENCES requests(id) ON DELETE CASCADE;
+        ALTER TABLE shadow_attempts ADD FOREIGN KEY (request_id) REFERENCES requests(id) ON DELETE CASCADE;
+        ALTER TABLE shadow_attempts ADD FOREIGN KEY (primary_attempt_id) REFERENCES forward_attempts(id) ON DELETE SET NULL;
+    END IF;
+END $$;
+
+DROP FUNCTION IF EXISTS convert_from_monthly_partitions(TEXT);
+DROP FUNCTION IF EXISTS delete_request_dependents();
+DROP FUNCTION IF EXISTS convert_to_monthly_partitions(TEXT, TEXT);
+DROP FUNCTION IF EXISTS create_monthly_partitions(TEXT, TIMESTAMPTZ, TIMESTAMPTZ);
+
+CREATE INDEX IF NOT EXISTS idx_requests_endpoint_received_id ON requests(endpoint_id, received_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_requests_received_at ON requests(received_at DESC);
+CREATE INDEX IF NOT EXISTS idx_requests_method ON requests(method);
+CREATE INDEX IF NOT EXISTS idx_requests_body_size ON requests(body_size) WHERE body_size > 0;
+CREATE INDEX IF NOT EXISTS idx_requests_headers_gin ON requests USING GIN (headers);
+CREATE INDEX IF NOT EXISTS idx_requests_search_vector ON requests USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_requests_body_json ON requests USING GIN (try_parse_jsonb(body) jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_requests_query_params_gin ON requests USING GIN (query_params);
+
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_request_attempted_id ON forward_attempts(request_id, attempted_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_rule_attempted_id ON forward_attempts(forwarding_rule_id, attempted_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_rule_status ON forward_attempts(forwarding_rule_id, status, attempted_at DESC);
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_status ON forward_attempts(status);
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_attempted_at ON forward_attempts(attempted_at DESC);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS delivery_stats AS
+SELECT
+    forwarding_rule_id,
+    DATE_TRUNC('hour', attempted_at) as hour,
+    COUNT(*) as total_attempts,
+    COUNT(*) FILTER (WHERE status = 'success') as successful,
+    COUNT(*) FILTER (WHERE status = 'failed') as failed,
+    AVG(duration_ms) FILTER (WHERE duration_ms IS NOT NULL) as avg_duration_ms,
+    MAX(duration_ms) as max_duration_ms,
+    MIN(duration_ms) as min_duration_ms
+FROM forward_attempts
+GROUP BY forwarding_rule_id, DATE_TRUNC('hour', attempted_at);
+
+CREATE INDEX IF NOT EXISTS idx_delivery_stats_rule_id ON delivery_stats(forwarding_rule_id);
+CREATE INDEX IF NOT EXISTS idx_delivery_stats_hour ON delivery_stats(hour DESC);
@@ -0,0 +1,203 @@
+-- Migration: Time-partitioned requests and forward_attempts
+-- Both tables are range-partitioned by month on received_at / attempted_at,
+-- so retention can drop whole partitions and time-bounded queries only scan
+-- the months they cover. Partitions are named <table>_pYYYYMM (UTC months);
+-- rows outside every partition land in <table>_default. The retention worker
+-- keeps partitions created a few months ahead.
+--
+-- Primary keys become (id, received_at) and (id, attempted_at), and foreign
+-- keys can no longer reference these tables. Deleting a request still deletes
+-- its forward attempts, shadow attempts, replays and bulk replay items, through
+-- a trigger. Existing rows are copied once, on the first run.
+--
+-- Maintenance window: copies all requests and forward attempts under exclusive locks
+-- The copy runs in the migration's transaction, holding ACCESS EXCLUSIVE locks
+-- on requests and forward_attempts until it commits, so captures, forwarding
+-- and request reads wait for it; on a large database that is an outage. Stop
+-- traffic and apply it with `migrate up` rather than letting a rolling deploy
+-- apply it at startup.
+
+-- Creates the monthly partitions of parent covering [from_ts, to_ts). Rows of
+-- a new month already in the default partition are moved into it.
+CREATE OR REPLACE FUNCTION create_monthly_partitions(parent TEXT, from_ts TIMESTAMPTZ, to_ts TIMESTAMPTZ)
+RETURNS INTEGER AS $$
+DECLARE
+    month_start TIMESTAMP := date_trunc('month', from_ts AT TIME ZONE 'UTC');
+    lower_bound TIMESTAMPTZ;
+    upper_bound TIMESTAMPTZ;
+    partition_name TEXT;
+    default_name TEXT := parent || '_default';
+    key_column TEXT;
+    columns TEXT;
+    moving BOOLEAN;
+    created INTEGER := 0;
+BEGIN
+    SELECT a.attname INTO key_column
+    FROM pg_partitioned_table pt
+    JOIN pg_attribute a ON a.attrelid = pt.partrelid AND a.attnum = pt.partattrs[0]
+    WHERE pt.partrelid = parent::regclass;
+
+    SELECT string_agg(quote_ident(attname), ', ' ORDER BY attnum) INTO columns
+    FROM pg_attribute
+    WHERE attrelid = parent::regclass AND attnum > 0 AND NOT attisdropped AND attgenerated = '';
+
+    WHILE (month_start AT TIME ZONE 'UTC') < to_ts LOOP
+        lower_bound := month_start AT TIME ZONE 'UTC';
+        upper_bound := (month_start + INTERVAL '1 month') AT TIME ZONE 'UTC';
+        partition_name := parent || '_p' || to_char(month_start, 'YYYYMM');
+
+        IF to_regclass(partition_name) IS NULL THEN
+            moving := false;
+            IF to_regclass(default_name) IS NOT NULL THEN
+                EXECUTE format('SELECT EXISTS (SELECT 1 FROM %I WHERE %I >= %L AND %I < %L)',
+                    default_name, key_column, lower_bound, key_column, upper_bound) INTO moving;
+            END IF;
+
+            IF moving THEN
+                EXECUTE format('ALTER TABLE %I DETACH PARTITION %I', parent, default_name);
+            END IF;
+            EXECUTE format('CREATE TABLE %I PARTITION OF %I FOR VALUES FROM (%L) TO (%L)',
+                partition_name, parent, lower_bound, upper_bound);
+            IF moving THEN
+                EXECUTE format('INSERT INTO %I (%s) SELECT %s FROM %I WHERE %I >= %L AND %I < %L',
+                    parent, columns, columns, default_name, key_column, lower_bound, key_column, upper_bound);
+                EXECUTE format('DELETE FROM %I WHERE %I >= %L AND %I < %L',
+                    default_name, key_column, lower_bound, key_column, upper_bound);
+                EXECUTE format('ALTER TABLE %I ATTACH PARTITION %I DEFAULT', parent, default_name);
+            END IF;
+            created := created + 1;
+        END IF;
+
+        month_start := month_start + INTERVAL '1 month';
+    END LOOP;
+
+    RETURN created;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Replaces tbl with a copy partitioned by month on key_column. Returns false
+-- when tbl is already partitioned.
+CREATE OR REPLACE FUNCTION convert_to_monthly_partitions(tbl TEXT, key_column TEXT)
+RETURNS BOOLEAN AS $$
+DECLARE
+    legacy TEXT := tbl || '_unpartitioned';
+    oldest TIMESTAMPTZ;
+    columns TEXT;
+    foreign_keys TEXT[] := '{}';
+    stmt TEXT;
+    r RECORD;
+BEGIN
+    IF (SELECT relkind FROM pg_class WHERE oid = tbl::regclass) = 'p' THEN
+        RETURN false;
+    END IF;
+
+    -- Foreign keys referencing tbl cannot reference a partitioned table
+    FOR r IN SELECT conrelid::regclass AS referencing, conname FROM pg_constraint
+             WHERE contype = 'f' AND confrelid = tbl::regclass LOOP
+        EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', r.referencing, r.conname);
+    END LOOP;
+
+    EXECUTE format('UPDATE %I SET %I = now() WHERE %I IS NULL', tbl, key_column, key_column);
+    EXECUTE format('ALTER TABLE %I RENAME TO %I', tbl, legacy);
+    EXECUTE format('CREATE TABLE %I (LIKE %I INCLUDING DEFAULTS INCLUDING GENERATED INCLUDING CONSTRAINTS) PARTITION BY RANGE (%I)',
+        tbl, legacy, key_column);
+    EXECUTE format('ALTER TABLE %I ALTER COLUMN %I SET NOT NULL', tbl, key_column);
+    EXECUTE format('CREATE TABLE %I PARTITION OF %I DEFAULT', tbl || '_default', tbl);
+
+    EXECUTE format('SELECT min(%I) FROM %I', key_column, legacy) INTO oldest;
+    PERFORM create_monthly_partitions(tbl, COALESCE(oldest, now()), now() + INTERVAL '3 months');
+
+    SELECT string_agg(quote_ident(attname), ', ' ORDER BY attnum) INTO columns
+    FROM pg_attribute
+    WHERE attrelid = legacy::regclass AND attnum > 0 AND NOT attisdropped AND attgenerated = '';
+    EXECUTE format('INSERT INTO %I (%s) SELECT %s FROM %I', tbl, columns, columns, legacy);
+
+    -- Foreign keys to other tables move to the new table
+    FOR r IN SELECT conname, pg_get_constraintdef(oid) AS def FROM pg_constraint
+             WHERE contype = 'f' AND conrelid = legacy::regclass LOOP
+        foreign_keys := array_append(foreign_keys, format('ALTER TABLE %I ADD CONSTRAINT %I %s', tbl, r.conname, r.def));
+    END LOOP;
+
+    EXECUTE format('DROP TABLE %I', legacy);
+    FOREACH stmt IN ARRAY foreign_keys LOOP
+        EXECUTE stmt;
+    END LOOP;
+    EXECUTE format('ALTER TABLE %I ADD PRIMARY KEY (id, %I)', tbl, key_column);
+
+    RETURN true;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Stands in for the ON DELETE CASCADE foreign keys of the tables referencing
+-- a request. A request moved to another partition still exists and keeps them.
+CREATE OR REPLACE FUNCTION delete_request_dependents() RETURNS TRIGGER AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM requests WHERE id = OLD.id) THEN
+        RETURN OLD;
+    END IF;
+    DELETE FROM forward_attempts WHERE request_id = OLD.id;
+    DELETE FROM shadow_attempts WHERE request_id = OLD.id;
+    DELETE FROM bulk_replay_items WHERE request_id = OLD.id;
+    DELETE FROM replays WHERE request_id = OLD.id;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$
+BEGIN
+    IF (SELECT relkind FROM pg_class WHERE oid = 'requests'::regclass) <> 'p'
+       OR (SELECT relkind FROM pg_class WHERE oid = 'forward_attempts'::regclass) <> 'p' THEN
+        -- Recreated below on the partitioned table
+        DROP MATERIALIZED VIEW IF EXISTS delivery_stats;
+
+        PERFORM convert_to_monthly_partitions('requests', 'received_at');
+        PERFORM convert_to_monthly_partitions('forward_attempts', 'attempted_at');
+
+        CREATE TRIGGER requests_delete_dependents
+            AFTER DELETE ON requests
+            FOR EACH ROW EXECUTE FUNCTION delete_request_dependents();
+    END IF;
+END $$;
+
+SELECT create_monthly_partitions('requests', now(), now() + INTERVAL '3 months');
+SELECT create_monthly_partitions('forward_attempts', now(), now() + INTERVAL '3 months');
+
+-- Earlier migrations created several overlapping indexes. Tables converted
+-- above lose them with the legacy table; drop them wherever they remain.
+DROP INDEX IF EXISTS idx_requests_endpoint_id;
+DROP INDEX IF EXISTS idx_requests_endpoint_date;
+DROP INDEX IF EXISTS idx_requests_analytics;
+DROP INDEX IF EXISTS idx_forward_attempts_request_id;
+DROP INDEX IF EXISTS idx_forward_attempts_rule_id;
+
+-- Indexes, created on every partition
+CREATE INDEX IF NOT EXISTS idx_requests_endpoint_received_id ON requests(endpoint_id, received_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_requests_received_at ON requests(received_at DESC);
+CREATE INDEX IF NOT EXISTS idx_requests_method ON requests(method);
+CREATE INDEX IF NOT EXISTS idx_requests_body_size ON requests(body_size) WHERE body_size > 0;
+CREATE INDEX IF NOT EXISTS idx_requests_headers_gin ON requests USING GIN (headers);
+CREATE INDEX IF NOT EXISTS idx_requests_search_vector ON requests USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_requests_body_json ON requests USING GIN (try_parse_jsonb(body) jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_requests_query_params_gin ON requests USING GIN (query_params);
+
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_request_attempted_id ON forward_attempts(request_id, attempted_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_rule_attempted_id ON forward_attempts(forwarding_rule_id, attempted_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_rule_status ON forward_attempts(forwarding_rule_id, status, attempted_at DESC);
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_status ON forward_attempts(status);
+CREATE INDEX IF NOT EXISTS idx_forward_attempts_attempted_at ON forward_attempts(attempted_at DESC);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS delivery_stats AS
+SELECT
+    forwarding_rule_id,
+    DATE_TRUNC('hour', attempted_at) as hour,
+    COUNT(*) as total_attempts,
+    COUNT(*) FILTER (WHERE status = 'success') as successful,
+    COUNT(*) FILTER (WHERE status = 'failed') as failed,
+    AVG(duration_ms) FILTER (WHERE duration_ms IS NOT NULL) as avg_duration_ms,
+    MAX(duration_ms) as max_duration_ms,
+    MIN(duration_ms) as min_duration_ms
+FROM forward_attempts
+GROUP BY forwarding_rule_id, DATE_TRUNC('hour', attempted_at);
+
+CREATE INDEX IF NOT EXISTS idx_delivery_stats_rule_id ON delivery_stats(forwarding_rule_id);
+CREATE INDEX IF NOT EXISTS idx_delivery_stats_hour ON delivery_stats(hour DESC);
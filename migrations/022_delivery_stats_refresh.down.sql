@@ -0,0 +1,19 @@
+-- Reverts 022_delivery_stats_refresh
+DROP TABLE IF EXISTS materialized_view_refreshes;
+DROP MATERIALIZED VIEW IF EXISTS delivery_stats;
+
+CREATE MATERIALIZED VIEW delivery_stats AS
+SELECT
+    forwarding_rule_id,
+    DATE_TRUNC('hour', attempted_at) as hour,
+    COUNT(*) as total_attempts,
+    COUNT(*) FILTER (WHERE status = 'success') as successful,
+    COUNT(*) FILTER (WHERE status = 'failed') as failed,
+    AVG(duration_ms) FILTER (WHERE duration_ms IS NOT NULL) as avg_duration_ms,
+    MAX(duration_ms) as max_duration_ms,
+    MIN(duration_ms) as min_duration_ms
+FROM forward_attempts
+GROUP BY forwarding_rule_id, DATE_TRUNC('hour', attempted_at);
+
+CREATE INDEX IF NOT EXISTS idx_delivery_stats_rule_id ON delivery_stats(forwarding_rule_id);
+CREATE INDEX IF NOT EXISTS idx_delivery_stats_hour ON delivery_stats(hour DESC);
@@ -0,0 +1,39 @@
+-- Migration: Refreshed delivery_stats
+-- delivery_stats holds hourly rollups of forward attempts for the hours that
+-- had ended when it was last refreshed. A background worker refreshes it
+-- concurrently and records the end of the rolled-up hours in
+-- materialized_view_refreshes; readers add attempts from that point on
+-- straight from forward_attempts. Duration sums and counts let rollups and
+-- live attempts be averaged together.
+
+DROP MATERIALIZED VIEW IF EXISTS delivery_stats;
+
+CREATE MATERIALIZED VIEW delivery_stats AS
+SELECT
+    forwarding_rule_id,
+    DATE_TRUNC('hour', attempted_at) as hour,
+    COUNT(*) as total_attempts,
+    COUNT(*) FILTER (WHERE status = 'success') as successful,
+    COUNT(*) FILTER (WHERE status = 'failed') as failed,
+    AVG(duration_ms) FILTER (WHERE duration_ms IS NOT NULL) as avg_duration_ms,
+    MAX(duration_ms) as max_duration_ms,
+    MIN(duration_ms) as min_duration_ms,
+    COUNT(duration_ms) as duration_count,
+    COALESCE(SUM(duration_ms), 0) as total_duration_ms
+FROM forward_attempts
+WHERE attempted_at < DATE_TRUNC('hour', now())
+GROUP BY forwarding_rule_id, DATE_TRUNC('hour', attempted_at);
+
+-- Required by REFRESH MATERIALIZED VIEW CONCURRENTLY
+CREATE UNIQUE INDEX IF NOT EXISTS idx_delivery_stats_rule_hour ON delivery_stats(forwarding_rule_id, hour);
+CREATE INDEX IF NOT EXISTS idx_delivery_stats_hour ON delivery_stats(hour DESC);
+
+CREATE TABLE IF NOT EXISTS materialized_view_refreshes (
+    view_name TEXT PRIMARY KEY,
+    refreshed_through TIMESTAMPTZ NOT NULL, -- Rows before this time are rolled up
+    refreshed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO materialized_view_refreshes (view_name, refreshed_through)
+VALUES ('delivery_stats', DATE_TRUNC('hour', now()))
+ON CONFLICT (view_name) DO UPDATE SET refreshed_through = EXCLUDED.refreshed_through, refreshed_at = now();
@@ -0,0 +1,3 @@
+-- Reverts 023_correlation_ids
+ALTER TABLE forward_attempts DROP COLUMN IF EXISTS correlation_id;
+ALTER TABLE requests DROP COLUMN IF EXISTS correlation_id;
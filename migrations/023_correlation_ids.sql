@@ -0,0 +1,6 @@
+-- Migration: Correlation IDs
+-- Captured requests store the X-Request-ID of the capture, and forward
+-- attempts the correlation ID of the request or background job run that made
+-- them, so records can be matched with log lines. Earlier rows have none.
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS correlation_id TEXT;
+ALTER TABLE forward_attempts ADD COLUMN IF NOT EXISTS correlation_id TEXT;
@@ -0,0 +1,4 @@
+-- Reverts 024_bulk_replay_leases
+DELETE FROM bulk_replay_items WHERE status = 'in_flight';
+ALTER TABLE bulk_replays DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE bulk_replays DROP COLUMN IF EXISTS runner_id;
@@ -0,0 +1,7 @@
+-- Migration: Bulk replay leases
+-- A bulk replay is run by the process holding its lease, which it renews
+-- while running, so replicas do not replay the same job twice and a job
+-- whose process died is picked up once its lease expires. Items are
+-- recorded as in_flight before their request is sent.
+ALTER TABLE bulk_replays ADD COLUMN IF NOT EXISTS runner_id TEXT;
+ALTER TABLE bulk_replays ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+-- Reverts 025_export_job_leases
+ALTER TABLE export_jobs DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE export_jobs DROP COLUMN IF EXISTS runner_id;
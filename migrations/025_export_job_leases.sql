@@ -0,0 +1,6 @@
+-- Migration: Export job leases
+-- An export job is held by the process writing it, which renews the lease
+-- while the job is pending or running. Only jobs whose lease has expired, as
+-- their process stopped, are failed as interrupted.
+ALTER TABLE export_jobs ADD COLUMN IF NOT EXISTS runner_id TEXT;
+ALTER TABLE export_jobs ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
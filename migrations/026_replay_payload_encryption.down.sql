@@ -0,0 +1,3 @@
+-- Reverts 026_replay_payload_encryption. Encrypted replays lose their headers
+-- and body.
+ALTER TABLE replays DROP COLUMN IF EXISTS encrypted_payload;
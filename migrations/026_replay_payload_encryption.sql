@@ -0,0 +1,5 @@
+-- Migration: Replay payload encryption
+-- Replays of requests on endpoints with encrypt_payloads keep the headers and
+-- body they send in encrypted_payload, like the requests themselves, instead
+-- of a plaintext copy in headers and body.
+ALTER TABLE replays ADD COLUMN IF NOT EXISTS encrypted_payload TEXT;
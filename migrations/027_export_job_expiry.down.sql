@@ -0,0 +1,3 @@
+-- Reverts 027_export_job_expiry
+DROP INDEX IF EXISTS idx_export_jobs_expires_at;
+ALTER TABLE export_jobs DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,10 @@
+-- Migration: Export job expiry
+-- Export files are plaintext copies of captured requests, so finished jobs
+-- expire: the export worker deletes them and their files once expires_at
+-- passes. Jobs finished before this migration keep their file for a day.
+ALTER TABLE export_jobs ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+UPDATE export_jobs SET expires_at = COALESCE(completed_at, created_at) + interval '24 hours'
+WHERE expires_at IS NULL AND status IN ('completed', 'failed');
+
+CREATE INDEX IF NOT EXISTS idx_export_jobs_expires_at ON export_jobs(expires_at) WHERE expires_at IS NOT NULL;
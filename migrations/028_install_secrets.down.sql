@@ -0,0 +1,2 @@
+-- Reverts 028_install_secrets
+DROP TABLE IF EXISTS install_secrets;
@@ -0,0 +1,9 @@
+-- Migration: Install secrets
+-- Random secrets shared by every replica of an install, such as the key
+-- redaction hashes are computed with. Values are encrypted under the master
+-- key when one is configured and re-encrypted by key rotation.
+CREATE TABLE IF NOT EXISTS install_secrets (
+    name VARCHAR(64) PRIMARY KEY,
+    value TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now()
+);
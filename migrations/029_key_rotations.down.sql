@@ -0,0 +1,2 @@
+-- Reverts 029_key_rotations
+DROP TABLE IF EXISTS key_rotations;
@@ -0,0 +1,17 @@
+-- Migration: Key rotations
+-- Master key rotations are recorded so their progress is visible from every
+-- replica. The replica running one holds an advisory lock, so only one runs at
+-- a time, and renews its lease while it works; a rotation whose lease expired
+-- was interrupted.
+CREATE TABLE IF NOT EXISTS key_rotations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    key_id TEXT NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'running', -- running|completed|failed
+    rotated JSONB NOT NULL DEFAULT '{}'::jsonb,
+    error_message TEXT,
+    lease_expires_at TIMESTAMPTZ,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_key_rotations_started ON key_rotations(started_at DESC);
@@ -0,0 +1,12 @@
+// Package migrations embeds the SQL migrations applied by the db package.
+//
+// NNN_name.sql files are applied in version order; an optional
+// NNN_name.down.sql reverts the migration of the same version. Applied
+// migrations must not be edited: their checksums are recorded, and the server
+// refuses to start when a file no longer matches.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS